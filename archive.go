@@ -0,0 +1,356 @@
+package paperboy
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	// pure Go SQLite driver, so the archive doesn't need cgo.
+	_ "modernc.org/sqlite"
+)
+
+// archiveSchema creates the tables used by an Archive. Times are stored as
+// RFC 3339 text in UTC so SQLite's date functions can be used in reports.
+const archiveSchema = `
+CREATE TABLE IF NOT EXISTS sources (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	url  TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS items (
+	id         INTEGER PRIMARY KEY,
+	url        TEXT NOT NULL UNIQUE,
+	title      TEXT NOT NULL,
+	source_id  INTEGER NOT NULL REFERENCES sources(id),
+	first_seen TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sightings (
+	id        INTEGER PRIMARY KEY,
+	item_id   INTEGER NOT NULL REFERENCES items(id),
+	source_id INTEGER NOT NULL REFERENCES sources(id),
+	seen_at   TEXT NOT NULL,
+	position  INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS reads (
+	id       INTEGER PRIMARY KEY,
+	item_id  INTEGER NOT NULL REFERENCES items(id),
+	consumer TEXT NOT NULL DEFAULT '',
+	read_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sightings_item ON sightings(item_id);
+CREATE INDEX IF NOT EXISTS reads_item ON reads(item_id);
+`
+
+// archiveColumn is a column added to a table after the table was first
+// released, archives created before then get it on open.
+type archiveColumn struct {
+	table, name, decl string
+}
+
+var archiveColumns = []archiveColumn{
+	{"reads", "consumer", "TEXT NOT NULL DEFAULT ''"},
+	{"sightings", "position", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// Archive is a SQLite database that keeps every item a Bot has seen, when it
+// was seen and when it was read.
+type Archive struct {
+	db *sql.DB
+	// ro is a read-only handle on the same file for Query, so the SQL it
+	// runs can't change the archive however it's written.
+	ro *sql.DB
+}
+
+// QueryResult holds the rows returned by Archive.Query.
+type QueryResult struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// OpenArchive opens, or creates, the archive database at path.
+func OpenArchive(path string) (*Archive, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows one writer, sharing a connection avoids
+	// "database is locked" errors between the poller and the front ends.
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec(archiveSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	for _, column := range archiveColumns {
+		if err = addColumn(db, column); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	ro, err := sql.Open("sqlite", readOnlyURI(path))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Archive{db: db, ro: ro}, nil
+}
+
+// readOnlyURI returns a URI that opens the database at path read-only,
+// waiting for the poller's writes instead of failing while it's writing.
+func readOnlyURI(path string) string {
+	escape := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+	return "file:" + escape.Replace(path) + "?mode=ro&_pragma=busy_timeout(5000)"
+}
+
+// addColumn adds column to its table unless the table already has it.
+func addColumn(db *sql.DB, column archiveColumn) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, column.table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column.name {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s",
+		column.table, column.name, column.decl))
+	return err
+}
+
+// Close closes the underlying database.
+func (a *Archive) Close() error {
+	a.ro.Close()
+	return a.db.Close()
+}
+
+func archiveTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// sourceID returns the id of the named source, creating it if needed.
+func sourceID(tx *sql.Tx, name, url string) (id int64, err error) {
+	_, err = tx.Exec(`INSERT INTO sources (name, url) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET url = excluded.url
+		WHERE excluded.url != ''`, name, url)
+	if err != nil {
+		return
+	}
+	err = tx.QueryRow(`SELECT id FROM sources WHERE name = ?`, name).Scan(&id)
+	return
+}
+
+// itemID returns the id of item, creating it if needed.
+func itemID(tx *sql.Tx, item Item, srcID int64, seen time.Time) (id int64, err error) {
+	_, err = tx.Exec(`INSERT INTO items (url, title, source_id, first_seen)
+		VALUES (?, ?, ?, ?) ON CONFLICT(url) DO NOTHING`,
+		item.URL, item.Title, srcID, archiveTime(seen))
+	if err != nil {
+		return
+	}
+	err = tx.QueryRow(`SELECT id FROM items WHERE url = ?`, item.URL).Scan(&id)
+	return
+}
+
+// RecordSources stores the name and URL of each source.
+func (a *Archive) RecordSources(sources []Source) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if _, err = sourceID(tx, source.Name, source.URL); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecordSightings stores items that were returned by a poll at seen. Items
+// that are new to the archive are added, every item gets a sighting with
+// its position on the page.
+func (a *Archive) RecordSightings(items []Item, seen time.Time) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		srcID, err := sourceID(tx, item.SourceName, "")
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		id, err := itemID(tx, item, srcID, seen)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO sightings (item_id, source_id, seen_at, position)
+			VALUES (?, ?, ?, ?)`, id, srcID, archiveTime(seen), item.Position)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecordReads stores a read event by consumer for each of items.
+func (a *Archive) RecordReads(consumer string, items []Item, read time.Time) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		srcID, err := sourceID(tx, item.SourceName, "")
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		id, err := itemID(tx, item, srcID, read)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO reads (item_id, consumer, read_at)
+			VALUES (?, ?, ?)`, id, consumer, archiveTime(read))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Query runs a read-only SQL query against the archive. The query must be
+// a single SELECT, WITH or VALUES statement, and it's run on a read-only
+// connection, so statements that try to modify the database fail.
+func (a *Archive) Query(query string, args ...interface{}) (*QueryResult, error) {
+	if err := checkArchiveQuery(query); err != nil {
+		return nil, err
+	}
+
+	rows, err := a.ro.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &QueryResult{Rows: make([][]interface{}, 0)}
+	if result.Columns, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(result.Columns))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		// text columns may come back as []byte, which would be base64
+		// encoded by encoding/json.
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, rows.Err()
+}
+
+// checkArchiveQuery makes sure query is a single statement that only reads,
+// ATTACH and PRAGMA included.
+func checkArchiveQuery(query string) error {
+	// split the query into statements without their comments, keeping
+	// quoted names and strings as they are.
+	var statements []string
+	var stmt strings.Builder
+	endStatement := func() {
+		if text := strings.TrimSpace(stmt.String()); text != "" {
+			statements = append(statements, text)
+		}
+		stmt.Reset()
+	}
+	for i := 0; i < len(query); i++ {
+		quoted := -1
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			quoted = strings.IndexByte(query[i+1:], c)
+		case c == '[':
+			quoted = strings.IndexByte(query[i+1:], ']')
+		case strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			i += j
+			stmt.WriteByte(' ')
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query) - i - 3
+			}
+			i += j + 3
+			stmt.WriteByte(' ')
+			continue
+		case c == ';':
+			endStatement()
+			continue
+		default:
+			stmt.WriteByte(c)
+			continue
+		}
+
+		j := len(query)
+		if quoted >= 0 {
+			j = i + quoted + 2
+		}
+		stmt.WriteString(query[i:j])
+		i = j - 1
+	}
+	endStatement()
+
+	switch len(statements) {
+	case 0:
+		return fmt.Errorf("empty query")
+	case 1:
+	default:
+		return fmt.Errorf("only one statement can be run at a time")
+	}
+	keyword := strings.Fields(strings.TrimLeft(statements[0], "( \t\r\n"))
+	if len(keyword) > 0 {
+		switch strings.ToUpper(keyword[0]) {
+		case "SELECT", "WITH", "VALUES":
+			return nil
+		}
+	}
+	return fmt.Errorf("only SELECT queries can be run, not %s", strings.Fields(statements[0])[0])
+}
@@ -0,0 +1,40 @@
+package paperboy
+
+import "testing"
+
+func TestCheckArchiveQuery(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		ok    bool
+	}{
+		{"SELECT * FROM items", true},
+		{"select title from items;", true},
+		{"  (SELECT 1)", true},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", true},
+		{"VALUES (1), (2)", true},
+		{"-- newest first\nSELECT * FROM items ORDER BY first_seen DESC", true},
+		{"/* by source */ SELECT source, count(*) FROM items GROUP BY source", true},
+		{"SELECT * FROM items; -- all of them", true},
+		{"SELECT * FROM items; /* all */ ;", true},
+		{"SELECT 'a;b', \"x;y\", [z;w] FROM items", true},
+		{"SELECT '--not a comment' FROM items", true},
+
+		{"", false},
+		{"-- just a comment", false},
+		{";", false},
+		{"SELECT 1; SELECT 2", false},
+		{"SELECT 1; -- two\nDELETE FROM items", false},
+		{"SELECT 1 /* ; */; DROP TABLE items", false},
+		{"DELETE FROM items", false},
+		{"-- looks harmless\nDELETE FROM items", false},
+		{"/* SELECT */ UPDATE items SET title = ''", false},
+		{"PRAGMA query_only = 0", false},
+		{"ATTACH DATABASE 'x.db' AS x", false},
+		{"SELECT 1; ATTACH DATABASE 'x.db' AS x", false},
+	} {
+		err := checkArchiveQuery(tt.query)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkArchiveQuery(%q) = %v, want ok %v", tt.query, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package paperboy
+
+import (
+	"fmt"
+	"github.com/google/logger"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AutosavePolicy controls when a Bot saves its items to disk.
+type AutosavePolicy struct {
+	// Path of the snapshot file, autosaving is disabled when it's empty.
+	Path string `yaml:"path"`
+	// Interval between saves while the Bot is running, zero disables
+	// periodic saves.
+	Interval time.Duration `yaml:"interval"`
+	// Changes is the number of changes to the Bot's items that trigger a
+	// save, zero disables saving on changes.
+	Changes int `yaml:"changes"`
+	// Backups is the number of previous snapshots kept as Path.1, Path.2
+	// and so on, Path.1 being the most recent.
+	Backups int `yaml:"backups"`
+}
+
+// backupPath returns the name of the nth backup of path.
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateBackups shifts path.1 to path.2 and so on, dropping the oldest, and
+// makes path.1 a copy of path. path itself is left alone, so there's always
+// a snapshot to restore even if the new one never replaces it.
+func rotateBackups(path string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+
+	for n := backups - 1; n >= 1; n-- {
+		err := os.Rename(backupPath(path, n), backupPath(path, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	first := backupPath(path, 1)
+	if err := os.Remove(first); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err := os.Link(path, first)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		// not every file system has hard links.
+		return copyFile(path, first)
+	}
+	return nil
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// SaveFile atomically writes a snapshot of the Bot's items to path. The
+// snapshot is written to a temporary file in the same directory which is
+// then renamed over path, so a crash never leaves a partial snapshot behind.
+// Up to backups previous snapshots are kept.
+func (b *Bot) SaveFile(path string, backups int) error {
+	return writeFileAtomic(path, backups, b.Dump)
+}
+
+// writeFileAtomic writes path with write, through a temporary file that's
+// renamed over path, keeping up to backups previous versions.
+func writeFileAtomic(path string, backups int, write func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// removing fails harmlessly once tmp has been renamed.
+	defer os.Remove(tmp.Name())
+
+	if err = write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = rotateBackups(path, backups); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadFile loads the snapshot at path into the Bot.
+func (b *Bot) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return b.Load(f)
+}
+
+// RestoreFile loads the snapshot at path, falling back to its backups when
+// path is missing or can't be loaded. It isn't an error for there to be no
+// snapshot at all.
+func (b *Bot) RestoreFile(path string, backups int) error {
+	var firstErr error
+	for n := 0; n <= backups; n++ {
+		candidate := path
+		if n > 0 {
+			candidate = backupPath(path, n)
+		}
+
+		err := b.loadFile(candidate)
+		if err == nil {
+			if firstErr != nil {
+				logger.Warningf("Restored %s, %s\n", candidate, firstErr)
+			}
+			return nil
+		}
+
+		if !os.IsNotExist(err) && firstErr == nil {
+			firstErr = fmt.Errorf("error loading %s: %s", candidate, err)
+		}
+	}
+	return firstErr
+}
+
+// Save writes a snapshot using the Bot's autosave policy. It does nothing
+// when the policy has no path.
+func (b *Bot) Save() error {
+	policy := b.autosavePolicy()
+	if policy.Path == "" {
+		return nil
+	}
+
+	b.saveMux.Lock()
+	defer b.saveMux.Unlock()
+
+	// changes made while the snapshot is written count towards the next
+	// save.
+	saved := 0
+	err := writeFileAtomic(policy.Path, policy.Backups, func(w io.Writer) error {
+		var err error
+		saved, err = b.dump(w)
+		return err
+	})
+	if err == nil {
+		b.mux.Lock()
+		b.changes -= saved
+		b.mux.Unlock()
+	}
+	return err
+}
+
+// Restore loads the most recent usable snapshot using the Bot's autosave
+// policy.
+func (b *Bot) Restore() error {
+	policy := b.autosavePolicy()
+	if policy.Path == "" {
+		return nil
+	}
+	return b.RestoreFile(policy.Path, policy.Backups)
+}
+
+// autosavePolicy returns the Bot's autosave policy, which can change when
+// the Bot is reconfigured.
+func (b *Bot) autosavePolicy() AutosavePolicy {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.Autosave
+}
+
+// changed records n changes to the Bot's items and saves if the autosave
+// policy asks for it. It must be called without holding b.mux.
+func (b *Bot) changed(n int) {
+	if n == 0 {
+		return
+	}
+
+	b.mux.Lock()
+	b.changes += n
+	due := b.Autosave.Changes > 0 && b.changes >= b.Autosave.Changes
+	b.mux.Unlock()
+
+	if due {
+		b.autosave()
+	}
+}
+
+// autosave saves and logs, rather than returns, any error.
+func (b *Bot) autosave() {
+	if err := b.Save(); err != nil {
+		logger.Errorf("Error saving to %s: %s\n", b.autosavePolicy().Path, err)
+	}
+}
@@ -0,0 +1,57 @@
+package paperboy
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	write := func(content string) {
+		t.Helper()
+		err := writeFileAtomic(path, 2, func(w io.Writer) error {
+			_, err := io.WriteString(w, content)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(file, want string) {
+		t.Helper()
+		got, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("%s has %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+
+	write("one")
+	check(path, "one")
+	if _, err := os.Stat(backupPath(path, 1)); !os.IsNotExist(err) {
+		t.Fatalf("first save made a backup: %v", err)
+	}
+	write("two")
+	write("three")
+	write("four")
+	check(path, "four")
+	check(backupPath(path, 1), "three")
+	check(backupPath(path, 2), "two")
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Fatalf("more backups than asked for: %v", err)
+	}
+
+	// a crash after rotating, before the new snapshot is in place, still
+	// leaves the last one to restore.
+	if err := rotateBackups(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	check(path, "four")
+	check(backupPath(path, 1), "four")
+	check(backupPath(path, 2), "three")
+}
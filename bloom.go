@@ -0,0 +1,167 @@
+package paperboy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// bloomFilter is a fixed size set of strings that can report false
+// positives, but never false negatives.
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint32
+}
+
+// newBloomFilter sizes a bloomFilter to hold n strings with a false positive
+// rate of p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	return &bloomFilter{
+		bits:   make([]uint64, (int(m)+63)/64),
+		hashes: uint32(k),
+	}
+}
+
+// locations returns the bit positions for s, using double hashing of the two
+// halves of a 64 bit FNV hash.
+func (f *bloomFilter) locations(s string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	nbits := uint64(len(f.bits) * 64)
+	locs := make([]uint64, f.hashes)
+	for i := range locs {
+		locs[i] = uint64(h1+uint32(i)*h2) % nbits
+	}
+	return locs
+}
+
+func (f *bloomFilter) add(s string) {
+	for _, loc := range f.locations(s) {
+		f.bits[loc/64] |= 1 << (loc % 64)
+	}
+}
+
+func (f *bloomFilter) has(s string) bool {
+	for _, loc := range f.locations(s) {
+		if f.bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomBucket is the filter for strings added from start until the next
+// bucket starts.
+type bloomBucket struct {
+	start  time.Time
+	filter *bloomFilter
+	// count is the number of strings added to filter.
+	count int
+}
+
+// timeBloom remembers strings for a limited time by keeping one bloomFilter
+// per time bucket and dropping buckets as they expire. It uses much less
+// memory than a map of every evicted URL.
+type timeBloom struct {
+	buckets  []bloomBucket
+	width    time.Duration
+	keep     int
+	capacity int
+}
+
+// newTimeBloom returns a timeBloom that remembers strings for at least
+// remember, in buckets of width each holding up to capacity strings. A
+// bucket that's full before its time is up is followed by another one, so
+// the false positive rate stays the same however many strings are added.
+func newTimeBloom(remember, width time.Duration, capacity int) *timeBloom {
+	keep := int(remember/width) + 1
+	return &timeBloom{width: width, keep: keep, capacity: capacity}
+}
+
+// expire drops buckets that are older than the timeBloom remembers.
+func (t *timeBloom) expire(now time.Time) {
+	oldest := now.Add(-time.Duration(t.keep) * t.width)
+	for len(t.buckets) > 0 && t.buckets[0].start.Before(oldest) {
+		t.buckets = t.buckets[1:]
+	}
+}
+
+func (t *timeBloom) add(s string, now time.Time) {
+	t.expire(now)
+
+	n := len(t.buckets)
+	if n == 0 || now.Sub(t.buckets[n-1].start) >= t.width || t.buckets[n-1].count >= t.capacity {
+		t.buckets = append(t.buckets, bloomBucket{
+			start:  now.Truncate(t.width),
+			filter: newBloomFilter(t.capacity, 0.001),
+		})
+		n++
+	}
+	t.buckets[n-1].filter.add(s)
+	t.buckets[n-1].count++
+}
+
+func (t *timeBloom) has(s string, now time.Time) bool {
+	t.expire(now)
+	for _, bucket := range t.buckets {
+		if bucket.filter.has(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// bytes returns the filter's bits, 8 little endian bytes per word.
+func (f *bloomFilter) bytes() []byte {
+	b := make([]byte, len(f.bits)*8)
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(b[i*8:], word)
+	}
+	return b
+}
+
+// bloomFilterFromBytes rebuilds a filter from the bits bytes returned.
+func bloomFilterFromBytes(b []byte, hashes uint32) (*bloomFilter, error) {
+	if len(b) == 0 || len(b)%8 != 0 || hashes == 0 {
+		return nil, fmt.Errorf("invalid bloom filter of %d bytes and %d hashes", len(b), hashes)
+	}
+	f := &bloomFilter{bits: make([]uint64, len(b)/8), hashes: hashes}
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(b[i*8:])
+	}
+	return f, nil
+}
+
+// merge adds a bucket of count strings added since start, combining it with
+// a bucket that started at the same time if the filters are the same size
+// and the two fit in one.
+func (t *timeBloom) merge(start time.Time, filter *bloomFilter, count int) {
+	i := 0
+	for i < len(t.buckets) && t.buckets[i].start.Before(start) {
+		i++
+	}
+	for j := i; j < len(t.buckets) && t.buckets[j].start.Equal(start); j++ {
+		existing := &t.buckets[j]
+		if len(existing.filter.bits) == len(filter.bits) && existing.filter.hashes == filter.hashes &&
+			existing.count+count <= t.capacity {
+			for k, word := range filter.bits {
+				existing.filter.bits[k] |= word
+			}
+			existing.count += count
+			return
+		}
+	}
+	t.buckets = append(t.buckets, bloomBucket{})
+	copy(t.buckets[i+1:], t.buckets[i:])
+	t.buckets[i] = bloomBucket{start, filter, count}
+}
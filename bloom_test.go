@@ -0,0 +1,39 @@
+package paperboy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeBloomOverCapacity(t *testing.T) {
+	const capacity, added, probes = 1000, 10000, 20000
+	tb := newTimeBloom(24*time.Hour, time.Hour, capacity)
+	now := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	// ten times the capacity in one period, like a busy poll.
+	for i := 0; i < added; i++ {
+		tb.add(fmt.Sprintf("https://example.com/added/%d", i), now)
+	}
+	for i := 0; i < added; i++ {
+		if !tb.has(fmt.Sprintf("https://example.com/added/%d", i), now) {
+			t.Fatalf("added string %d is missing", i)
+		}
+	}
+	if n := len(tb.buckets); n != added/capacity {
+		t.Errorf("%d buckets, want %d", n, added/capacity)
+	}
+
+	// each full bucket adds its 0.1% to the rate, an overfilled one would
+	// match nearly everything.
+	falsePositives := 0
+	for i := 0; i < probes; i++ {
+		if tb.has(fmt.Sprintf("https://example.com/other/%d", i), now) {
+			falsePositives++
+		}
+	}
+	want := float64(len(tb.buckets)) * 0.001
+	if rate := float64(falsePositives) / probes; rate > 2*want {
+		t.Fatalf("false positive rate is %.4f, want about %.4f", rate, want)
+	}
+}
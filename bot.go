@@ -1,171 +1,415 @@
 package paperboy
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/google/logger"
 	"io"
-	"io/ioutil"
-	"strings"
 	"sync"
 	"time"
 )
 
-// Bot is used to collect items, store items, and search for items.
+// Bot is used to collect items, store items, and search for items. Items
+// are kept in a log shared by every consumer, each with its own read
+// position.
 type Bot struct {
-	unreadItems   map[string]Item
-	sentItems     map[string]Item
+	log           []*logEntry
+	entries       map[string]*logEntry
+	nextSeq       int
+	consumers     map[string]*cursor
 	PollFrequency time.Duration
-	Sources       []Source
-	mux           sync.Mutex
-	running       bool
+	sources       []Source
+	// SourcesPath, if set, is the json file the Bot's sources are saved to
+	// whenever they change.
+	SourcesPath string
+	// Archive, if set, records every item the Bot sees and reads.
+	Archive *Archive
+	// Autosave controls when the Bot's items are saved to disk.
+	Autosave AutosavePolicy
+	// Retention controls how many items, read by every consumer, the Bot
+	// keeps.
+	Retention RetentionPolicy
+	// Ranking orders unread items, and the items of each poll sent to
+	// subscribers.
+	Ranking RankPolicy
+	// Trends configures trending term detection, changing it starts the
+	// counts over.
+	Trends TrendPolicy
+	// SubscriberBuffer is the number of items buffered for each
+	// subscriber, DefaultSubscriberBuffer when zero.
+	SubscriberBuffer int
+	// SlowSubscribers decides which items a subscriber with a full buffer
+	// loses.
+	SlowSubscribers DropPolicy
+	rules           []Rule
+	suppressed      map[string]Suppression
+	marks           map[string]map[string]*mark
+	watches         []Watch
+	alerted         map[string]map[string]bool
+	notifiers       map[string]Notifier
+	subscribers     map[*subscriber]bool
+	subMux          sync.Mutex
+	lastUsed        map[string]time.Time
+	evicted         *timeBloom
+	index           *Index
+	trends          *trendTracker
+	history         map[string][]Sighting
+	lastPolled      map[string]time.Time
+	digests         []ScheduledDigest
+	digestDue       map[string]time.Time
+	sinks           map[string]*sinkRunner
+	deliveries      []Delivery
+	mux             sync.Mutex
+	saveMux         sync.Mutex
+	changes         int
+	state           State
+	stop            chan struct{}
+	done            chan struct{}
+	// config is the Config the Bot was made or last reconfigured with.
+	config *Config
+	// reconfigured tells the polling loop its intervals may have changed.
+	reconfigured chan struct{}
+	// applyMux makes ApplyConfig apply one config at a time.
+	applyMux sync.Mutex
 }
 
 // NewBot creates a Bot instance with the default settings.
 func NewBot(sources []Source) *Bot {
 	return &Bot{
-		unreadItems:   make(map[string]Item),
-		sentItems:     make(map[string]Item),
+		entries:       make(map[string]*logEntry),
+		consumers:     make(map[string]*cursor),
 		PollFrequency: time.Duration(10) * time.Second,
-		Sources:       sources,
+		sources:       sources,
+		Retention:     DefaultRetention,
+		Ranking:       DefaultRanking,
+		Trends:        DefaultTrends,
+		lastUsed:      make(map[string]time.Time),
+		index:         NewIndex(),
 	}
 }
 
-// Start causes the Bot to start polling all sources for items.
-func (b *Bot) Start(stop chan bool) {
+// State is where a Bot is in its lifecycle.
+type State int
 
-	getItems := func() {
-		for item := range GetAll(b.Sources) {
-			if _, seen := b.sentItems[item.URL]; !seen {
-				b.mux.Lock()
-				b.unreadItems[item.URL] = item
-				b.mux.Unlock()
-			}
-		}
+const (
+	// Stopped Bots aren't polling, they can be started.
+	Stopped State = iota
+	// Running Bots poll their sources every PollFrequency.
+	Running
+	// Stopping Bots are finishing their current poll before stopping.
+	Stopping
+)
 
+func (s State) String() string {
+	switch s {
+	case Stopped:
+		return "stopped"
+	case Running:
+		return "running"
+	case Stopping:
+		return "stopping"
 	}
-	getItems()
-	go func() {
-		pollTimer := time.Tick(b.PollFrequency)
-		for {
-			select {
-			case <-pollTimer:
-				getItems()
-			case <-stop:
-				b.running = false
-				return
-			}
-		}
-	}()
-	b.running = true
+	return fmt.Sprintf("State(%d)", int(s))
 }
 
-// CacheSize provides the number of items the Bot has in memory.
-func (b *Bot) CacheSize() int {
+// Start causes the Bot to start polling all sources for items, right away
+// and then every PollFrequency, until Stop is called or ctx is done. It
+// returns without waiting for the first poll. Starting a running Bot does
+// nothing, starting a stopping one waits for it to stop first.
+func (b *Bot) Start(ctx context.Context) {
 	b.mux.Lock()
-	size := len(b.sentItems)
+	for b.state == Stopping {
+		done := b.done
+		b.mux.Unlock()
+		<-done
+		b.mux.Lock()
+	}
+	if b.state == Running {
+		b.mux.Unlock()
+		return
+	}
+
+	b.state = Running
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	b.stop, b.done = stop, done
+	if b.reconfigured == nil {
+		b.reconfigured = make(chan struct{}, 1)
+	}
+	reconfigured := b.reconfigured
 	b.mux.Unlock()
-	return size
+
+	go b.run(ctx, stop, done, reconfigured)
 }
 
-// Flush will clear cached items from the Bot's memory.
-func (b *Bot) Flush() {
+// run is the polling loop, it closes done once it has stopped. The tickers
+// are reset when something is sent on reconfigured.
+func (b *Bot) run(ctx context.Context, stop, done chan struct{}, reconfigured <-chan struct{}) {
+	defer func() {
+		b.autosave()
+		b.mux.Lock()
+		b.state = Stopped
+		b.mux.Unlock()
+		close(done)
+	}()
+
+	if b.Archive != nil {
+		if err := b.Archive.RecordSources(b.Sources()); err != nil {
+			logger.Errorf("Error archiving sources: %s\n", err)
+		}
+	}
+
+	b.poll(ctx)
+
 	b.mux.Lock()
-	b.sentItems = make(map[string]Item)
+	pollFrequency, saveInterval := b.PollFrequency, b.Autosave.Interval
+	// digests that were due while the Bot was stopped aren't sent late.
+	b.digestDue = nil
 	b.mux.Unlock()
+
+	pollTicker := time.NewTicker(pollFrequency)
+	defer pollTicker.Stop()
+
+	// a nil channel never fires, leaving periodic saves disabled.
+	var saveTicker *time.Ticker
+	var saveTimer <-chan time.Time
+	resetSaves := func() {
+		if saveTicker != nil {
+			saveTicker.Stop()
+			saveTicker, saveTimer = nil, nil
+		}
+		if saveInterval > 0 {
+			saveTicker = time.NewTicker(saveInterval)
+			saveTimer = saveTicker.C
+		}
+	}
+	resetSaves()
+
+	var digestTimer *time.Timer
+	var digestDue <-chan time.Time
+	resetDigests := func() {
+		if digestTimer != nil {
+			digestTimer.Stop()
+			digestTimer, digestDue = nil, nil
+		}
+		if next := b.nextDigest(time.Now()); !next.IsZero() {
+			digestTimer = time.NewTimer(time.Until(next))
+			digestDue = digestTimer.C
+		}
+	}
+	resetDigests()
+
+	defer func() {
+		if saveTicker != nil {
+			saveTicker.Stop()
+		}
+		if digestTimer != nil {
+			digestTimer.Stop()
+		}
+	}()
+
+	for {
+		// a stop that raced with a tick wins.
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		select {
+		case <-pollTicker.C:
+			b.poll(ctx)
+		case <-saveTimer:
+			b.autosave()
+		case <-digestDue:
+			b.sendDigests(time.Now())
+			resetDigests()
+		case <-reconfigured:
+			b.mux.Lock()
+			frequency, interval := b.PollFrequency, b.Autosave.Interval
+			b.mux.Unlock()
+			if frequency != pollFrequency {
+				pollFrequency = frequency
+				pollTicker.Reset(pollFrequency)
+			}
+			if interval != saveInterval {
+				saveInterval = interval
+				resetSaves()
+			}
+			resetDigests()
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconfigure tells the polling loop, if it's running, that its intervals
+// or digests may have changed. b.mux must be held.
+func (b *Bot) reconfigure() {
+	if b.reconfigured == nil {
+		return
+	}
+	select {
+	case b.reconfigured <- struct{}{}:
+	default:
+		// the loop hasn't picked up the last change yet.
+	}
 }
 
-// Unread will return a list of unread items and flush the pending items
-// cache.
-func (b *Bot) Unread() []Item {
+// poll gets items from every source and stores the new ones. Fetches that
+// are in flight when the Bot is stopped are finished and stored, unless ctx
+// is done.
+func (b *Bot) poll(ctx context.Context) {
+	polledAt := time.Now()
+	polled := make([]Item, 0)
+	added := make([]Item, 0)
+	for item := range GetAllContext(ctx, b.activeSources()) {
+		now := time.Now()
+		item.FirstSeen = now
+		polled = append(polled, item)
+		b.mux.Lock()
+		e, seen := b.entries[item.URL]
+		if seen && (item.Score != 0 || item.Comments != 0) {
+			// points and comments keep growing while an item is listed.
+			e.item.Score, e.item.Comments = item.Score, item.Comments
+			b.index.Update(e.item)
+		}
+		if !seen && !b.wasEvicted(item.URL, now) {
+			if rule := applyRules(b.rules, item); rule != "" {
+				b.suppress(item, rule, now)
+			} else {
+				b.appendEntry(item, false)
+				b.trackTrends(item)
+				added = append(added, item)
+			}
+		}
+		b.mux.Unlock()
+	}
 	b.mux.Lock()
+	b.recordPositions(polled, polledAt)
+	b.Ranking.Sort(added, time.Now())
+	alerts := b.watchItems(added)
+	b.mux.Unlock()
+
+	b.publish(added)
+	b.sendAlerts(alerts)
+	b.changed(len(added))
+	b.Evict()
 
-	items := make([]Item, 0)
-	for _, item := range b.unreadItems {
-		b.sentItems[item.URL] = item
-		items = append(items, item)
+	if b.Archive != nil {
+		if err := b.Archive.RecordSightings(polled, time.Now()); err != nil {
+			logger.Errorf("Error archiving items: %s\n", err)
+		}
 	}
+}
 
-	b.unreadItems = make(map[string]Item)
+// Stop stops the Bot polling and waits for it to finish its current poll
+// and save. Stopping a Bot that isn't running does nothing. Notifiers run
+// as part of a poll, so they must not call Stop.
+func (b *Bot) Stop() {
+	b.mux.Lock()
+	if b.state == Running {
+		b.state = Stopping
+		close(b.stop)
+	}
+	done := b.done
 	b.mux.Unlock()
 
-	return items
+	if done != nil {
+		<-done
+	}
 }
 
-// Search will look through the bots cache of read items for items with a
-// Title that contain sterm.
-func (b *Bot) Search(sterm string) []Item {
+// Wait blocks until the Bot stops, because of Stop or because the context
+// it was started with is done. It returns right away if the Bot isn't
+// running.
+func (b *Bot) Wait() {
 	b.mux.Lock()
+	done := b.done
+	b.mux.Unlock()
 
-	matches := make([]Item, 0)
-	for _, item := range b.sentItems {
-		if strings.Contains(strings.ToLower(item.Title), strings.ToLower(sterm)) {
-			matches = append(matches, item)
-		}
+	if done != nil {
+		<-done
 	}
-	b.mux.Unlock()
-	return matches
 }
 
-// NPending returns the number of unread items.
-func (b *Bot) NPending() int {
+// State returns where the Bot is in its lifecycle.
+func (b *Bot) State() State {
 	b.mux.Lock()
 	defer b.mux.Unlock()
-	return len(b.unreadItems)
+	return b.state
 }
 
-// IsRunning is used to determine if the bot has been started and in its
-// polling loop.
-func (b *Bot) IsRunning() bool {
-	return b.running
+// CacheSize provides the number of items the Bot has in memory.
+func (b *Bot) CacheSize() int {
+	b.mux.Lock()
+	size := len(b.entries)
+	b.mux.Unlock()
+	return size
 }
 
-// Dump all items to w encoded as json.
-func (b *Bot) Dump(w io.Writer) error {
+// Flush will clear items every consumer has read, except starred ones, from
+// the Bot's memory. The URLs of flushed items are remembered like evicted
+// ones, so they don't become unread again.
+func (b *Bot) Flush() {
 	b.mux.Lock()
-	defer b.mux.Unlock()
-
-	encodedItems, err := json.Marshal(b.sentItems)
-	if err != nil {
-		return err
+	now := time.Now()
+	flushed := 0
+	for url, e := range b.entries {
+		if b.readByAll(e) && !b.isStarred(url) {
+			b.forget(url, now)
+			flushed++
+		}
 	}
+	b.mux.Unlock()
+	b.changed(flushed)
+}
 
-	_, err = w.Write(encodedItems)
+// Search will look through the bot's read and unread items for items
+// matching query, best match first. See ParseQuery for the query syntax.
+func (b *Bot) Search(query string) ([]Item, error) {
+	return b.SearchWith(query, SearchOptions{})
+}
+
+// SearchWith searches like Search, applying opts to the query.
+func (b *Bot) SearchWith(query string, opts SearchOptions) ([]Item, error) {
+	q, err := opts.Parse(query)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
-}
 
-// Load will import items from r into the bot's memory.
-func (b *Bot) Load(r io.Reader) error {
 	b.mux.Lock()
 	defer b.mux.Unlock()
 
-	ibytes, err := ioutil.ReadAll(r)
-	if err != nil {
-		return err
-	}
-
-	tmpMap := make(map[string]Item)
-	err = json.Unmarshal(ibytes, &tmpMap)
-	if err != nil {
-		return err
+	now := time.Now()
+	matches := b.index.Match(q)
+	for _, item := range matches {
+		b.touch(item.URL, now)
 	}
+	return matches, nil
+}
 
-	for key, val := range tmpMap {
-		b.sentItems[key] = val
-	}
-	return nil
+// IsRunning is used to determine if the bot has been started and in its
+// polling loop.
+func (b *Bot) IsRunning() bool {
+	return b.State() == Running
 }
 
+// DumpAll writes every item, read or not, to w as a json list in the order
+// they were added. Unlike Dump, it's a listing of items rather than a
+// snapshot of the Bot.
 func (b *Bot) DumpAll(w io.Writer) error {
-	items := make([]Item, 0, 20)
-	for _, item := range b.sentItems {
-		items = append(items, item)
-	}
-
-	for _, item := range b.unreadItems {
-		items = append(items, item)
+	b.mux.Lock()
+	items := make([]Item, 0, len(b.log))
+	for _, e := range b.log {
+		items = append(items, e.item)
 	}
+	b.mux.Unlock()
 
 	encoded, err := json.Marshal(items)
 	if err != nil {
@@ -0,0 +1,187 @@
+package paperboy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowSource serves a page with a new item on every request, answering after
+// delay. Each request is announced on the returned channel when it starts.
+func slowSource(delay time.Duration) (*httptest.Server, <-chan int) {
+	requests := make(chan int, 100)
+	var mux sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		n++
+		i := n
+		mux.Unlock()
+		select {
+		case requests <- i:
+		default:
+		}
+		time.Sleep(delay)
+		fmt.Fprintf(w, `<html><body><a href="https://example.com/%d">Item %d</a></body></html>`, i, i)
+	}))
+	// idle connections would count as leaked goroutines.
+	srv.Config.SetKeepAlivesEnabled(false)
+	return srv, requests
+}
+
+// within fails t if f takes longer than d.
+func within(t *testing.T, d time.Duration, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("%s took longer than %s", what, d)
+	}
+}
+
+func TestBotLifecycle(t *testing.T) {
+	before := runtime.NumGoroutine()
+	srv, requests := slowSource(200 * time.Millisecond)
+
+	b := NewBot([]Source{{Name: "slow", URL: srv.URL, Selector: "a", Converter: "anchor"}})
+	b.PollFrequency = time.Hour
+	if b.State() != Stopped {
+		t.Fatalf("new Bot is %s", b.State())
+	}
+	within(t, time.Second, "stopping a Bot that isn't running", b.Stop)
+	within(t, time.Second, "waiting for a Bot that isn't running", b.Wait)
+
+	b.Start(context.Background())
+	if b.State() != Running {
+		t.Fatalf("started Bot is %s", b.State())
+	}
+	<-requests
+
+	// Stop lets the poll that's under way finish, and store its item.
+	start := time.Now()
+	b.Stop()
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("Stop returned after %s, before the poll finished", d)
+	}
+	if b.State() != Stopped {
+		t.Fatalf("stopped Bot is %s", b.State())
+	}
+	if n := b.CacheSize(); n != 1 {
+		t.Fatalf("stopped Bot has %d items, want 1", n)
+	}
+
+	within(t, time.Second, "a second Stop", b.Stop)
+	within(t, time.Second, "Wait after Stop", b.Wait)
+
+	// a stopped Bot starts again, and stops when its context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Start(ctx)
+	<-requests
+	waited := make(chan struct{})
+	go func() {
+		b.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while the Bot was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	within(t, time.Second, "Wait after the context is done", func() { <-waited })
+	if b.State() != Stopped {
+		t.Fatalf("Bot is %s after its context is done", b.State())
+	}
+
+	srv.Close()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left running, %d before:\n%s",
+				runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBotConcurrentStartStop(t *testing.T) {
+	srv, _ := slowSource(10 * time.Millisecond)
+	defer srv.Close()
+
+	b := NewBot([]Source{{Name: "slow", URL: srv.URL, Selector: "a", Converter: "anchor"}})
+	b.PollFrequency = 5 * time.Millisecond
+	for round := 0; round < 5; round++ {
+		done := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			go func() {
+				b.Start(context.Background())
+				done <- struct{}{}
+			}()
+		}
+		for i := 0; i < 4; i++ {
+			<-done
+		}
+		if b.State() != Running {
+			t.Fatalf("round %d: Bot is %s after Start", round, b.State())
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			go func() {
+				b.Stop()
+				done <- struct{}{}
+			}()
+		}
+		for i := 0; i < 3; i++ {
+			<-done
+		}
+		if b.State() != Stopped {
+			t.Fatalf("round %d: Bot is %s after Stop", round, b.State())
+		}
+	}
+}
+
+func TestBotRepollUpdatesCounts(t *testing.T) {
+	var mux sync.Mutex
+	comments := 3
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		n := comments
+		mux.Unlock()
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0" xmlns:slash="http://purl.org/rss/1.0/modules/slash/"><channel>
+<item><title>Gophers everywhere</title><link>https://example.com/gophers</link><slash:comments>%d</slash:comments></item>
+</channel></rss>`, n)
+	}))
+	defer srv.Close()
+
+	b := NewBot([]Source{{Name: "feed", URL: srv.URL, Type: FeedSource}})
+	b.poll(context.Background())
+	mux.Lock()
+	comments = 42
+	mux.Unlock()
+	b.poll(context.Background())
+
+	found, err := b.Search("gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Comments != 42 {
+		t.Fatalf("search found %+v, want the item with 42 comments", found)
+	}
+	if unread := b.Peek("alice"); len(unread) != 1 || unread[0].Comments != 42 {
+		t.Fatalf("unread items are %+v, want the item with 42 comments", unread)
+	}
+}
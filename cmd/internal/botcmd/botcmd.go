@@ -0,0 +1,125 @@
+// Package botcmd builds the commands pbcmd, pbslack and pbirc share. The
+// commands write their replies to a writer, marked up in the Style of the
+// program running them.
+package botcmd
+
+import (
+	"fmt"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"io"
+)
+
+// Style is how a program marks up the commands' replies.
+type Style struct {
+	// Program is the program's name, for replies like "pbirc wasn't
+	// started with a config file."
+	Program string
+	// Code and Bold mark up text, it's left as it is when they're nil.
+	Code func(string) string
+	Bold func(string) string
+	// Quote starts the lines shown under another one, like the example
+	// items of a trend.
+	Quote string
+	// Item writes an item on a line, as "[source] title - url" when it's
+	// nil.
+	Item func(w io.Writer, item paperboy.Item)
+	// Link returns the URL in an argument, for chats that mark links up.
+	// Arguments are taken as they are when it's nil.
+	Link func(string) string
+	// Target returns the channel in a watch's -to, for chats that mark
+	// channels up. It's taken as it is when Target is nil.
+	Target func(string) string
+	// Digest is the format digests are written in, PlainText when it's
+	// not set.
+	Digest paperboy.TextFormat
+	// Notifier is the notifier new watches alert with.
+	Notifier string
+}
+
+func (s Style) code(text string) string {
+	if s.Code == nil {
+		return text
+	}
+	return s.Code(text)
+}
+
+func (s Style) bold(text string) string {
+	if s.Bold == nil {
+		return text
+	}
+	return s.Bold(text)
+}
+
+func (s Style) link(arg string) string {
+	if s.Link == nil {
+		return arg
+	}
+	return s.Link(arg)
+}
+
+func (s Style) target(arg string) string {
+	if s.Target == nil {
+		return arg
+	}
+	return s.Target(arg)
+}
+
+func (s Style) digest() paperboy.TextFormat {
+	if s.Digest == "" {
+		return paperboy.PlainText
+	}
+	return s.Digest
+}
+
+// Builder builds commands that work on a Bot.
+type Builder struct {
+	Bot *paperboy.Bot
+	// W is where the commands write their replies, and Errors where they
+	// write errors and usage, W when it's nil.
+	W      io.Writer
+	Errors io.Writer
+	// Consumer is who items are read as, it can change between commands.
+	Consumer *string
+	// Channel is where the command running was sent from, new watches
+	// alert there unless they're given -to. It can be nil.
+	Channel *string
+	Style   Style
+}
+
+// writeItem writes item to the Builder's writer.
+func (b *Builder) writeItem(item paperboy.Item) {
+	if b.Style.Item != nil {
+		b.Style.Item(b.W, item)
+		return
+	}
+	fmt.Fprintf(b.W, "[%s] %s - %s\n", item.SourceName, item.Title, item.URL)
+}
+
+// errorf writes an error to the Builder's Errors.
+func (b *Builder) errorf(format string, args ...interface{}) {
+	w := b.Errors
+	if w == nil {
+		w = b.W
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// usage writes how c is used.
+func (b *Builder) usage(c *commands.Command) {
+	b.errorf("usage: %s\n", b.Style.code(c.Usage))
+}
+
+// consumer returns who items are read as.
+func (b *Builder) consumer() string {
+	return *b.Consumer
+}
+
+// channel returns where the command running was sent from, empty if the
+// Builder doesn't know.
+func (b *Builder) channel() string {
+	if b.Channel == nil {
+		return ""
+	}
+	return *b.Channel
+}
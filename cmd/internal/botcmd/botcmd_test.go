@@ -0,0 +1,78 @@
+package botcmd
+
+import (
+	"bytes"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"strings"
+	"testing"
+)
+
+func run(c *commands.Command, args ...string) {
+	c.Run(c, args)
+}
+
+func TestBuilder(t *testing.T) {
+	var out, errs bytes.Buffer
+	consumer, channel := "alice", "<#C024|general>"
+	unwrap := func(s string) string {
+		return strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(s, "<#"), "<"), ">")
+	}
+	b := &Builder{
+		Bot:      paperboy.NewBot(nil),
+		W:        &out,
+		Errors:   &errs,
+		Consumer: &consumer,
+		Channel:  &channel,
+		Style: Style{
+			Program:  "pbtest",
+			Code:     func(s string) string { return "`" + s + "`" },
+			Link:     unwrap,
+			Target:   func(s string) string { return strings.Split(unwrap(s), "|")[0] },
+			Notifier: "chat",
+		},
+	}
+
+	// arguments are unwrapped with the Style.
+	run(b.AddSourceCommand(), "-feed", "blog", "<https://example.com/feed>")
+	sources := b.Bot.Sources()
+	if len(sources) != 1 || sources[0].URL != "https://example.com/feed" || sources[0].Type != paperboy.FeedSource {
+		t.Fatalf("sources are %+v", sources)
+	}
+	if got := out.String(); got != "Added blog.\n" {
+		t.Fatalf("wrote %q", got)
+	}
+
+	// watches alert the channel they were added in with the Style's
+	// notifier, and flags are reset between runs.
+	run(b.WatchCommand(), "-to", "#other", "go", "golang")
+	watch := b.WatchCommand()
+	run(watch, "-fuzzy", "rust", "rust")
+	run(watch, "zig", "zig")
+	want := map[string]paperboy.Watch{
+		"go":   {Name: "go", Query: "golang", Notifier: "chat", To: "#other"},
+		"rust": {Name: "rust", Query: "rust", Notifier: "chat", To: "C024", Fuzzy: true},
+		"zig":  {Name: "zig", Query: "zig", Notifier: "chat", To: "C024"},
+	}
+	watches := b.Bot.Watches()
+	if len(watches) != len(want) {
+		t.Fatalf("watches are %+v", watches)
+	}
+	for _, w := range watches {
+		wt := want[w.Name]
+		if w.Query != wt.Query || w.Notifier != wt.Notifier || w.To != wt.To || w.Fuzzy != wt.Fuzzy {
+			t.Errorf("watch %s is %s to %s, want %s to %s", w.Name, w, w.To, wt, wt.To)
+		}
+	}
+
+	// errors and usage go to Errors, marked up.
+	out.Reset()
+	run(b.UnwatchCommand())
+	run(b.ReloadRulesCommand(""))
+	if out.Len() != 0 {
+		t.Errorf("wrote %q", out.String())
+	}
+	if got, want := errs.String(), "usage: `unwatch <name>`\npbtest wasn't started with a rules file.\n"; got != want {
+		t.Errorf("wrote errors %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,274 @@
+package botcmd
+
+// Commands that run the bot and show its items.
+
+import (
+	"context"
+	"fmt"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReloadCommand reloads the config file the program was started with and
+// reports what changed.
+func (b *Builder) ReloadCommand(config *paperboy.Config) *commands.Command {
+	return &commands.Command{
+		Name:  "reload",
+		Short: "Reload the config file and apply its changes.",
+		Usage: "reload",
+		Run: func(*commands.Command, []string) {
+			if config.Path() == "" {
+				b.errorf("%s wasn't started with a config file.\n", b.Style.Program)
+				return
+			}
+			next, err := config.Reload()
+			if err != nil {
+				b.errorf("Error reloading the config, nothing changed:\n%s\n", b.Style.code(err.Error()))
+				return
+			}
+			changes, err := b.Bot.ApplyConfig(next)
+			if err != nil {
+				b.errorf("Error applying the config: %s\n", err)
+				return
+			}
+			fmt.Fprintln(b.W, changes)
+		},
+	}
+}
+
+func (b *Builder) StartCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "start",
+		Short: "Start polling sources for items.",
+		Usage: "start",
+		Run: func(*commands.Command, []string) {
+			if b.Bot.IsRunning() {
+				fmt.Fprintln(b.W, "Bot already running.")
+				return
+			}
+			b.Bot.Start(context.Background())
+			fmt.Fprintln(b.W, "Bot started.")
+		},
+	}
+}
+
+func (b *Builder) StopCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "stop",
+		Short: "Stop polling sources for items.",
+		Usage: "stop",
+		Run: func(*commands.Command, []string) {
+			if !b.Bot.IsRunning() {
+				fmt.Fprintln(b.W, "Bot isn't running.")
+				return
+			}
+			b.Bot.Stop()
+			fmt.Fprintln(b.W, "Bot stopped.")
+		},
+	}
+}
+
+func (b *Builder) StatusCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "status",
+		Short: "Show how many items are cached and unread.",
+		Usage: "status",
+		Run: func(*commands.Command, []string) {
+			fmt.Fprintf(b.W, "%d items.\n%d unread items.\n", b.Bot.CacheSize(), b.Bot.NPending(b.consumer()))
+			if b.Bot.IsRunning() {
+				fmt.Fprintln(b.W, "Bot is running.")
+			}
+		},
+	}
+}
+
+func (b *Builder) ShowCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "show",
+		Short: "Show unread items.",
+		Usage: "show",
+		Run: func(*commands.Command, []string) {
+			for _, item := range b.Bot.Unread(b.consumer()) {
+				b.writeItem(item)
+			}
+		},
+	}
+}
+
+func (b *Builder) PeekCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "peek",
+		Short: "Show unread items without marking them read.",
+		Usage: "peek",
+		Run: func(*commands.Command, []string) {
+			for _, item := range b.Bot.Peek(b.consumer()) {
+				b.writeItem(item)
+			}
+		},
+	}
+}
+
+func (b *Builder) SearchCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "search",
+		Short: "Search items by title, source:, domain:, before: and after:.",
+		Usage: "search [-fuzzy] <query>",
+	}
+
+	var opts paperboy.SearchOptions
+	c.Flags.BoolVar(&opts.Fuzzy, "fuzzy", false, "Match words with typos.")
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		args = c.Flags.Args()
+		// reset, flags keep their values between runs.
+		defer func() { opts = paperboy.SearchOptions{} }()
+
+		if len(args) == 0 {
+			b.usage(c)
+			return
+		}
+
+		sterm := strings.Join(args, " ")
+		results, err := b.Bot.SearchWith(sterm, opts)
+		if err != nil {
+			b.errorf("invalid query %s: %s\n", b.Style.code(sterm), err)
+			return
+		}
+		fmt.Fprintf(b.W, "Showing results for %s\n", b.Style.code(sterm))
+		for _, result := range results {
+			b.writeItem(result)
+		}
+	}
+	return c
+}
+
+func (b *Builder) TrendingCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "trending",
+		Short: "Show the terms rising the most in new titles, with example items.",
+		Usage: "trending [n]",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		n := 5
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+				b.usage(c)
+				return
+			}
+		}
+
+		trends := b.Bot.Trending(n)
+		if len(trends) == 0 {
+			fmt.Fprintln(b.W, "Nothing is trending.")
+		}
+		for _, trend := range trends {
+			fmt.Fprintf(b.W, "%s: %d items, %.1f expected, on %s\n",
+				b.Style.bold(trend.Term), trend.Count, trend.Expected, strings.Join(trend.Sources, ", "))
+			for _, item := range trend.Examples {
+				fmt.Fprint(b.W, b.Style.Quote)
+				b.writeItem(item)
+			}
+		}
+	}
+	return c
+}
+
+// DigestCommand shows the best items of the last day, grouped by source or
+// by topic.
+func (b *Builder) DigestCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "digest",
+		Short: "Show the best items of the last day, grouped by source or topic.",
+		Usage: "digest [-n count] [-window duration] [-by source|topic] [-format text|markdown|html|slack] [-source name] [-q query]",
+	}
+
+	var formatName, source string
+	var opts paperboy.DigestOptions
+	by := string(paperboy.BySource)
+	c.Flags.IntVar(&opts.Limit, "n", paperboy.DefaultDigestLimit, "Number of items.")
+	c.Flags.DurationVar(&opts.Window, "window", paperboy.DefaultDigestWindow, "How far back the digest goes.")
+	c.Flags.StringVar(&by, "by", by, "Group items by source or topic.")
+	c.Flags.StringVar(&formatName, "format", string(b.Style.digest()), "Format, text, markdown, html or slack.")
+	c.Flags.StringVar(&source, "source", "", "Only include items from this source.")
+	c.Flags.StringVar(&opts.Query, "q", "", "Only include items matching this search.")
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		// reset, flags keep their values between runs.
+		name, s, g, o := formatName, source, by, opts
+		formatName, source, by = string(b.Style.digest()), "", string(paperboy.BySource)
+		opts = paperboy.DigestOptions{Limit: paperboy.DefaultDigestLimit, Window: paperboy.DefaultDigestWindow}
+
+		format, err := paperboy.ParseTextFormat(name)
+		if err != nil || len(c.Flags.Args()) > 0 {
+			b.usage(c)
+			return
+		}
+		o.GroupBy = paperboy.DigestGrouping(g)
+		if s != "" {
+			o.Sources = []string{s}
+		}
+
+		digest, err := b.Bot.Digest(o)
+		if err != nil {
+			b.errorf("Error building the digest: %s\n", err)
+			return
+		}
+		fmt.Fprint(b.W, digest.Render(format))
+	}
+	return c
+}
+
+// HistoryCommand shows where an item has been on its sources' pages, the
+// item can be given by URL or as a search for it.
+func (b *Builder) HistoryCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "history",
+		Short: "Show where an item has been on its sources' pages.",
+		Usage: "history <url|query>",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) == 0 {
+			b.usage(c)
+			return
+		}
+
+		url := b.Style.link(args[0])
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			results, err := b.Bot.Search(strings.Join(args, " "))
+			if err != nil {
+				b.errorf("%s\n", err)
+				return
+			}
+			if len(results) == 0 {
+				b.errorf("No items found.\n")
+				return
+			}
+			url = results[0].URL
+		}
+
+		h, err := b.Bot.History(url)
+		if err != nil {
+			b.errorf("%s\n", err)
+			return
+		}
+
+		b.writeItem(h.Item)
+		for _, source := range h.Sources() {
+			fmt.Fprintf(b.W, "%s: peaked at #%d, on the page for %s\n",
+				b.Style.bold(source), h.Peak[source], h.OnPage[source].Round(time.Second))
+		}
+		for _, s := range h.Sightings {
+			fmt.Fprintf(b.W, "%s%s #%d from %s to %s\n", b.Style.Quote, s.Source, s.Position,
+				s.From.Format(time.Stamp), s.To.Format(time.Stamp))
+		}
+	}
+	return c
+}
@@ -0,0 +1,167 @@
+package botcmd
+
+// Commands that star, tag and save items for later.
+
+import (
+	"fmt"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"sort"
+	"strings"
+)
+
+// urlCommand builds a command that runs do with the URL it's given.
+func (b *Builder) urlCommand(name, short string, do func(url string) error) *commands.Command {
+	c := &commands.Command{
+		Name:  name,
+		Short: short,
+		Usage: name + " <url>",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) != 1 {
+			b.usage(c)
+			return
+		}
+		if err := do(b.Style.link(args[0])); err != nil {
+			b.errorf("%s\n", err)
+			return
+		}
+		fmt.Fprintln(b.W, "Done.")
+	}
+	return c
+}
+
+// listCommand builds a command that writes the items list returns.
+func (b *Builder) listCommand(name, short string, list func() []paperboy.Item) *commands.Command {
+	return &commands.Command{
+		Name:  name,
+		Short: short,
+		Usage: name,
+		Run: func(*commands.Command, []string) {
+			items := list()
+			if len(items) == 0 {
+				fmt.Fprintln(b.W, "No items.")
+			}
+			for _, item := range items {
+				b.writeItem(item)
+			}
+		},
+	}
+}
+
+func (b *Builder) StarCommand() *commands.Command {
+	return b.urlCommand("star", "Star an item, starred items are kept in memory.", func(url string) error {
+		return b.Bot.Star(b.consumer(), url)
+	})
+}
+
+func (b *Builder) UnstarCommand() *commands.Command {
+	return b.urlCommand("unstar", "Remove the star from an item.", func(url string) error {
+		if !b.Bot.Unstar(b.consumer(), url) {
+			return fmt.Errorf("%s isn't starred", url)
+		}
+		return nil
+	})
+}
+
+func (b *Builder) StarredCommand() *commands.Command {
+	return b.listCommand("starred", "Show your starred items.", func() []paperboy.Item {
+		return b.Bot.Starred(b.consumer())
+	})
+}
+
+func (b *Builder) LaterCommand() *commands.Command {
+	return b.urlCommand("later", "Add an item to your read later list.", func(url string) error {
+		return b.Bot.AddReadLater(b.consumer(), url)
+	})
+}
+
+func (b *Builder) UnlaterCommand() *commands.Command {
+	return b.urlCommand("unlater", "Remove an item from your read later list.", func(url string) error {
+		if !b.Bot.RemoveReadLater(b.consumer(), url) {
+			return fmt.Errorf("%s isn't on your read later list", url)
+		}
+		return nil
+	})
+}
+
+func (b *Builder) ReadLaterCommand() *commands.Command {
+	return b.listCommand("readlater", "Show your read later list.", func() []paperboy.Item {
+		return b.Bot.ReadLater(b.consumer())
+	})
+}
+
+func (b *Builder) TagCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "tag",
+		Short: "Tag an item.",
+		Usage: "tag <url> <tag>...",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) < 2 {
+			b.usage(c)
+			return
+		}
+		url := b.Style.link(args[0])
+		if err := b.Bot.Tag(b.consumer(), url, args[1:]...); err != nil {
+			b.errorf("%s\n", err)
+			return
+		}
+		fmt.Fprintf(b.W, "Tagged %s\n", strings.Join(b.Bot.ItemTags(b.consumer(), url), ", "))
+	}
+	return c
+}
+
+func (b *Builder) UntagCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "untag",
+		Short: "Remove tags from an item.",
+		Usage: "untag <url> <tag>...",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) < 2 {
+			b.usage(c)
+			return
+		}
+		n := b.Bot.Untag(b.consumer(), b.Style.link(args[0]), args[1:]...)
+		fmt.Fprintf(b.W, "Removed %d tags.\n", n)
+	}
+	return c
+}
+
+// TagsCommand lists the tags in use, or the items with a tag.
+func (b *Builder) TagsCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "tags",
+		Short: "List your tags, or the items with a tag.",
+		Usage: "tags [tag]",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		switch len(args) {
+		case 0:
+			counts := b.Bot.Tags(b.consumer())
+			if len(counts) == 0 {
+				fmt.Fprintln(b.W, "No tags.")
+			}
+			tags := make([]string, 0, len(counts))
+			for tag := range counts {
+				tags = append(tags, tag)
+			}
+			sort.Strings(tags)
+			for _, tag := range tags {
+				fmt.Fprintf(b.W, "%s (%d)\n", tag, counts[tag])
+			}
+		case 1:
+			for _, item := range b.Bot.Tagged(b.consumer(), args[0]) {
+				b.writeItem(item)
+			}
+		default:
+			b.usage(c)
+		}
+	}
+	return c
+}
@@ -0,0 +1,141 @@
+package botcmd
+
+// Commands that manage the bot's filtering rules.
+
+import (
+	"fmt"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"os"
+)
+
+// LoadRules replaces the bot's rules with the ones in the json file at path.
+func LoadRules(b *paperboy.Bot, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rules, err := paperboy.ReadRules(f)
+	if err != nil {
+		return err
+	}
+	return b.SetRules(rules)
+}
+
+func (b *Builder) RulesCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "rules",
+		Short: "List the rules items are filtered with.",
+		Usage: "rules",
+		Run: func(*commands.Command, []string) {
+			rules := b.Bot.Rules()
+			if len(rules) == 0 {
+				fmt.Fprintln(b.W, "No rules.")
+			}
+			for _, rule := range rules {
+				fmt.Fprintln(b.W, b.Style.code(rule.String()))
+			}
+		},
+	}
+}
+
+func (b *Builder) AddRuleCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "addrule",
+		Short: "Add or replace a rule that includes or excludes new items.",
+		Usage: "addrule [-exclude] [-source name] [-keyword word] [-regex re] [-domain name] [-min-score n] <name>",
+	}
+
+	var rule paperboy.Rule
+	var exclude bool
+	c.Flags.BoolVar(&exclude, "exclude", false, "Drop matching items, instead of keeping only matching items.")
+	c.Flags.StringVar(&rule.Source, "source", "", "Only apply the rule to items from this source.")
+	c.Flags.StringVar(&rule.Keyword, "keyword", "", "Match titles containing this word.")
+	c.Flags.StringVar(&rule.Regex, "regex", "", "Match titles with this regular expression.")
+	c.Flags.StringVar(&rule.Domain, "domain", "", "Match items linking to this domain.")
+	c.Flags.IntVar(&rule.MinScore, "min-score", 0, "Match items with at least this score, needs -source.")
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		args = c.Flags.Args()
+		// reset, flags keep their values between runs.
+		r, excl := rule, exclude
+		rule, exclude = paperboy.Rule{}, false
+
+		if len(args) != 1 {
+			b.usage(c)
+			return
+		}
+
+		r.Name = args[0]
+		r.Action = paperboy.Include
+		if excl {
+			r.Action = paperboy.Exclude
+		}
+
+		if err := b.Bot.AddRule(r); err != nil {
+			b.errorf("%s\n", err)
+			return
+		}
+		fmt.Fprintf(b.W, "Added %s\n", b.Style.code(r.String()))
+	}
+	return c
+}
+
+func (b *Builder) RemoveRuleCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "rmrule",
+		Short: "Remove a rule.",
+		Usage: "rmrule <name>",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) != 1 {
+			b.usage(c)
+			return
+		}
+		if !b.Bot.RemoveRule(args[0]) {
+			b.errorf("No rule named %s.\n", args[0])
+			return
+		}
+		fmt.Fprintf(b.W, "Removed %s.\n", args[0])
+	}
+	return c
+}
+
+// ReloadRulesCommand reloads the rules from the file the program was
+// started with.
+func (b *Builder) ReloadRulesCommand(path string) *commands.Command {
+	return &commands.Command{
+		Name:  "reloadrules",
+		Short: "Reload the rules from the rules file.",
+		Usage: "reloadrules",
+		Run: func(*commands.Command, []string) {
+			if path == "" {
+				b.errorf("%s wasn't started with a rules file.\n", b.Style.Program)
+				return
+			}
+			if err := LoadRules(b.Bot, path); err != nil {
+				b.errorf("Error reloading rules: %s\n", err)
+				return
+			}
+			fmt.Fprintf(b.W, "%d rules loaded.\n", len(b.Bot.Rules()))
+		},
+	}
+}
+
+func (b *Builder) SuppressedCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "suppressed",
+		Short: "Show items that were dropped by a rule.",
+		Usage: "suppressed",
+		Run: func(*commands.Command, []string) {
+			for _, s := range b.Bot.Suppressed() {
+				fmt.Fprintf(b.W, "(%s) ", s.Rule)
+				b.writeItem(s.Item)
+			}
+		},
+	}
+}
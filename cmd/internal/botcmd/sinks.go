@@ -0,0 +1,200 @@
+package botcmd
+
+// Commands that show where new items are delivered.
+
+import (
+	"fmt"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"sort"
+)
+
+func (b *Builder) SinksCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "sinks",
+		Short: "List the sinks new items are delivered to.",
+		Usage: "sinks",
+		Run: func(*commands.Command, []string) {
+			sinks := b.Bot.Sinks()
+			if len(sinks) == 0 {
+				fmt.Fprintln(b.W, "No sinks are configured.")
+				return
+			}
+			for _, s := range sinks {
+				fmt.Fprintln(b.W, s)
+			}
+		},
+	}
+}
+
+// DeliveriesCommand shows the most recent deliveries to the sinks, failed
+// ones with their error.
+func (b *Builder) DeliveriesCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "deliveries",
+		Short: "Show the most recent deliveries to the sinks.",
+		Usage: "deliveries [-n count] [-failed]",
+	}
+
+	var n int
+	var failed bool
+	c.Flags.IntVar(&n, "n", 10, "Number of deliveries.")
+	c.Flags.BoolVar(&failed, "failed", false, "Only show failed deliveries.")
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		// reset, flags keep their values between runs.
+		limit, onlyFailed := n, failed
+		n, failed = 10, false
+
+		if limit <= 0 || len(c.Flags.Args()) > 0 {
+			b.usage(c)
+			return
+		}
+
+		shown := 0
+		for _, d := range b.Bot.Deliveries() {
+			if shown == limit {
+				break
+			}
+			if onlyFailed && d.Error == "" {
+				continue
+			}
+			fmt.Fprintf(b.W, "%s %s: %d items, %d attempts", d.Time.Format("Jan 2 15:04:05"), b.Style.bold(d.Sink), d.Items, d.Attempts)
+			if d.Error != "" {
+				fmt.Fprintf(b.W, ", failed: %s", d.Error)
+			}
+			fmt.Fprintln(b.W)
+			shown++
+		}
+		if shown == 0 {
+			fmt.Fprintln(b.W, "No deliveries.")
+		}
+	}
+	return c
+}
+
+func (b *Builder) WebhooksCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "webhooks",
+		Short: "List the webhook queues and how many posts are waiting in them.",
+		Usage: "webhooks",
+		Run: func(*commands.Command, []string) {
+			queues := b.Bot.WebhookQueues()
+			if len(queues) == 0 {
+				fmt.Fprintln(b.W, "No webhooks are queued.")
+				return
+			}
+
+			names := make([]string, 0, len(queues))
+			for name := range queues {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				q := queues[name]
+				fmt.Fprintf(b.W, "%s: %d pending, %d dead, in %s\n", b.Style.bold(name), len(q.Pending()), len(q.Dead()), q.Path())
+			}
+		},
+	}
+}
+
+// webhookQueue returns the named webhook's queue, writing an error if it
+// doesn't have one.
+func (b *Builder) webhookQueue(name string) (*paperboy.WebhookQueue, bool) {
+	q, ok := b.Bot.WebhookQueues()[name]
+	if !ok {
+		b.errorf("No queued webhook named %s.\n", name)
+	}
+	return q, ok
+}
+
+// QueueCommand shows a webhook's pending posts, or its dead letters.
+func (b *Builder) QueueCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "queue",
+		Short: "Show a webhook's pending posts, or its dead letters.",
+		Usage: "queue [-dead] <webhook>",
+	}
+
+	var dead bool
+	c.Flags.BoolVar(&dead, "dead", false, "Show the posts that were given up on.")
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		// reset, flags keep their values between runs.
+		showDead := dead
+		dead = false
+
+		if len(c.Flags.Args()) != 1 {
+			b.usage(c)
+			return
+		}
+		q, ok := b.webhookQueue(c.Flags.Arg(0))
+		if !ok {
+			return
+		}
+
+		posts := q.Pending()
+		if showDead {
+			posts = q.Dead()
+		}
+		if len(posts) == 0 {
+			fmt.Fprintln(b.W, "No posts.")
+			return
+		}
+		for _, p := range posts {
+			fmt.Fprintf(b.W, "%s %d items, %d attempts", b.Style.code(p.ID), p.Items, p.Attempts)
+			if !p.Next.IsZero() {
+				fmt.Fprintf(b.W, ", next %s", p.Next.Format("Jan 2 15:04:05"))
+			}
+			if p.Error != "" {
+				fmt.Fprintf(b.W, ": %s", p.Error)
+			}
+			fmt.Fprintln(b.W)
+		}
+	}
+	return c
+}
+
+// deadLetterCommand builds a command that does something with a webhook's
+// dead letters, all of them unless IDs are given, and reports how many with
+// format.
+func (b *Builder) deadLetterCommand(name, short, format string, do func(*paperboy.WebhookQueue, []string) (int, error)) *commands.Command {
+	c := &commands.Command{
+		Name:  name,
+		Short: short,
+		Usage: name + " <webhook> [id...]",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) == 0 {
+			b.usage(c)
+			return
+		}
+		q, ok := b.webhookQueue(args[0])
+		if !ok {
+			return
+		}
+		n, err := do(q, args[1:])
+		if err != nil {
+			b.errorf("%s\n", err)
+		}
+		fmt.Fprintf(b.W, format, n)
+	}
+	return c
+}
+
+func (b *Builder) RedeliverCommand() *commands.Command {
+	return b.deadLetterCommand("redeliver", "Queue a webhook's dead letters again, all of them unless IDs are given.",
+		"Queued %d posts again.\n", func(q *paperboy.WebhookQueue, ids []string) (int, error) {
+			return q.Redeliver(ids...)
+		})
+}
+
+func (b *Builder) PurgeCommand() *commands.Command {
+	return b.deadLetterCommand("purge", "Drop a webhook's dead letters, all of them unless IDs are given.",
+		"Dropped %d posts.\n", func(q *paperboy.WebhookQueue, ids []string) (int, error) {
+			return q.Purge(ids...)
+		})
+}
@@ -0,0 +1,112 @@
+package botcmd
+
+// Commands that change the bot's sources while it runs.
+
+import (
+	"fmt"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"strings"
+)
+
+func (b *Builder) SourcesCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "sources",
+		Short: "List the sources.",
+		Usage: "sources",
+		Run: func(*commands.Command, []string) {
+			for _, source := range b.Bot.Sources() {
+				paused := ""
+				if source.Paused {
+					paused = " (paused)"
+				}
+				fmt.Fprintf(b.W, "%s - %s%s\n", source.Name, source.URL, paused)
+			}
+		},
+	}
+}
+
+func (b *Builder) AddSourceCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "addsource",
+		Short: "Add a source, polled from the next poll on.",
+		Usage: "addsource [-feed] [-converter name] <name> <url> <selector>",
+	}
+
+	var converter string
+	var feed bool
+	c.Flags.StringVar(&converter, "converter", "anchor", "Converter that turns matches into items, one of "+strings.Join(paperboy.Converters(), ", ")+".")
+	c.Flags.BoolVar(&feed, "feed", false, "The URL is an RSS or Atom feed, no selector is needed.")
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		args = c.Flags.Args()
+		// reset, flags keep their values between runs.
+		conv, isFeed := converter, feed
+		converter, feed = "anchor", false
+
+		if len(args) < 3 && !(isFeed && len(args) == 2) {
+			b.usage(c)
+			b.errorf("converters: %s\n", strings.Join(paperboy.Converters(), ", "))
+			return
+		}
+
+		source := paperboy.Source{
+			Name: args[0],
+			URL:  b.Style.link(args[1]),
+		}
+		if isFeed {
+			source.Type = paperboy.FeedSource
+		} else {
+			source.Selector = strings.Join(args[2:], " ")
+			source.Converter = conv
+		}
+		if err := b.Bot.AddSource(source); err != nil {
+			b.errorf("%s\n", err)
+			return
+		}
+		fmt.Fprintf(b.W, "Added %s.\n", source.Name)
+	}
+	return c
+}
+
+// sourceCommand builds a command that runs do with a source's name,
+// reporting sources that don't exist.
+func (b *Builder) sourceCommand(name, short, done string, do func(string) (bool, error)) *commands.Command {
+	c := &commands.Command{
+		Name:  name,
+		Short: short,
+		Usage: name + " <source>",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) != 1 {
+			b.usage(c)
+			return
+		}
+
+		found, err := do(args[0])
+		if !found {
+			b.errorf("No source named %s.\n", args[0])
+			return
+		}
+		if err != nil {
+			b.errorf("Error saving sources: %s\n", err)
+			return
+		}
+		fmt.Fprintf(b.W, "%s %s.\n", done, args[0])
+	}
+	return c
+}
+
+func (b *Builder) RemoveSourceCommand() *commands.Command {
+	return b.sourceCommand("rmsource", "Remove a source, its items are kept.", "Removed", b.Bot.RemoveSource)
+}
+
+func (b *Builder) PauseCommand() *commands.Command {
+	return b.sourceCommand("pause", "Stop polling a source until it's resumed.", "Paused", b.Bot.PauseSource)
+}
+
+func (b *Builder) ResumeCommand() *commands.Command {
+	return b.sourceCommand("resume", "Resume polling a paused source.", "Resumed", b.Bot.ResumeSource)
+}
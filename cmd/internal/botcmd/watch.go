@@ -0,0 +1,107 @@
+package botcmd
+
+// Commands that manage the bot's watchlists.
+
+import (
+	"fmt"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"os"
+	"strings"
+)
+
+// LoadWatches replaces the bot's watches with the ones in the json file at
+// path.
+func LoadWatches(b *paperboy.Bot, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	watches, err := paperboy.ReadWatches(f)
+	if err != nil {
+		return err
+	}
+	return b.SetWatches(watches)
+}
+
+func (b *Builder) WatchesCommand() *commands.Command {
+	return &commands.Command{
+		Name:  "watches",
+		Short: "List the watchlists new items are alerted for.",
+		Usage: "watches",
+		Run: func(*commands.Command, []string) {
+			watches := b.Bot.Watches()
+			if len(watches) == 0 {
+				fmt.Fprintln(b.W, "No watches.")
+			}
+			for _, watch := range watches {
+				fmt.Fprintln(b.W, b.Style.code(watch.String()))
+			}
+		},
+	}
+}
+
+// WatchCommand adds a watch that alerts with the Style's notifier, in the
+// channel it was added in unless another destination is given with -to.
+func (b *Builder) WatchCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "watch",
+		Short: "Add or replace a watchlist that alerts as soon as a matching item is seen.",
+		Usage: "watch [-fuzzy] [-notify name] [-to destination] <name> <query>",
+	}
+
+	watch := paperboy.Watch{Notifier: b.Style.Notifier}
+	c.Flags.BoolVar(&watch.Fuzzy, "fuzzy", false, "Match words with typos.")
+	c.Flags.StringVar(&watch.Notifier, "notify", b.Style.Notifier, "Notifier alerts are sent with.")
+	c.Flags.StringVar(&watch.To, "to", "", "Where the notifier sends alerts, this channel by default.")
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		args = c.Flags.Args()
+		// reset, flags keep their values between runs.
+		wt := watch
+		watch = paperboy.Watch{Notifier: b.Style.Notifier}
+
+		if len(args) < 2 {
+			b.usage(c)
+			return
+		}
+
+		wt.Name = args[0]
+		wt.Query = strings.Join(args[1:], " ")
+		if wt.To == "" {
+			wt.To = b.channel()
+		}
+		wt.To = b.Style.target(wt.To)
+
+		if err := b.Bot.AddWatch(wt); err != nil {
+			b.errorf("%s\n", err)
+			return
+		}
+		fmt.Fprintf(b.W, "Added %s\n", b.Style.code(wt.String()))
+	}
+	return c
+}
+
+func (b *Builder) UnwatchCommand() *commands.Command {
+	c := &commands.Command{
+		Name:  "unwatch",
+		Short: "Remove a watchlist.",
+		Usage: "unwatch <name>",
+	}
+
+	c.Run = func(cmd *commands.Command, args []string) {
+		if len(args) != 1 {
+			b.usage(c)
+			return
+		}
+		if !b.Bot.RemoveWatch(args[0]) {
+			b.errorf("No watch named %s.\n", args[0])
+			return
+		}
+		fmt.Fprintf(b.W, "Removed %s.\n", args[0])
+	}
+	return c
+}
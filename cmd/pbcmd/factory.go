@@ -5,101 +5,36 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/jwriopel/commands"
 	"github.com/jwriopel/paperboy"
 )
 
-func startCommand(b *paperboy.Bot, stopper chan bool) *commands.Command {
-	return &commands.Command{
-		Name:  "start",
-		Short: "Start the paperboy Bot instance.",
-		Usage: "start",
-		Run: func(*commands.Command, []string) {
-			if b.IsRunning() {
-				fmt.Println("Bot already running.")
-				return
-			}
-			b.Start(stopper)
-			fmt.Println("Bot started.")
-		},
-	}
-}
-
-func stopCommand(b *paperboy.Bot, stopper chan bool) *commands.Command {
-	return &commands.Command{
-		Name:  "stop",
-		Short: "Command the bot to stop polling.",
-		Usage: "stop",
-		Run: func(*commands.Command, []string) {
-
-			switch b.IsRunning() {
-			case true:
-				fmt.Print("Stopping...")
-				stopper <- true
-				fmt.Println("done.")
-			case false:
-				fmt.Println("bot isn't running.")
-			}
-		},
-	}
-}
-
-func sourcesCommand(b *paperboy.Bot) (c *commands.Command) {
-	c = &commands.Command{
-		Name:  "sources",
-		Short: "Print the list of sources.",
-		Usage: "sources",
+func readCommand(b *paperboy.Bot) *commands.Command {
+	c := &commands.Command{
+		Name:  "read",
+		Short: "Mark items read by URL.",
+		Usage: "read <url>...",
 	}
 
-	c.Run = func(*commands.Command, []string) {
-		var slist string
-		for _, source := range b.Sources {
-			slist += fmt.Sprintf("%s\n", source.Name)
+	c.Run = func(command *commands.Command, args []string) {
+		if len(args) == 0 {
+			c.Flags.Usage()
+			return
 		}
-		fmt.Println(slist)
-	}
-	return
-}
-
-func statusCommand(b *paperboy.Bot) (c *commands.Command) {
-
-	c = &commands.Command{
-		Name:  "status",
-		Short: "Show how many items are cached (read) and unread.",
-		Usage: "status",
-		Run: func(*commands.Command, []string) {
-			fmt.Printf("%d read items.\n", b.CacheSize())
-			if b.NPending() > 0 {
-				fmt.Printf("%d unread items.\n", b.NPending())
-			}
-			if b.IsRunning() {
-				fmt.Println("Bot is running.")
-			}
-		},
-	}
-	return
-}
-
-func showCommand(b *paperboy.Bot) *commands.Command {
-	return &commands.Command{
-		Name:  "show",
-		Short: "Show new items.",
-		Usage: "show",
-		Run: func(*commands.Command, []string) {
-			for _, item := range b.Unread() {
-				printItem(item)
-			}
-		},
+		fmt.Printf("%d items marked read.\n", b.MarkRead(consumer, args...))
 	}
+	return c
 }
 
 func validSource(name string, b *paperboy.Bot) bool {
-	for _, source := range b.Sources {
+	for _, source := range b.Sources() {
 		if source.Name == name {
 			return true
 		}
@@ -108,7 +43,7 @@ func validSource(name string, b *paperboy.Bot) bool {
 }
 
 // streamCommand is a command that will stream items to the stdout, until
-// the user presses any key.
+// the user presses any key. Streamed items are marked read.
 func streamCommand(b *paperboy.Bot) *commands.Command {
 
 	c := &commands.Command{
@@ -121,82 +56,67 @@ func streamCommand(b *paperboy.Bot) *commands.Command {
 	c.Flags.StringVar(&sourceFilter, "source", "", "Only show items from a single source.")
 
 	c.Run = func(c *commands.Command, args []string) {
-		quit := make(chan bool)
-		poller := time.Tick(time.Duration(10) * time.Second)
-		reader := bufio.NewReader(os.Stdin)
+		c.Flags.Parse(args)
+		// reset, flags keep their values between runs.
+		source := sourceFilter
+		sourceFilter = ""
 
-		if sourceFilter != "" && !validSource(sourceFilter, b) {
-			fmt.Fprintf(os.Stderr, "Invalid source: %s\n", sourceFilter)
+		if source != "" && !validSource(source, b) {
+			fmt.Fprintf(os.Stderr, "Invalid source: %s\n", source)
 			return
 		}
 
-		go func() {
-			if !b.IsRunning() {
-				b.Start(quit)
-			}
-			fmt.Println("Press any key to stop streaming.")
-			_, _ = reader.ReadString('\n')
-			quit <- true
-		}()
+		// subscribe before reading the backlog so nothing stored in
+		// between is missed, items that are in both are shown once.
+		items, cancel := b.Subscribe(func(item paperboy.Item) bool {
+			return source == "" || item.SourceName == source
+		})
 
-		for {
-			select {
-			case <-quit:
-				close(quit)
-				// reset
-				sourceFilter = ""
-				return
-			case <-poller:
-				for _, item := range b.Unread() {
-					if sourceFilter == "" {
-						printItem(item)
-					}
-
-					if sourceFilter != "" && item.SourceName == sourceFilter {
-						printItem(item)
-					}
-
-				}
+		// show what arrived before streaming started.
+		shown := make(map[string]bool)
+		for _, item := range b.Unread(consumer) {
+			if source == "" || item.SourceName == source {
+				printItem(item)
+				shown[item.URL] = true
 			}
 		}
 
-	}
-
-	return c
-}
-
-func searchCommand(b *paperboy.Bot) *commands.Command {
-
-	c := &commands.Command{
-		Name:  "search",
-		Short: "Search cache for items by title.",
-		Usage: "search <search term>",
-	}
+		started := false
+		if !b.IsRunning() {
+			b.Start(context.Background())
+			started = true
+		}
 
-	c.Run = func(cmd *commands.Command, args []string) {
-		c.Flags.Parse(args)
-		args = c.Flags.Args()
+		go func() {
+			fmt.Println("Press any key to stop streaming.")
+			bufio.NewReader(os.Stdin).ReadString('\n')
+			cancel()
+		}()
 
-		if len(args) == 0 {
-			c.Flags.Usage()
-			return
+		for item := range items {
+			if shown[item.URL] {
+				delete(shown, item.URL)
+				continue
+			}
+			printItem(item)
+			b.MarkRead(consumer, item.URL)
 		}
 
-		sterm := strings.Join(args, " ")
-		results := b.Search(sterm)
-		for _, result := range results {
-			printItem(result)
+		if started {
+			b.Stop()
 		}
 	}
+
 	return c
 }
 
-// saveCommand will create a commands.Command that is used to save the read
-// items to a json file. This can be loaded and added to the Bot's memory.
+// saveCommand will create a commands.Command that is used to save a
+// snapshot of the Bot to a json file. This can be loaded back into the Bot's
+// memory.
 func saveCommand(b *paperboy.Bot) *commands.Command {
 	c := &commands.Command{
 		Name:  "save",
-		Short: "Save read items to a disk.",
+		Short: "Save the items, read state and sources to disk.",
 		Usage: "save <path>",
 	}
 
@@ -225,12 +145,22 @@ func loadCommand(b *paperboy.Bot) *commands.Command {
 
 	c := &commands.Command{
 		Name:  "load",
-		Short: "Load items saved items from a file.",
-		Usage: "load <path>",
+		Short: "Load a saved snapshot, merging it with or replacing what's in memory.",
+		Usage: "load [-strategy merge|replace] <path>",
 	}
 
+	var strategyName string
+	c.Flags.StringVar(&strategyName, "strategy", "merge", "What to do with the items already in memory, merge or replace.")
+
 	c.Run = func(command *commands.Command, args []string) {
-		if len(args) == 0 {
+		c.Flags.Parse(args)
+		args = c.Flags.Args()
+		// reset, flags keep their values between runs.
+		name := strategyName
+		strategyName = "merge"
+
+		strategy, err := paperboy.ParseLoadStrategy(name)
+		if err != nil || len(args) == 0 {
 			c.Flags.Usage()
 			return
 		}
@@ -241,11 +171,129 @@ func loadCommand(b *paperboy.Bot) *commands.Command {
 		}
 		defer ifile.Close()
 
-		err = b.Load(ifile)
+		err = b.LoadWith(ifile, strategy)
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+
+	return c
+}
+
+// queryCommand runs read-only SQL against the Bot's archive and prints the
+// results as a table.
+func queryCommand(b *paperboy.Bot) *commands.Command {
+	c := &commands.Command{
+		Name:  "query",
+		Short: "Run a read-only SQL query against the item archive.",
+		Usage: "query <sql>",
+	}
+
+	c.Run = func(command *commands.Command, args []string) {
+		if len(args) == 0 {
+			c.Flags.Usage()
+			return
+		}
+
+		if b.Archive == nil {
+			fmt.Fprintln(os.Stderr, "No archive, start pbcmd with -archive <path>.")
+			return
 		}
+
+		result, err := b.Archive.Query(strings.Join(args, " "))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(result.Columns, "\t"))
+		for _, row := range result.Rows {
+			fields := make([]string, len(row))
+			for i, value := range row {
+				fields[i] = fmt.Sprint(value)
+			}
+			fmt.Fprintln(tw, strings.Join(fields, "\t"))
+		}
+		tw.Flush()
+		fmt.Printf("(%d rows)\n", len(result.Rows))
 	}
 
 	return c
 }
+
+// exportCommand writes the bot's newest items as a feed, to a file or to
+// stdout.
+func exportCommand(b *paperboy.Bot) *commands.Command {
+	c := &commands.Command{
+		Name:  "export",
+		Short: "Write the newest items as an RSS, Atom or JSON feed.",
+		Usage: "export [-format rss|atom|json] [-source name] [-q query] [-n count] [path]",
+	}
+
+	var formatName string
+	var opts paperboy.FeedOptions
+	c.Flags.StringVar(&formatName, "format", "rss", "Feed format, rss, atom or json.")
+	c.Flags.StringVar(&opts.Source, "source", "", "Only export items from this source.")
+	c.Flags.StringVar(&opts.Query, "q", "", "Only export items matching this search.")
+	c.Flags.IntVar(&opts.Limit, "n", paperboy.DefaultFeedLimit, "Number of items to export.")
+
+	c.Run = func(command *commands.Command, args []string) {
+		c.Flags.Parse(args)
+		args = c.Flags.Args()
+		// reset, flags keep their values between runs.
+		name, o := formatName, opts
+		formatName, opts = "rss", paperboy.FeedOptions{Limit: paperboy.DefaultFeedLimit}
+
+		format, err := paperboy.ParseFeedFormat(name)
+		if err != nil || len(args) > 1 {
+			c.Flags.Usage()
+			return
+		}
+
+		feed, err := b.Feed(o)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
+		}
+
+		out := os.Stdout
+		if len(args) == 1 {
+			if out, err = os.Create(args[0]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				return
+			}
+			defer out.Close()
+		}
+		if err := paperboy.WriteFeed(out, format, feed); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+	return c
+}
+
+func digestsCommand(b *paperboy.Bot) *commands.Command {
+	return &commands.Command{
+		Name:  "digests",
+		Short: "List the scheduled digests and when they're next sent.",
+		Usage: "digests",
+		Run: func(*commands.Command, []string) {
+			digests := b.Digests()
+			if len(digests) == 0 {
+				fmt.Println("No digests are scheduled.")
+				return
+			}
+
+			now := time.Now()
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, d := range digests {
+				next := "never"
+				if t := d.Next(now); !t.IsZero() {
+					next = t.Format("Mon Jan 2 15:04 MST")
+				}
+				fmt.Fprintf(tw, "%s\tnext %s\n", d, next)
+			}
+			tw.Flush()
+		},
+	}
+}
@@ -4,57 +4,169 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/jwriopel/commands"
 	"github.com/jwriopel/paperboy"
+	"github.com/jwriopel/paperboy/cmd/internal/botcmd"
 	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 )
 
-func printItem(item paperboy.Item) {
+// consumer is the name pbcmd reads items as.
+var consumer = "pbcmd"
+
+// style writes the shared commands' output with colored items.
+var style = botcmd.Style{
+	Program:  "pbcmd",
+	Quote:    "    ",
+	Item:     writeItem,
+	Digest:   paperboy.PlainText,
+	Notifier: "stdout",
+}
+
+func writeItem(w io.Writer, item paperboy.Item) {
 	green := color.New(color.FgGreen).SprintfFunc()
 	yellow := color.New(color.FgCyan).SprintfFunc()
 
-	fmt.Printf("[%s] %s - %s\n", item.SourceName, green(item.Title), yellow(item.URL))
+	fmt.Fprintf(w, "[%s] %s - %s\n", item.SourceName, green(item.Title), yellow(item.URL))
+}
+
+func printItem(item paperboy.Item) {
+	writeItem(os.Stdout, item)
 }
 
-func buildBot() *paperboy.Bot {
-	sources := []paperboy.Source{
-		paperboy.Source{
-			Name:        "HackerNews",
-			URL:         "https://news.ycombinator.com",
-			Selector:    ".storylink",
-			ConvertFunc: paperboy.AnchorConverter,
-		},
-		paperboy.Source{
-			Name:        "Reddit",
-			URL:         "https://www.reddit.com",
-			Selector:    "a.title",
-			ConvertFunc: paperboy.RedditConverter,
-		},
+// printAlert is the "stdout" notifier, it prints alerts and digests between
+// prompts.
+func printAlert(n paperboy.Notification) error {
+	fmt.Println()
+	if n.Digest != nil {
+		fmt.Print(n.Text())
+		return nil
 	}
-	return paperboy.NewBot(sources)
+	color.New(color.FgRed, color.Bold).Println(n.Subject)
+	for _, item := range n.Items {
+		printItem(item)
+	}
+	return nil
 }
 
 func main() {
-	stopper := make(chan bool)
-	bot := buildBot()
-
-	commands.Add(startCommand(bot, stopper))
-	commands.Add(stopCommand(bot, stopper))
-	commands.Add(sourcesCommand(bot))
-	commands.Add(statusCommand(bot))
-	commands.Add(showCommand(bot))
+	paperboy.RegisterNotifierType("stdout", func(paperboy.NotifierConfig) (paperboy.Notifier, error) {
+		return paperboy.NotifierFunc(printAlert), nil
+	})
+
+	config := paperboy.DefaultConfig()
+	if err := config.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	bot, err := config.NewBot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if bot.Archive != nil {
+		defer bot.Archive.Close()
+	}
+	if err := bot.Restore(); err != nil {
+		fmt.Fprintf(os.Stderr, "error restoring items: %s\n", err)
+	}
+	defer func() {
+		bot.Stop()
+		if err := bot.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "error saving items: %s\n", err)
+		}
+	}()
+
+	if config.Storage.Rules != "" {
+		if err := botcmd.LoadRules(bot, config.Storage.Rules); err != nil {
+			fmt.Fprintf(os.Stderr, "error loading rules: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	bot.AddNotifier("stdout", paperboy.NotifierFunc(printAlert))
+	if config.Storage.Watches != "" {
+		if err := botcmd.LoadWatches(bot, config.Storage.Watches); err != nil {
+			fmt.Fprintf(os.Stderr, "error loading watches: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if config.Path() != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go bot.WatchConfig(context.Background(), config, paperboy.DefaultConfigCheck, hup,
+			func(changes paperboy.ConfigChanges, err error) {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error reloading %s: %s\n", config.Path(), err)
+					return
+				}
+				fmt.Fprintf(os.Stderr, "reloaded %s:\n%s\n", config.Path(), changes)
+			})
+	}
+
+	cmds := &botcmd.Builder{Bot: bot, W: os.Stdout, Errors: os.Stderr, Consumer: &consumer, Style: style}
+
+	commands.Add(cmds.StartCommand())
+	commands.Add(cmds.StopCommand())
+	commands.Add(cmds.SourcesCommand())
+	commands.Add(cmds.AddSourceCommand())
+	commands.Add(cmds.RemoveSourceCommand())
+	commands.Add(cmds.PauseCommand())
+	commands.Add(cmds.ResumeCommand())
+	commands.Add(importOPMLCommand(bot))
+	commands.Add(exportOPMLCommand(bot))
+	commands.Add(cmds.StatusCommand())
+	commands.Add(cmds.ShowCommand())
+	commands.Add(cmds.PeekCommand())
+	commands.Add(readCommand(bot))
 	commands.Add(streamCommand(bot))
-	commands.Add(searchCommand(bot))
+	commands.Add(cmds.SearchCommand())
 	commands.Add(saveCommand(bot))
 	commands.Add(loadCommand(bot))
+	commands.Add(queryCommand(bot))
+	commands.Add(cmds.TrendingCommand())
+	commands.Add(cmds.HistoryCommand())
+	commands.Add(exportCommand(bot))
+	commands.Add(cmds.DigestCommand())
+	commands.Add(digestsCommand(bot))
+	commands.Add(cmds.SinksCommand())
+	commands.Add(cmds.DeliveriesCommand())
+	commands.Add(cmds.WebhooksCommand())
+	commands.Add(cmds.QueueCommand())
+	commands.Add(cmds.RedeliverCommand())
+	commands.Add(cmds.PurgeCommand())
+	commands.Add(cmds.RulesCommand())
+	commands.Add(cmds.AddRuleCommand())
+	commands.Add(cmds.RemoveRuleCommand())
+	commands.Add(cmds.ReloadCommand(config))
+	commands.Add(loadRulesCommand(bot))
+	commands.Add(saveRulesCommand(bot))
+	commands.Add(cmds.SuppressedCommand())
+	commands.Add(cmds.WatchesCommand())
+	commands.Add(cmds.WatchCommand())
+	commands.Add(cmds.UnwatchCommand())
+	commands.Add(cmds.StarCommand())
+	commands.Add(cmds.UnstarCommand())
+	commands.Add(cmds.StarredCommand())
+	commands.Add(cmds.TagCommand())
+	commands.Add(cmds.UntagCommand())
+	commands.Add(cmds.TagsCommand())
+	commands.Add(cmds.LaterCommand())
+	commands.Add(cmds.UnlaterCommand())
+	commands.Add(cmds.ReadLaterCommand())
 
 	cmdReader := bufio.NewReader(os.Stdin)
 	for {
-		if bot.NPending() > 0 {
+		if bot.NPending(consumer) > 0 {
 			fmt.Print("* ")
 		}
 		fmt.Print("pbcmd> ")
@@ -62,7 +174,7 @@ func main() {
 		if err != nil {
 			if err == io.EOF {
 				fmt.Println("bye")
-				return
+				break
 			}
 			panic(err)
 		}
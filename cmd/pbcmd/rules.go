@@ -0,0 +1,60 @@
+package main
+
+// Commands that manage the bot's filtering rules.
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"github.com/jwriopel/paperboy/cmd/internal/botcmd"
+)
+
+func loadRulesCommand(b *paperboy.Bot) *commands.Command {
+	c := &commands.Command{
+		Name:  "loadrules",
+		Short: "Replace the rules with the ones in a json file.",
+		Usage: "loadrules <path>",
+	}
+
+	c.Run = func(command *commands.Command, args []string) {
+		if len(args) != 1 {
+			c.Flags.Usage()
+			return
+		}
+		if err := botcmd.LoadRules(b, args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
+		}
+		fmt.Printf("%d rules loaded.\n", len(b.Rules()))
+	}
+	return c
+}
+
+func saveRulesCommand(b *paperboy.Bot) *commands.Command {
+	c := &commands.Command{
+		Name:  "saverules",
+		Short: "Save the rules to a json file.",
+		Usage: "saverules <path>",
+	}
+
+	c.Run = func(command *commands.Command, args []string) {
+		if len(args) != 1 {
+			c.Flags.Usage()
+			return
+		}
+
+		f, err := os.Create(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
+		}
+		defer f.Close()
+
+		if err = paperboy.WriteRules(f, b.Rules()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+	return c
+}
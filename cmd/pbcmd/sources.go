@@ -0,0 +1,79 @@
+package main
+
+// Commands that change the bot's sources while it runs.
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+)
+
+// importOPMLCommand adds the feeds in an OPML file as sources.
+func importOPMLCommand(b *paperboy.Bot) *commands.Command {
+	c := &commands.Command{
+		Name:  "importopml",
+		Short: "Add the feeds in an OPML file as sources.",
+		Usage: "importopml <path>",
+	}
+
+	c.Run = func(command *commands.Command, args []string) {
+		if len(args) != 1 {
+			c.Flags.Usage()
+			return
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
+		}
+		defer f.Close()
+
+		sources, err := paperboy.ReadOPML(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", args[0], err)
+			return
+		}
+		added, err := b.ImportSources(sources)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		for _, source := range added {
+			fmt.Printf("Added %s\n", source.Name)
+		}
+		fmt.Printf("Added %d of %d sources, the others already exist.\n", len(added), len(sources))
+	}
+	return c
+}
+
+// exportOPMLCommand writes the sources as OPML, to a file or to stdout.
+func exportOPMLCommand(b *paperboy.Bot) *commands.Command {
+	c := &commands.Command{
+		Name:  "exportopml",
+		Short: "Write the sources as OPML.",
+		Usage: "exportopml [path]",
+	}
+
+	c.Run = func(command *commands.Command, args []string) {
+		if len(args) > 1 {
+			c.Flags.Usage()
+			return
+		}
+
+		out := os.Stdout
+		if len(args) == 1 {
+			var err error
+			if out, err = os.Create(args[0]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				return
+			}
+			defer out.Close()
+		}
+		if err := paperboy.WriteOPML(out, b.Sources()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+	return c
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/logger"
+	"github.com/jwriopel/commands"
+	"github.com/jwriopel/paperboy"
+	"github.com/jwriopel/paperboy/cmd/internal/botcmd"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// maxReplyLines is the number of lines a command's reply is cut to, so one
+// command can't keep the bot busy for minutes at the flood limit.
+const maxReplyLines = 30
+
+// ircStyle writes the commands' replies as plain text.
+var ircStyle = botcmd.Style{
+	Program:  "pbirc",
+	Quote:    "> ",
+	Digest:   paperboy.PlainText,
+	Notifier: "irc",
+}
+
+// saveOnSignal saves the bot's items and exits when the process is
+// interrupted or terminated, after leaving IRC.
+func saveOnSignal(bot *paperboy.Bot, quit func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		quit()
+		bot.Stop()
+		if err := bot.Save(); err != nil {
+			logger.Errorf("Error saving items: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
+
+// reloadOnChange applies config to the bot again when its file changes or
+// the process gets SIGHUP.
+func reloadOnChange(bot *paperboy.Bot, config *paperboy.Config) {
+	if config.Path() == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go bot.WatchConfig(context.Background(), config, paperboy.DefaultConfigCheck, hup,
+		func(changes paperboy.ConfigChanges, err error) {
+			if err != nil {
+				logger.Errorf("Error reloading %s: %s\n", config.Path(), err)
+				return
+			}
+			logger.Infof("Reloaded %s: %s\n", config.Path(), strings.Replace(changes.String(), "\n", "; ", -1))
+		})
+}
+
+// command returns the command in a PRIVMSG to the bot, the whole text of a
+// private message, or the text after "nick:" or "nick," in a channel.
+func command(m paperboy.IRCMessage, nick string) (string, bool) {
+	target, text := m.Param(0), strings.TrimSpace(m.Param(1))
+	if strings.EqualFold(target, nick) {
+		return text, text != ""
+	}
+	if len(text) <= len(nick) || !strings.EqualFold(text[:len(nick)], nick) {
+		return "", false
+	}
+	if c := text[len(nick)]; c != ':' && c != ',' {
+		return "", false
+	}
+	text = strings.TrimSpace(text[len(nick)+1:])
+	return text, text != ""
+}
+
+// ircRequest is a command and where to reply to it.
+type ircRequest struct {
+	line    string
+	nick    string
+	replyTo string
+}
+
+func main() {
+	server := flag.String("server", "irc.libera.chat:6697", "IRC server's host:port.")
+	useTLS := flag.Bool("tls", true, "Connect with TLS.")
+	nick := flag.String("nick", "paperboy", "Nick to use, with _ added while it's taken.")
+	channels := flag.String("channels", "", "Comma separated channels to join.")
+	saslUser := flag.String("sasl", "", "Account to log in to with SASL, the password is the irc credential or $IRC_PASSWORD.")
+
+	config := paperboy.DefaultConfig()
+	if err := config.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		logger.Fatal(err)
+	}
+
+	password := config.Credentials["irc"]
+	if password == "" {
+		password = os.Getenv("IRC_PASSWORD")
+	}
+	ircConfig := paperboy.IRCConfig{
+		Addr:     *server,
+		TLS:      *useTLS,
+		Nick:     *nick,
+		Channels: strings.Split(*channels, ","),
+	}
+	if *channels == "" {
+		ircConfig.Channels = nil
+	}
+	if *saslUser != "" {
+		ircConfig.SASLUser, ircConfig.SASLPassword = *saslUser, password
+	} else {
+		ircConfig.Password = password
+	}
+	client := paperboy.NewIRCClient(ircConfig)
+	paperboy.RegisterNotifierType("irc", ircNotifierType(client))
+
+	bot, err := config.NewBot()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	bot.AddNotifier("irc", ircNotifier(client))
+	if err := bot.Restore(); err != nil {
+		logger.Errorf("Error restoring items: %s\n", err)
+	}
+	ctx, quit := context.WithCancel(context.Background())
+	saveOnSignal(bot, quit)
+	reloadOnChange(bot, config)
+	if config.Storage.Rules != "" {
+		if err := botcmd.LoadRules(bot, config.Storage.Rules); err != nil {
+			logger.Fatalf("Error loading rules: %s\n", err)
+		}
+	}
+	if config.Storage.Watches != "" {
+		if err := botcmd.LoadWatches(bot, config.Storage.Watches); err != nil {
+			logger.Fatalf("Error loading watches: %s\n", err)
+		}
+	}
+	cmdBuffer := new(bytes.Buffer)
+	// each IRC nick reads items separately.
+	var consumer string
+	// the channel a command was sent in, or the nick that sent it in a
+	// private message.
+	var channel string
+
+	cmds := &botcmd.Builder{Bot: bot, W: cmdBuffer, Consumer: &consumer, Channel: &channel, Style: ircStyle}
+
+	commands.Add(cmds.StartCommand())
+	commands.Add(cmds.StopCommand())
+	commands.Add(cmds.StatusCommand())
+	commands.Add(cmds.SourcesCommand())
+	commands.Add(cmds.AddSourceCommand())
+	commands.Add(cmds.RemoveSourceCommand())
+	commands.Add(cmds.PauseCommand())
+	commands.Add(cmds.ResumeCommand())
+	commands.Add(cmds.ShowCommand())
+	commands.Add(cmds.PeekCommand())
+	commands.Add(cmds.SearchCommand())
+	commands.Add(cmds.TrendingCommand())
+	commands.Add(cmds.HistoryCommand())
+	commands.Add(cmds.DigestCommand())
+	commands.Add(cmds.SinksCommand())
+	commands.Add(cmds.DeliveriesCommand())
+	commands.Add(cmds.WebhooksCommand())
+	commands.Add(cmds.QueueCommand())
+	commands.Add(cmds.RedeliverCommand())
+	commands.Add(cmds.PurgeCommand())
+	commands.Add(cmds.RulesCommand())
+	commands.Add(cmds.AddRuleCommand())
+	commands.Add(cmds.RemoveRuleCommand())
+	commands.Add(cmds.ReloadRulesCommand(config.Storage.Rules))
+	commands.Add(cmds.ReloadCommand(config))
+	commands.Add(cmds.SuppressedCommand())
+	commands.Add(cmds.WatchesCommand())
+	commands.Add(cmds.WatchCommand())
+	commands.Add(cmds.UnwatchCommand())
+	commands.Add(cmds.StarCommand())
+	commands.Add(cmds.UnstarCommand())
+	commands.Add(cmds.StarredCommand())
+	commands.Add(cmds.TagCommand())
+	commands.Add(cmds.UntagCommand())
+	commands.Add(cmds.TagsCommand())
+	commands.Add(cmds.LaterCommand())
+	commands.Add(cmds.UnlaterCommand())
+	commands.Add(cmds.ReadLaterCommand())
+
+	// commands run one at a time off the connection's goroutine, so a slow
+	// one doesn't keep the client from answering the server's pings.
+	requests := make(chan ircRequest, 10)
+	go func() {
+		for req := range requests {
+			cmdBuffer.Reset()
+			consumer = "irc:" + req.nick
+			channel = req.replyTo
+			if err := commands.Run(req.line); err != nil {
+				client.Say(req.replyTo, fmt.Sprintf("error running %s: %s", req.line, err))
+				continue
+			}
+
+			lines := strings.Split(strings.TrimRight(cmdBuffer.String(), "\n"), "\n")
+			if len(lines) > maxReplyLines {
+				more := len(lines) - maxReplyLines
+				lines = append(lines[:maxReplyLines], fmt.Sprintf("... and %d more lines", more))
+			}
+			if err := client.Say(req.replyTo, strings.Join(lines, "\n")); err != nil {
+				logger.Errorf("Error replying to %s: %s\n", req.nick, err)
+			}
+		}
+	}()
+
+	err = client.Run(ctx, func(m paperboy.IRCMessage) {
+		if m.Command != "PRIVMSG" {
+			return
+		}
+		line, ok := command(m, client.Nick())
+		if !ok {
+			return
+		}
+		req := ircRequest{line: line, nick: m.Nick(), replyTo: m.Param(0)}
+		if strings.EqualFold(req.replyTo, client.Nick()) {
+			req.replyTo = req.nick
+		}
+		// replying would block the connection while the queue is full,
+		// the command is dropped.
+		select {
+		case requests <- req:
+		default:
+			logger.Errorf("Dropped %q from %s, too many commands are waiting\n", req.line, req.nick)
+		}
+	})
+	if err != context.Canceled {
+		logger.Fatal(err)
+	}
+	// saveOnSignal exits once the items are saved.
+	select {}
+}
@@ -0,0 +1,33 @@
+package main
+
+// Notifiers that post alerts and digests to IRC.
+
+import (
+	"fmt"
+	"github.com/jwriopel/paperboy"
+)
+
+// ircNotifier posts alerts and digests to the channel or nick in the
+// notification's To.
+func ircNotifier(client *paperboy.IRCClient) paperboy.Notifier {
+	return paperboy.NotifierFunc(func(n paperboy.Notification) error {
+		if n.To == "" {
+			return fmt.Errorf("no channel to alert")
+		}
+		return client.Say(n.To, n.Render(paperboy.PlainText))
+	})
+}
+
+// ircNotifierType builds the IRC notifiers in the config, they post to
+// their channel setting unless a watch says where.
+func ircNotifierType(client *paperboy.IRCClient) func(paperboy.NotifierConfig) (paperboy.Notifier, error) {
+	return func(nc paperboy.NotifierConfig) (paperboy.Notifier, error) {
+		post := ircNotifier(client)
+		return paperboy.NotifierFunc(func(n paperboy.Notification) error {
+			if n.To == "" {
+				n.To = nc.Settings["channel"]
+			}
+			return post.Notify(n)
+		}), nil
+	}
+}
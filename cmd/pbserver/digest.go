@@ -0,0 +1,59 @@
+package main
+
+// Handlers that serve digests of the bot's items.
+
+import (
+	"github.com/jwriopel/paperboy"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// digestHandler responds with a digest of the best recent items. The format
+// parameter is text, markdown, html or slack, html by default. n is the
+// number of items, window how far back the digest goes, by is source or
+// topic, and source and q limit it to a source and to items matching a
+// search.
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+	opts := paperboy.DigestOptions{
+		GroupBy: paperboy.DigestGrouping(r.FormValue("by")),
+		Query:   r.FormValue("q"),
+	}
+	if source := r.FormValue("source"); source != "" {
+		opts.Sources = []string{source}
+	}
+	if s := r.FormValue("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "n must be a positive number", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = n
+	}
+	if s := r.FormValue("window"); s != "" {
+		window, err := time.ParseDuration(s)
+		if err != nil || window <= 0 {
+			http.Error(w, "window must be a positive duration, like 24h", http.StatusBadRequest)
+			return
+		}
+		opts.Window = window
+	}
+
+	format := paperboy.HTML
+	if s := r.FormValue("format"); s != "" {
+		var err error
+		if format, err = paperboy.ParseTextFormat(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	digest, err := bot.Digest(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	io.WriteString(w, digest.Render(format))
+}
@@ -0,0 +1,49 @@
+package main
+
+// Handlers that serve the bot's items as feeds.
+
+import (
+	"fmt"
+	"github.com/jwriopel/paperboy"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// feedHandler builds a handler that serves the bot's newest items in format.
+// The source and q parameters limit the feed to a source and to items
+// matching a search, n is the number of items.
+func feedHandler(format paperboy.FeedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts := paperboy.FeedOptions{
+			Source: r.FormValue("source"),
+			Query:  r.FormValue("q"),
+		}
+		if s := r.FormValue("n"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "n must be a positive number", http.StatusBadRequest)
+				return
+			}
+			opts.Limit = n
+		}
+
+		feed, err := bot.Feed(opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		feed.Link = fmt.Sprintf("%s://%s/", scheme, r.Host)
+		feed.FeedURL = fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+
+		w.Header().Set("Content-Type", format.ContentType())
+		if err := paperboy.WriteFeed(w, format, feed); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing feed: %s\n", err)
+		}
+	}
+}
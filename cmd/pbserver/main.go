@@ -1,35 +1,56 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/jwriopel/paperboy"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
 var bot *paperboy.Bot
-var pollStop chan bool
 
 type botStatus struct {
-	Running     bool `json:"running"`
-	ReadCount   int  `json:"readcount"`
-	UnreadCount int  `json:"unreadCount"`
+	Running     bool   `json:"running"`
+	State       string `json:"state"`
+	ReadCount   int    `json:"readcount"`
+	UnreadCount int    `json:"unreadCount"`
 }
 
-func currentStatus() botStatus {
+// defaultConsumer reads items for requests without a consumer parameter.
+const defaultConsumer = "web"
+
+// requestConsumer returns the consumer named by the request's consumer
+// parameter.
+func requestConsumer(r *http.Request) string {
+	if consumer := r.FormValue("consumer"); consumer != "" {
+		return consumer
+	}
+	return defaultConsumer
+}
+
+func currentStatus(consumer string) botStatus {
 	return botStatus{
 		Running:     bot.IsRunning(),
+		State:       bot.State().String(),
 		ReadCount:   bot.CacheSize(),
-		UnreadCount: bot.NPending(),
+		UnreadCount: bot.NPending(consumer),
 	}
 }
 
-func writeStatus(w http.ResponseWriter) {
-	enc, err := json.Marshal(currentStatus())
+// writeJSON responds with v encoded as json.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	enc, err := json.Marshal(v)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error encoding status")
+		fmt.Fprintf(os.Stderr, "Error encoding response: %s\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -37,21 +58,19 @@ func writeStatus(w http.ResponseWriter) {
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
-	writeStatus(w)
+	writeJSON(w, currentStatus(requestConsumer(r)))
 }
 
 func startHandler(w http.ResponseWriter, r *http.Request) {
-	if !bot.IsRunning() {
-		go bot.Start(pollStop)
-	}
+	bot.Start(context.Background())
+	writeJSON(w, currentStatus(requestConsumer(r)))
 }
 
+// stopHandler stops the bot, responding once it has finished its current
+// poll.
 func stopHandler(w http.ResponseWriter, r *http.Request) {
-	if bot.IsRunning() {
-		go func() {
-			pollStop <- true
-		}()
-	}
+	bot.Stop()
+	writeJSON(w, currentStatus(requestConsumer(r)))
 }
 
 func itemsHandler(w http.ResponseWriter, r *http.Request) {
@@ -59,25 +78,236 @@ func itemsHandler(w http.ResponseWriter, r *http.Request) {
 	bot.DumpAll(w)
 }
 
+// searchHandler responds with the items matching the q parameter as json.
+// Setting the fuzzy parameter also matches words with typos.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	opts := paperboy.SearchOptions{Fuzzy: r.FormValue("fuzzy") != ""}
+	results, err := bot.SearchWith(r.FormValue("q"), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, results)
+}
+
+// trendingHandler responds with the terms rising the most in new titles.
+// The n parameter is the number of terms, 10 by default.
+func trendingHandler(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if s := r.FormValue("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n <= 0 {
+			http.Error(w, "n must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, bot.Trending(n))
+}
+
+// historyHandler responds with where the item with the url parameter has
+// been on its sources' pages.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	h, err := bot.History(r.FormValue("url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, h)
+}
+
+// unreadHandler responds with the consumer's unread items and marks them
+// read.
+func unreadHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, bot.Unread(requestConsumer(r)))
+}
+
+// peekHandler responds with the consumer's unread items.
+func peekHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, bot.Peek(requestConsumer(r)))
+}
+
+// streamHandler sends new items to the client as server-sent events, as
+// soon as the bot stores them. The source parameter limits the stream to a
+// single source.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	source := r.FormValue("source")
+	items, cancel := bot.Subscribe(func(item paperboy.Item) bool {
+		return source == "" || item.SourceName == source
+	})
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				return
+			}
+			enc, err := json.Marshal(item)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding item: %s\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", enc)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// readHandler marks the items whose URLs are given as url parameters read
+// for the consumer.
+func readHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	marked := bot.MarkRead(requestConsumer(r), r.Form["url"]...)
+	writeJSON(w, map[string]int{"marked": marked})
+}
+
+// queryHandler runs the read-only SQL in the q parameter against the
+// archive and responds with the result as json.
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	if bot.Archive == nil {
+		http.Error(w, "no archive configured", http.StatusNotFound)
+		return
+	}
+
+	query := r.FormValue("q")
+	if query == "" {
+		http.Error(w, "missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	result, err := bot.Archive.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// saveOnSignal saves the bot's items and exits when the process is
+// interrupted or terminated.
+func saveOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		bot.Stop()
+		if err := bot.Save(); err != nil {
+			log.Fatalf("Error saving items: %s\n", err)
+		}
+		os.Exit(0)
+	}()
+}
+
+// reloadOnChange applies config to the bot again when its file changes or
+// the process gets SIGHUP.
+func reloadOnChange(config *paperboy.Config) {
+	if config.Path() == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go bot.WatchConfig(context.Background(), config, paperboy.DefaultConfigCheck, hup,
+		func(changes paperboy.ConfigChanges, err error) {
+			if err != nil {
+				log.Printf("Error reloading %s: %s\n", config.Path(), err)
+				return
+			}
+			log.Printf("Reloaded %s: %s\n", config.Path(), strings.Replace(changes.String(), "\n", "; ", -1))
+		})
+}
+
 func main() {
+	paperboy.RegisterNotifierType("log", func(paperboy.NotifierConfig) (paperboy.Notifier, error) {
+		return paperboy.NotifierFunc(logAlert), nil
+	})
 
-	sources := []paperboy.Source{
-		paperboy.Source{
-			Name:        "HackerNews",
-			URL:         "https://news.ycombinator.com",
-			Selector:    ".storylink",
-			ConvertFunc: paperboy.AnchorConverter,
-		},
+	config := paperboy.DefaultConfig()
+	if err := config.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatal(err)
 	}
+	rulesPath = config.Storage.Rules
+	watchesPath = config.Storage.Watches
 
-	bot = paperboy.NewBot(sources)
-	pollStop = make(chan bool)
+	var err error
+	if bot, err = config.NewBot(); err != nil {
+		log.Fatal(err)
+	}
+	if bot.Archive != nil {
+		defer bot.Archive.Close()
+	}
+	if err := bot.Restore(); err != nil {
+		log.Printf("Error restoring items: %s\n", err)
+	}
+	saveOnSignal()
+	reloadOnChange(config)
+	if rulesPath != "" {
+		if err := loadRules(); err != nil {
+			log.Fatalf("Error loading rules: %s\n", err)
+		}
+	}
+	bot.AddNotifier("log", paperboy.NotifierFunc(logAlert))
+	if watchesPath != "" {
+		if err := loadWatches(); err != nil {
+			log.Fatalf("Error loading watches: %s\n", err)
+		}
+	}
 
 	http.Handle("/", http.FileServer(http.Dir("./static")))
 	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/sources", sourcesHandler)
+	http.HandleFunc("/sources/pause", pauseHandler(bot.PauseSource))
+	http.HandleFunc("/sources/resume", pauseHandler(bot.ResumeSource))
+	http.HandleFunc("/sources.opml", opmlHandler)
 	http.HandleFunc("/start", startHandler)
 	http.HandleFunc("/stop", stopHandler)
 	http.HandleFunc("/items", itemsHandler)
+	http.HandleFunc("/unread", unreadHandler)
+	http.HandleFunc("/peek", peekHandler)
+	http.HandleFunc("/read", readHandler)
+	http.HandleFunc("/stream", streamHandler)
+	http.HandleFunc("/rules", rulesHandler)
+	http.HandleFunc("/rules/reload", reloadRulesHandler)
+	http.HandleFunc("/suppressed", suppressedHandler)
+	http.HandleFunc("/watches", watchesHandler)
+	http.HandleFunc("/starred", listHandler(bot.Star, bot.Unstar, bot.Starred))
+	http.HandleFunc("/later", listHandler(bot.AddReadLater, bot.RemoveReadLater, bot.ReadLater))
+	http.HandleFunc("/tags", tagsHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/query", queryHandler)
+	http.HandleFunc("/trending", trendingHandler)
+	http.HandleFunc("/history", historyHandler)
+	http.HandleFunc("/digest", digestHandler)
+	http.HandleFunc("/sinks", sinksHandler)
+	http.HandleFunc("/deliveries", deliveriesHandler)
+	http.HandleFunc("/webhooks", webhooksHandler)
+	http.HandleFunc("/webhooks/queue", webhookQueueHandler)
+	http.HandleFunc("/webhooks/redeliver", deadLetterHandler(func(q *paperboy.WebhookQueue, ids []string) (int, error) {
+		return q.Redeliver(ids...)
+	}))
+	http.HandleFunc("/webhooks/purge", deadLetterHandler(func(q *paperboy.WebhookQueue, ids []string) (int, error) {
+		return q.Purge(ids...)
+	}))
+	http.HandleFunc("/feed.rss", feedHandler(paperboy.RSS))
+	http.HandleFunc("/feed.atom", feedHandler(paperboy.Atom))
+	http.HandleFunc("/feed.json", feedHandler(paperboy.JSONFeed))
 
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
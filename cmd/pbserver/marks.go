@@ -0,0 +1,65 @@
+package main
+
+// Handlers that star, tag and save items for later.
+
+import (
+	"github.com/jwriopel/paperboy"
+	"net/http"
+)
+
+// listHandler builds a handler for one of a consumer's item lists. It lists
+// the items on GET, runs add with the url parameter on POST and remove with
+// it on DELETE.
+func listHandler(add func(consumer, url string) error, remove func(consumer, url string) bool, list func(consumer string) []paperboy.Item) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		consumer := requestConsumer(r)
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := add(consumer, r.FormValue("url")); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		case http.MethodDelete:
+			if !remove(consumer, r.FormValue("url")) {
+				http.Error(w, "not on the list", http.StatusNotFound)
+				return
+			}
+		default:
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, list(consumer))
+	}
+}
+
+// tagsHandler responds on GET with the items with the tag parameter, or the
+// number of items with each tag when there isn't one. POST attaches the tag
+// parameters to the item with the url parameter and DELETE removes them,
+// both responding with the item's tags.
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	consumer := requestConsumer(r)
+	r.ParseForm()
+	url, tags := r.Form.Get("url"), r.Form["tag"]
+
+	switch r.Method {
+	case http.MethodGet:
+		if len(tags) == 0 {
+			writeJSON(w, bot.Tags(consumer))
+			return
+		}
+		writeJSON(w, bot.Tagged(consumer, tags[0]))
+		return
+	case http.MethodPost:
+		if err := bot.Tag(consumer, url, tags...); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	case http.MethodDelete:
+		bot.Untag(consumer, url, tags...)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, bot.ItemTags(consumer, url))
+}
@@ -0,0 +1,88 @@
+package main
+
+// Handlers that manage the bot's filtering rules.
+
+import (
+	"encoding/json"
+	"github.com/jwriopel/paperboy"
+	"net/http"
+	"os"
+)
+
+// rulesPath is the json file rules are loaded from.
+var rulesPath string
+
+// loadRules replaces the bot's rules with the ones in rulesPath.
+func loadRules() error {
+	f, err := os.Open(rulesPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rules, err := paperboy.ReadRules(f)
+	if err != nil {
+		return err
+	}
+	return bot.SetRules(rules)
+}
+
+// rulesHandler lists the rules on GET, adds or replaces the rule in the
+// json body on POST, replaces every rule with the json list in the body on
+// PUT and removes the rule in the name parameter on DELETE.
+func rulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var rule paperboy.Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := bot.AddRule(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodPut:
+		rules, err := paperboy.ReadRules(r.Body)
+		if err == nil {
+			err = bot.SetRules(rules)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		if !bot.RemoveRule(r.FormValue("name")) {
+			http.Error(w, "no such rule", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, bot.Rules())
+}
+
+// reloadRulesHandler reloads the rules from the file pbserver was started
+// with.
+func reloadRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if rulesPath == "" {
+		http.Error(w, "no rules file", http.StatusNotFound)
+		return
+	}
+	if err := loadRules(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, bot.Rules())
+}
+
+// suppressedHandler lists the items dropped by a rule.
+func suppressedHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, bot.Suppressed())
+}
@@ -0,0 +1,75 @@
+package main
+
+// Handlers that show where new items are delivered.
+
+import (
+	"github.com/jwriopel/paperboy"
+	"net/http"
+	"sort"
+)
+
+func sinksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, bot.Sinks())
+}
+
+func deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, bot.Deliveries())
+}
+
+// webhookSummary describes a webhook's queue.
+type webhookSummary struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Pending int    `json:"pending"`
+	Dead    int    `json:"dead"`
+}
+
+// webhooksHandler lists the webhook queues.
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	queues := bot.WebhookQueues()
+	summaries := make([]webhookSummary, 0, len(queues))
+	for name, q := range queues {
+		summaries = append(summaries, webhookSummary{name, q.Path(), len(q.Pending()), len(q.Dead())})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	writeJSON(w, summaries)
+}
+
+// webhookQueueHandler responds with the pending posts of the webhook in the
+// name parameter, or its dead letters when dead is set.
+func webhookQueueHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := bot.WebhookQueues()[r.FormValue("name")]
+	if !ok {
+		http.Error(w, "no such webhook queue", http.StatusNotFound)
+		return
+	}
+	if r.FormValue("dead") != "" {
+		writeJSON(w, q.Dead())
+		return
+	}
+	writeJSON(w, q.Pending())
+}
+
+// deadLetterHandler does something with the dead letters of the webhook in
+// the name parameter, the ones in the id parameters or all of them, and
+// responds with the ones left.
+func deadLetterHandler(do func(*paperboy.WebhookQueue, []string) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		q, ok := bot.WebhookQueues()[r.FormValue("name")]
+		if !ok {
+			http.Error(w, "no such webhook queue", http.StatusNotFound)
+			return
+		}
+		if _, err := do(q, r.Form["id"]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, q.Dead())
+	}
+}
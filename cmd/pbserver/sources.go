@@ -0,0 +1,90 @@
+package main
+
+// Handlers that change the bot's sources while it runs.
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jwriopel/paperboy"
+	"net/http"
+	"os"
+)
+
+// sourcesHandler lists the sources on GET, adds the source in the json body
+// on POST and removes the source in the name parameter on DELETE.
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var source paperboy.Source
+		if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := bot.AddSource(source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		found, err := bot.RemoveSource(r.FormValue("name"))
+		if !found {
+			http.Error(w, "no such source", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, bot.Sources())
+}
+
+// pauseHandler builds a handler that pauses or resumes, with do, the source
+// in the name parameter.
+func pauseHandler(do func(string) (bool, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		found, err := do(r.FormValue("name"))
+		if !found {
+			http.Error(w, "no such source", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, bot.Sources())
+	}
+}
+
+// opmlHandler responds with the sources as OPML on GET, and adds the feeds
+// in the OPML body on POST, responding with the sources added.
+func opmlHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		if err := paperboy.WriteOPML(w, bot.Sources()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing OPML: %s\n", err)
+		}
+	case http.MethodPost:
+		sources, err := paperboy.ReadOPML(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		added, err := bot.ImportSources(sources)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, added)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
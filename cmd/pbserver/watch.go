@@ -0,0 +1,72 @@
+package main
+
+// Handlers that manage the bot's watchlists.
+
+import (
+	"encoding/json"
+	"github.com/jwriopel/paperboy"
+	"log"
+	"net/http"
+	"os"
+)
+
+// watchesPath is the json file watches are loaded from.
+var watchesPath string
+
+// logAlert is the "log" notifier, it writes alerts to the server's log.
+func logAlert(n paperboy.Notification) error {
+	log.Print(n.Text())
+	return nil
+}
+
+// loadWatches replaces the bot's watches with the ones in watchesPath.
+func loadWatches() error {
+	f, err := os.Open(watchesPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	watches, err := paperboy.ReadWatches(f)
+	if err != nil {
+		return err
+	}
+	return bot.SetWatches(watches)
+}
+
+// watchesHandler lists the watches on GET, adds or replaces the watch in the
+// json body on POST, replaces every watch with the json list in the body on
+// PUT and removes the watch in the name parameter on DELETE.
+func watchesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var watch paperboy.Watch
+		if err := json.NewDecoder(r.Body).Decode(&watch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := bot.AddWatch(watch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodPut:
+		watches, err := paperboy.ReadWatches(r.Body)
+		if err == nil {
+			err = bot.SetWatches(watches)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		if !bot.RemoveWatch(r.FormValue("name")) {
+			http.Error(w, "no such watch", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, bot.Watches())
+}
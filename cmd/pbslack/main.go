@@ -2,54 +2,171 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"flag"
 	"fmt"
 	"github.com/google/logger"
 	"github.com/jwriopel/commands"
 	"github.com/jwriopel/paperboy"
+	"github.com/jwriopel/paperboy/cmd/internal/botcmd"
 	"golang.org/x/net/websocket"
 	"io"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 )
 
 var sentItems map[string]paperboy.Item
 var cmdMap map[string]func([]string) string
 
-func writeItem(w io.Writer, item paperboy.Item) {
-	fmt.Fprintf(w, "[%s] %s - %s\n", item.SourceName, item.Title, item.URL)
+// slackStyle marks the commands' replies up in Slack's mrkdwn.
+var slackStyle = botcmd.Style{
+	Program:  "pbslack",
+	Code:     func(s string) string { return "`" + s + "`" },
+	Bold:     func(s string) string { return "*" + s + "*" },
+	Quote:    "> ",
+	Link:     slackURL,
+	Target:   slackChannel,
+	Digest:   paperboy.SlackText,
+	Notifier: "slack",
 }
 
-func main() {
+// slackURL undoes Slack's link formatting, <https://example.com|label>.
+func slackURL(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "<"), ">")
+	if i := strings.Index(s, "|"); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
 
-	sources := []paperboy.Source{
-		paperboy.Source{
-			Name:        "HackerNews",
-			URL:         "https://news.ycombinator.com",
-			Selector:    ".storylink",
-			ConvertFunc: paperboy.AnchorConverter,
-		},
-		paperboy.Source{
-			Name:        "Reddit",
-			URL:         "https://www.reddit.com",
-			Selector:    "a.title",
-			ConvertFunc: paperboy.RedditConverter,
-		},
+// slackChannel undoes Slack's channel mentions, <#C024BE7LR|name>.
+func slackChannel(s string) string {
+	s = strings.TrimPrefix(strings.TrimSuffix(s, ">"), "<#")
+	if i := strings.Index(s, "|"); i >= 0 {
+		s = s[:i]
 	}
+	return s
+}
 
-	bot := paperboy.NewBot(sources)
-	stopper := make(chan bool)
-	cmdBuffer := new(bytes.Buffer)
+// saveOnSignal saves the bot's items and exits when the process is
+// interrupted or terminated.
+func saveOnSignal(bot *paperboy.Bot) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		bot.Stop()
+		if err := bot.Save(); err != nil {
+			logger.Errorf("Error saving items: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
 
-	commands.Add(startCommand(bot, cmdBuffer, stopper))
-	commands.Add(stopCommand(bot, cmdBuffer, stopper))
-	commands.Add(statusCommand(bot, cmdBuffer))
-	commands.Add(showCommand(bot, cmdBuffer))
-	commands.Add(searchCommand(bot, cmdBuffer))
+// reloadOnChange applies config to the bot again when its file changes or
+// the process gets SIGHUP.
+func reloadOnChange(bot *paperboy.Bot, config *paperboy.Config) {
+	if config.Path() == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go bot.WatchConfig(context.Background(), config, paperboy.DefaultConfigCheck, hup,
+		func(changes paperboy.ConfigChanges, err error) {
+			if err != nil {
+				logger.Errorf("Error reloading %s: %s\n", config.Path(), err)
+				return
+			}
+			logger.Infof("Reloaded %s: %s\n", config.Path(), strings.Replace(changes.String(), "\n", "; ", -1))
+		})
+}
+
+func main() {
+	config := paperboy.DefaultConfig()
+	if err := config.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		logger.Fatal(err)
+	}
 
-	wsurl, botId := paperboy.StartRTM()
+	token := config.Credentials["slack"]
+	if token == "" {
+		token = os.Getenv("SLACK_API_TOKEN")
+	}
+	wsurl, botId := paperboy.StartRTMWithToken(token)
 	ws, err := websocket.Dial(wsurl, "", "https://api.slack.com")
 	if err != nil {
 		logger.Fatal(err)
 	}
+	paperboy.RegisterNotifierType("slack", slackNotifierType(ws))
+
+	bot, err := config.NewBot()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	bot.AddNotifier("slack", slackNotifier(ws))
+	if err := bot.Restore(); err != nil {
+		logger.Errorf("Error restoring items: %s\n", err)
+	}
+	saveOnSignal(bot)
+	reloadOnChange(bot, config)
+	if config.Storage.Rules != "" {
+		if err := botcmd.LoadRules(bot, config.Storage.Rules); err != nil {
+			logger.Fatalf("Error loading rules: %s\n", err)
+		}
+	}
+	if config.Storage.Watches != "" {
+		if err := botcmd.LoadWatches(bot, config.Storage.Watches); err != nil {
+			logger.Fatalf("Error loading watches: %s\n", err)
+		}
+	}
+	cmdBuffer := new(bytes.Buffer)
+	// each Slack user reads items separately.
+	var consumer string
+	// the channel a command was sent in.
+	var channel string
+
+	cmds := &botcmd.Builder{Bot: bot, W: cmdBuffer, Consumer: &consumer, Channel: &channel, Style: slackStyle}
+
+	commands.Add(cmds.StartCommand())
+	commands.Add(cmds.StopCommand())
+	commands.Add(cmds.StatusCommand())
+	commands.Add(cmds.SourcesCommand())
+	commands.Add(cmds.AddSourceCommand())
+	commands.Add(cmds.RemoveSourceCommand())
+	commands.Add(cmds.PauseCommand())
+	commands.Add(cmds.ResumeCommand())
+	commands.Add(cmds.ShowCommand())
+	commands.Add(cmds.PeekCommand())
+	commands.Add(cmds.SearchCommand())
+	commands.Add(cmds.TrendingCommand())
+	commands.Add(cmds.HistoryCommand())
+	commands.Add(cmds.DigestCommand())
+	commands.Add(cmds.SinksCommand())
+	commands.Add(cmds.DeliveriesCommand())
+	commands.Add(cmds.WebhooksCommand())
+	commands.Add(cmds.QueueCommand())
+	commands.Add(cmds.RedeliverCommand())
+	commands.Add(cmds.PurgeCommand())
+	commands.Add(cmds.RulesCommand())
+	commands.Add(cmds.AddRuleCommand())
+	commands.Add(cmds.RemoveRuleCommand())
+	commands.Add(cmds.ReloadRulesCommand(config.Storage.Rules))
+	commands.Add(cmds.ReloadCommand(config))
+	commands.Add(cmds.SuppressedCommand())
+	commands.Add(cmds.WatchesCommand())
+	commands.Add(cmds.WatchCommand())
+	commands.Add(cmds.UnwatchCommand())
+	commands.Add(cmds.StarCommand())
+	commands.Add(cmds.UnstarCommand())
+	commands.Add(cmds.StarredCommand())
+	commands.Add(cmds.TagCommand())
+	commands.Add(cmds.UntagCommand())
+	commands.Add(cmds.TagsCommand())
+	commands.Add(cmds.LaterCommand())
+	commands.Add(cmds.UnlaterCommand())
+	commands.Add(cmds.ReadLaterCommand())
 
 	for {
 		m, err := paperboy.GetMessage(ws)
@@ -64,6 +181,8 @@ func main() {
 			cmdLine := m.Text
 			cmdParts := strings.Split(cmdLine, " ")
 			cmdBuffer.Reset()
+			consumer = "slack:" + m.User
+			channel = m.Channel
 			err = commands.Run(strings.Join(cmdParts[1:], " "))
 			if err != nil {
 				m.Text = fmt.Sprintf("error running `%s`: %s\n", cmdLine, err)
@@ -0,0 +1,42 @@
+package main
+
+// Notifiers that post alerts and digests to Slack.
+
+import (
+	"fmt"
+	"github.com/jwriopel/paperboy"
+	"golang.org/x/net/websocket"
+)
+
+// slackNotifier posts alerts and digests to the Slack channel in the
+// notification's To.
+func slackNotifier(ws *websocket.Conn) paperboy.Notifier {
+	return paperboy.NotifierFunc(func(n paperboy.Notification) error {
+		if n.To == "" {
+			return fmt.Errorf("no channel to alert")
+		}
+		return paperboy.PostMessage(ws, paperboy.Message{
+			Type:    "message",
+			Channel: n.To,
+			Text:    n.Render(paperboy.SlackText),
+		})
+	})
+}
+
+// slackNotifierType builds the Slack notifiers in the config, they post to
+// their channel setting unless a watch says where. Notifiers with a webhook
+// setting post to that incoming webhook instead of through the bot.
+func slackNotifierType(ws *websocket.Conn) func(paperboy.NotifierConfig) (paperboy.Notifier, error) {
+	return func(nc paperboy.NotifierConfig) (paperboy.Notifier, error) {
+		post := slackNotifier(ws)
+		if nc.Settings["webhook"] != "" {
+			post = &paperboy.SlackWebhook{URL: nc.Settings["webhook"]}
+		}
+		return paperboy.NotifierFunc(func(n paperboy.Notification) error {
+			if n.To == "" {
+				n.To = nc.Settings["channel"]
+			}
+			return post.Notify(n)
+		}), nil
+	}
+}
@@ -1,62 +1,54 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"github.com/fatih/color"
 	"github.com/jwriopel/paperboy"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	sources := []paperboy.Source{
-		paperboy.Source{
-			Name:        "HackerNews",
-			Url:         "https://news.ycombinator.com",
-			Selector:    ".storylink",
-			ConvertFunc: paperboy.AnchorConverter,
-		},
-		paperboy.Source{
-			Name:        "Reddit",
-			Url:         "https://www.reddit.com",
-			Selector:    "a.title",
-			ConvertFunc: paperboy.RedditConverter,
-		},
+	config := paperboy.DefaultConfig()
+	config.Poll = time.Duration(120) * time.Second
+	if err := config.ParseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+
+	bot, err := config.NewBot()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	colors := make(map[string]color.Attribute)
-	for i, source := range sources {
+	for i, source := range bot.Sources() {
 		colors[source.Name] = color.Attribute((i + 1) + 30)
 	}
 
-	seenItems := make(map[string]paperboy.Item)
-	ichan := make(chan paperboy.Item, 100)
-
-	getItems := func() {
-		for item := range paperboy.GetAll(sources) {
-			if _, seen := seenItems[item.Url]; !seen {
-				ichan <- item
-				seenItems[item.Url] = item
-			}
-		}
-
+	if config.Path() != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go bot.WatchConfig(context.Background(), config, paperboy.DefaultConfigCheck, hup,
+			func(changes paperboy.ConfigChanges, err error) {
+				if err != nil {
+					log.Printf("Error reloading %s: %s\n", config.Path(), err)
+					return
+				}
+				log.Printf("Reloaded %s: %s\n", config.Path(), strings.Replace(changes.String(), "\n", "; ", -1))
+			})
 	}
 
-	go func() {
-		pollTimer := time.Tick(time.Duration(120) * time.Second)
-		for {
-			select {
-			case <-pollTimer:
-				getItems()
-			}
-		}
-	}()
+	items, _ := bot.Subscribe(nil)
+	bot.Start(context.Background())
 
-	getItems()
-	for {
-		for item := range ichan {
-			color.Set(colors[item.SourceName])
-			log.Printf("%s - [%s] %s\n", item.Title, item.SourceName, item.Url)
-			color.Unset()
-		}
+	for item := range items {
+		color.Set(colors[item.SourceName])
+		log.Printf("%s - [%s] %s\n", item.Title, item.SourceName, item.URL)
+		color.Unset()
 	}
 }
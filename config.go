@@ -0,0 +1,638 @@
+package paperboy
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is what the paperboy binaries share: sources, settings, storage,
+// notifiers and credentials. It's read from a YAML file, see ReadConfig.
+type Config struct {
+	Sources []Source `yaml:"sources"`
+	// Poll is how often the sources are polled.
+	Poll      time.Duration   `yaml:"poll"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Autosave  AutosavePolicy  `yaml:"autosave"`
+	Retention RetentionPolicy `yaml:"retention"`
+	Ranking   RankPolicy      `yaml:"ranking"`
+	Trends    TrendPolicy     `yaml:"trends"`
+	// Notifiers are added to the Bot by name, for watches to alert.
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+	// Digests are sent to the notifiers on their schedules.
+	Digests []ScheduledDigest `yaml:"digests"`
+	// Sinks send new items to the notifiers as they're found.
+	Sinks []Sink `yaml:"sinks"`
+	// Credentials are secrets by name, like "slack" for the Slack API
+	// token. $VAR and ${VAR} in them are replaced with environment
+	// variables, so the secrets don't have to be in the file.
+	Credentials map[string]string `yaml:"credentials"`
+
+	// how ParseFlags loaded the config, for Reload.
+	path     string
+	defaults *Config
+	flags    map[string]string
+}
+
+// StorageConfig is where the Bot keeps what it saves. Empty paths aren't
+// used. The snapshot path is Config.Autosave.Path.
+type StorageConfig struct {
+	// Archive is the SQLite database items are archived in.
+	Archive string `yaml:"archive"`
+	// Sources is the json file sources changed at runtime are saved to,
+	// it overrides Config.Sources once it exists.
+	Sources string `yaml:"sources"`
+	// Rules and Watches are the json files rules and watchlists are
+	// loaded from.
+	Rules   string `yaml:"rules"`
+	Watches string `yaml:"watches"`
+}
+
+// NotifierConfig configures a notifier of a registered type, see
+// RegisterNotifierType.
+type NotifierConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Settings are the notifier's other keys, what they mean depends on
+	// the type. Environment variables in them are replaced like in
+	// Config.Credentials.
+	Settings map[string]string `yaml:",inline"`
+}
+
+// DefaultConfig polls Hacker News and Reddit, keeping nothing on disk.
+func DefaultConfig() *Config {
+	return &Config{
+		Sources: []Source{
+			{
+				Name:      "HackerNews",
+				URL:       "https://news.ycombinator.com",
+				Selector:  ".storylink",
+				Converter: "hackernews",
+			},
+			{
+				Name:      "Reddit",
+				URL:       "https://www.reddit.com",
+				Selector:  "a.title",
+				Converter: "reddit",
+			},
+		},
+		Poll: 10 * time.Second,
+		Autosave: AutosavePolicy{
+			Interval: 5 * time.Minute,
+			Changes:  100,
+			Backups:  3,
+		},
+		Retention: DefaultRetention,
+		Ranking:   DefaultRanking,
+		Trends:    DefaultTrends,
+	}
+}
+
+// notifierTypes maps type names to the functions that build notifiers of
+// that type.
+var notifierTypes = map[string]func(NotifierConfig) (Notifier, error){
+	"stdout":  newStdoutNotifier,
+	"file":    newFileNotifier,
+	"webhook": newWebhookNotifier,
+	"smtp":    newSMTPNotifier,
+	"slack":   newSlackNotifier,
+}
+
+var notifierTypesMux sync.RWMutex
+
+// RegisterNotifierType makes notifiers built by build available to configs
+// as typ, replacing any type already registered as typ. Types have to be
+// registered before the configs using them are read. The stdout, file,
+// webhook, smtp and slack types are built in.
+func RegisterNotifierType(typ string, build func(NotifierConfig) (Notifier, error)) {
+	notifierTypesMux.Lock()
+	notifierTypes[typ] = build
+	notifierTypesMux.Unlock()
+}
+
+// NotifierTypes returns the names of the registered notifier types.
+func NotifierTypes() []string {
+	notifierTypesMux.RLock()
+	defer notifierTypesMux.RUnlock()
+
+	names := make([]string, 0, len(notifierTypes))
+	for name := range notifierTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// notifierType returns the function that builds notifiers of type typ.
+func notifierType(typ string) (func(NotifierConfig) (Notifier, error), bool) {
+	notifierTypesMux.RLock()
+	defer notifierTypesMux.RUnlock()
+	build, ok := notifierTypes[typ]
+	return build, ok
+}
+
+// ConfigError is a problem with a config file.
+type ConfigError struct {
+	File string
+	// Line is where in the file the problem is, starting at 1, or 0 if
+	// it's not known.
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// ConfigErrors are all the problems found in a config file.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// yamlLine matches the line numbers yaml puts in its error messages.
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors turns the errors of decoding file into ConfigErrors.
+func yamlErrors(file string, err error) error {
+	var msgs []string
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+
+	errs := make(ConfigErrors, len(msgs))
+	for i, msg := range msgs {
+		e := &ConfigError{File: file, Msg: msg}
+		if m := yamlLine.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+		}
+		errs[i] = e
+	}
+	return errs
+}
+
+// ReadConfig reads a YAML config from r over c, keys that aren't in the file
+// keep their values in c. Problems are reported as ConfigErrors, with the
+// line they're on, file being the name used for r.
+func (c *Config) ReadConfig(r io.Reader, file string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return yamlErrors(file, err)
+	}
+	if len(root.Content) == 0 {
+		// an empty file changes nothing.
+		return nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return yamlErrors(file, err)
+	}
+
+	for name, value := range c.Credentials {
+		c.Credentials[name] = os.ExpandEnv(value)
+	}
+	for _, nc := range c.Notifiers {
+		for key, value := range nc.Settings {
+			nc.Settings[key] = os.ExpandEnv(value)
+		}
+	}
+
+	problems := c.validate()
+	if len(problems) == 0 {
+		return nil
+	}
+	errs := make(ConfigErrors, len(problems))
+	for i, p := range problems {
+		errs[i] = &ConfigError{File: file, Line: nodeLine(&root, p.path...), Msg: p.msg}
+	}
+	return errs
+}
+
+// LoadConfig reads the YAML config at path over the defaults.
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	if err := c.readFile(path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configProblem is something wrong with the value at path in a config,
+// path being keys and sequence indexes.
+type configProblem struct {
+	path []interface{}
+	msg  string
+}
+
+// Validate checks that c makes sense, like LoadConfig does but without line
+// numbers.
+func (c *Config) Validate() error {
+	problems := c.validate()
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", problems[0].msg)
+}
+
+func (c *Config) validate() []configProblem {
+	problems := make([]configProblem, 0)
+	problem := func(msg string, path ...interface{}) {
+		problems = append(problems, configProblem{path, msg})
+	}
+
+	names := make(map[string]bool)
+	for i, source := range c.Sources {
+		if err := source.Validate(); err != nil {
+			problem(err.Error(), "sources", i)
+		}
+		if names[source.Name] {
+			problem("duplicate source name "+source.Name, "sources", i, "name")
+		}
+		names[source.Name] = true
+	}
+
+	if c.Poll <= 0 {
+		problem("poll must be more than 0", "poll")
+	}
+
+	durations := []struct {
+		d    time.Duration
+		path []interface{}
+	}{
+		{c.Autosave.Interval, []interface{}{"autosave", "interval"}},
+		{c.Retention.MaxAge, []interface{}{"retention", "max_age"}},
+		{c.Retention.Remember, []interface{}{"retention", "remember"}},
+		{c.Ranking.HalfLife, []interface{}{"ranking", "half_life"}},
+		{c.Trends.Window, []interface{}{"trends", "window"}},
+		{c.Trends.Baseline, []interface{}{"trends", "baseline"}},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			problem(d.path[len(d.path)-1].(string)+" can't be negative", d.path...)
+		}
+	}
+
+	counts := []struct {
+		n    int
+		path []interface{}
+	}{
+		{c.Autosave.Changes, []interface{}{"autosave", "changes"}},
+		{c.Autosave.Backups, []interface{}{"autosave", "backups"}},
+		{c.Retention.MaxItems, []interface{}{"retention", "max_items"}},
+		{c.Trends.MinCount, []interface{}{"trends", "min_count"}},
+	}
+	for _, n := range counts {
+		if n.n < 0 {
+			problem(n.path[len(n.path)-1].(string)+" can't be negative", n.path...)
+		}
+	}
+
+	for source, quota := range c.Retention.SourceQuota {
+		if quota < 0 {
+			problem("quota can't be negative", "retention", "source_quota", source)
+		}
+	}
+	for source, weight := range c.Ranking.SourceWeight {
+		if weight < 0 {
+			problem("weight can't be negative", "ranking", "source_weight", source)
+		}
+	}
+
+	notifiers := make(map[string]bool)
+	for i, n := range c.Notifiers {
+		switch {
+		case n.Name == "":
+			problem("notifier has no name", "notifiers", i)
+		case notifiers[n.Name]:
+			problem("duplicate notifier name "+n.Name, "notifiers", i, "name")
+		}
+		notifiers[n.Name] = true
+
+		if _, ok := notifierType(n.Type); !ok {
+			problem(fmt.Sprintf("unknown notifier type %q, use one of %s", n.Type, strings.Join(NotifierTypes(), ", ")),
+				"notifiers", i, "type")
+		}
+	}
+
+	digests := make(map[string]bool)
+	for i, d := range c.Digests {
+		if err := d.compile(); err != nil {
+			problem(err.Error(), "digests", i)
+		}
+		if digests[d.Name] {
+			problem("duplicate digest name "+d.Name, "digests", i, "name")
+		}
+		digests[d.Name] = true
+	}
+
+	sinks := make(map[string]bool)
+	for i, s := range c.Sinks {
+		if err := s.compile(); err != nil {
+			problem(err.Error(), "sinks", i)
+		} else if s.MinScore != 0 {
+			if err := checkScoreSources(c.Sources, s.Sources); err != nil {
+				problem(fmt.Sprintf("sink %s: %s", s.Name, err), "sinks", i, "min_score")
+			}
+		}
+		if sinks[s.Name] {
+			problem("duplicate sink name "+s.Name, "sinks", i, "name")
+		}
+		sinks[s.Name] = true
+	}
+	return problems
+}
+
+// nodeLine returns the line of the value at path in the YAML document root,
+// or of the closest parent that's in the document.
+func nodeLine(root *yaml.Node, path ...interface{}) int {
+	n := root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	line := n.Line
+	for _, p := range path {
+		var next *yaml.Node
+		switch key := p.(type) {
+		case string:
+			if n.Kind != yaml.MappingNode {
+				return line
+			}
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == key {
+					next = n.Content[i+1]
+					line = n.Content[i].Line
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && key < len(n.Content) {
+				next = n.Content[key]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			return line
+		}
+		n = next
+	}
+	return line
+}
+
+// weightsValue is a flag.Value for source weights, see ParseSourceWeights.
+type weightsValue struct {
+	weights *map[string]float64
+}
+
+func (v weightsValue) String() string {
+	if v.weights == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*v.weights))
+	for source, weight := range *v.weights {
+		pairs = append(pairs, fmt.Sprintf("%s=%g", source, weight))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v weightsValue) Set(s string) error {
+	weights, err := ParseSourceWeights(s)
+	if err != nil {
+		return err
+	}
+	*v.weights = weights
+	return nil
+}
+
+// clone returns a copy of c that shares nothing with it.
+func (c *Config) clone() *Config {
+	n := *c
+	n.Sources = append([]Source(nil), c.Sources...)
+	n.Notifiers = make([]NotifierConfig, len(c.Notifiers))
+	for i, nc := range c.Notifiers {
+		n.Notifiers[i] = nc
+		n.Notifiers[i].Settings = copyMap(nc.Settings)
+	}
+	n.Digests = make([]ScheduledDigest, len(c.Digests))
+	for i, d := range c.Digests {
+		n.Digests[i] = d
+		n.Digests[i].Sources = append([]string(nil), d.Sources...)
+	}
+	n.Sinks = make([]Sink, len(c.Sinks))
+	for i, s := range c.Sinks {
+		n.Sinks[i] = s
+		n.Sinks[i].Sources = append([]string(nil), s.Sources...)
+	}
+	n.Credentials = copyMap(c.Credentials)
+	if c.Retention.SourceQuota != nil {
+		n.Retention.SourceQuota = make(map[string]int, len(c.Retention.SourceQuota))
+		for source, quota := range c.Retention.SourceQuota {
+			n.Retention.SourceQuota[source] = quota
+		}
+	}
+	if c.Ranking.SourceWeight != nil {
+		n.Ranking.SourceWeight = make(map[string]float64, len(c.Ranking.SourceWeight))
+		for source, weight := range c.Ranking.SourceWeight {
+			n.Ranking.SourceWeight[source] = weight
+		}
+	}
+	return &n
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	n := make(map[string]string, len(m))
+	for k, v := range m {
+		n[k] = v
+	}
+	return n
+}
+
+// ParseFlags defines the flags the binaries share on fs, including -config,
+// and parses args. Settings come from c, then from the config file, then
+// from the flags that were given.
+func (c *Config) ParseFlags(fs *flag.FlagSet, args []string) error {
+	defaults := c.clone()
+	path := fs.String("config", "", "YAML file of sources and settings.")
+	c.defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return c.Validate()
+	}
+
+	if err := c.readFile(*path); err != nil {
+		return err
+	}
+	// the flags that were given win over the file.
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// remember the flags, Reload applies them over the file again.
+	shared := flag.NewFlagSet("", flag.ContinueOnError)
+	defaults.clone().defineFlags(shared)
+	c.path, c.defaults = *path, defaults
+	c.flags = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if shared.Lookup(f.Name) != nil {
+			c.flags[f.Name] = f.Value.String()
+		}
+	})
+	return c.Validate()
+}
+
+// Path returns the file the config was read from by ParseFlags, if any.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Reload reads the config's file again, over the same defaults and with the
+// same flags as when it was first read.
+func (c *Config) Reload() (*Config, error) {
+	if c.path == "" {
+		return nil, fmt.Errorf("the config wasn't read from a file")
+	}
+
+	next := c.defaults.clone()
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	next.defineFlags(fs)
+	if err := next.readFile(c.path); err != nil {
+		return nil, err
+	}
+	for name, value := range c.flags {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("-%s: %s", name, err)
+		}
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	next.path, next.defaults, next.flags = c.path, c.defaults, c.flags
+	return next, nil
+}
+
+// readFile reads the config file at path over c.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.ReadConfig(f, path)
+}
+
+// defineFlags defines flags on fs that set c's settings.
+func (c *Config) defineFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&c.Poll, "poll", c.Poll, "How often to poll the sources.")
+	fs.StringVar(&c.Storage.Archive, "archive", c.Storage.Archive, "SQLite database to archive items in.")
+	fs.StringVar(&c.Storage.Sources, "sources", c.Storage.Sources, "Json file the list of sources is loaded from and saved to.")
+	fs.StringVar(&c.Storage.Rules, "rules", c.Storage.Rules, "Json file of rules new items are filtered with.")
+	fs.StringVar(&c.Storage.Watches, "watches", c.Storage.Watches, "Json file of watchlists new items are alerted for.")
+	fs.StringVar(&c.Autosave.Path, "state", c.Autosave.Path, "File the bot's items are saved to and restored from.")
+	fs.DurationVar(&c.Autosave.Interval, "autosave", c.Autosave.Interval, "How often to save the bot's items.")
+	fs.IntVar(&c.Autosave.Changes, "save-every", c.Autosave.Changes, "Save after this many changes to the bot's items.")
+	fs.IntVar(&c.Autosave.Backups, "backups", c.Autosave.Backups, "Number of previous saves to keep.")
+	fs.DurationVar(&c.Retention.MaxAge, "max-age", c.Retention.MaxAge, "Evict read items older than this, 0 keeps them.")
+	fs.IntVar(&c.Retention.MaxItems, "max-items", c.Retention.MaxItems, "Number of read items to keep, 0 keeps them all.")
+	fs.DurationVar(&c.Ranking.HalfLife, "half-life", c.Ranking.HalfLife, "Time for an unread item's rank to halve, 0 doesn't decay ranks.")
+	fs.Var(weightsValue{&c.Ranking.SourceWeight}, "weights", "Rank weights of sources, like HackerNews=2,Reddit=0.5.")
+}
+
+// NewBot creates a Bot with c's sources and settings, restoring its sources
+// from c.Storage.Sources and opening its archive. The snapshot isn't
+// restored, see Bot.Restore.
+func (c *Config) NewBot() (*Bot, error) {
+	b := NewBot(append([]Source(nil), c.Sources...))
+	b.PollFrequency = c.Poll
+	b.Autosave = c.Autosave
+	b.Retention = c.Retention
+	b.Ranking = c.Ranking
+	b.Trends = c.Trends
+	b.config = c
+
+	b.SourcesPath = c.Storage.Sources
+	if err := b.RestoreSources(); err != nil {
+		return nil, err
+	}
+
+	// notifiers and sinks are only started once everything that can fail
+	// has been checked.
+	notifiers := make([]Notifier, len(c.Notifiers))
+	for i, nc := range c.Notifiers {
+		build, ok := notifierType(nc.Type)
+		if !ok {
+			return nil, fmt.Errorf("notifier %s: unknown type %q", nc.Name, nc.Type)
+		}
+		n, err := build(nc)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %s", nc.Name, err)
+		}
+		notifiers[i] = n
+	}
+	if err := b.SetDigests(c.Digests); err != nil {
+		return nil, err
+	}
+	sinks, err := compileSinks(c.Sinks)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sinks {
+		if s.MinScore == 0 {
+			continue
+		}
+		if err := checkScoreSources(b.sources, s.Sources); err != nil {
+			return nil, fmt.Errorf("sink %s: %s", s.Name, err)
+		}
+	}
+
+	if c.Storage.Archive != "" {
+		archive, err := OpenArchive(c.Storage.Archive)
+		if err != nil {
+			return nil, err
+		}
+		b.Archive = archive
+	}
+
+	for i, nc := range c.Notifiers {
+		b.AddNotifier(nc.Name, notifiers[i])
+	}
+	for _, s := range sinks {
+		if err := b.AddSink(s); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,233 @@
+package paperboy
+
+import (
+	"github.com/google/logger"
+	"sort"
+	"time"
+)
+
+// logEntry is an item in the Bot's item log. Entries are kept in the order
+// they were added, seq increasing with each one.
+type logEntry struct {
+	item Item
+	seq  int
+	// restored entries were loaded from a snapshot, they count as read by
+	// every consumer.
+	restored bool
+}
+
+// cursor is a consumer's position in the item log.
+type cursor struct {
+	// pos is the seq of the first entry that may be unread, every entry
+	// before it has been read.
+	pos int
+	// read holds the URLs of entries at or after pos that were marked read
+	// one at a time.
+	read map[string]bool
+}
+
+func newCursor() *cursor {
+	return &cursor{read: make(map[string]bool)}
+}
+
+// isRead reports whether the consumer at c has read e.
+func (c *cursor) isRead(e *logEntry) bool {
+	return e.restored || e.seq < c.pos || c.read[e.item.URL]
+}
+
+// cursor returns the named consumer's cursor, creating it when create is
+// set. A consumer that doesn't exist yet is given a cursor at the start of
+// the log that isn't stored. b.mux must be held.
+func (b *Bot) cursor(consumer string, create bool) *cursor {
+	c, ok := b.consumers[consumer]
+	if !ok {
+		c = newCursor()
+		if create {
+			b.consumers[consumer] = c
+		}
+	}
+	return c
+}
+
+// readByAll reports whether every consumer has read e. Without consumers
+// only restored entries that were read count, nobody has seen the others.
+// b.mux must be held.
+func (b *Bot) readByAll(e *logEntry) bool {
+	if len(b.consumers) == 0 {
+		return e.restored
+	}
+	for _, c := range b.consumers {
+		if !c.isRead(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// logIndex returns the index of the first entry in the log with a seq of
+// at least seq. b.mux must be held.
+func (b *Bot) logIndex(seq int) int {
+	return sort.Search(len(b.log), func(i int) bool {
+		return b.log[i].seq >= seq
+	})
+}
+
+// appendEntry adds item to the end of the log. b.mux must be held.
+func (b *Bot) appendEntry(item Item, restored bool) *logEntry {
+	e := &logEntry{item: item, seq: b.nextSeq, restored: restored}
+	b.nextSeq++
+	b.log = append(b.log, e)
+	b.entries[item.URL] = e
+	b.index.Add(item)
+	return e
+}
+
+// removeEntry drops the item with url from the log. b.mux must be held.
+func (b *Bot) removeEntry(url string) {
+	e, ok := b.entries[url]
+	if !ok {
+		return
+	}
+
+	i := b.logIndex(e.seq)
+	b.log = append(b.log[:i], b.log[i+1:]...)
+	delete(b.entries, url)
+	for _, c := range b.consumers {
+		delete(c.read, url)
+	}
+	b.index.Remove(url)
+}
+
+// unreadEntries returns the entries c hasn't read, oldest first. b.mux
+// must be held.
+func (b *Bot) unreadEntries(c *cursor) []*logEntry {
+	unread := make([]*logEntry, 0)
+	for _, e := range b.log[b.logIndex(c.pos):] {
+		if !c.isRead(e) {
+			unread = append(unread, e)
+		}
+	}
+	return unread
+}
+
+// advance moves c past the entries it has read. b.mux must be held.
+func (b *Bot) advance(c *cursor) {
+	for _, e := range b.log[b.logIndex(c.pos):] {
+		if !c.isRead(e) {
+			return
+		}
+		c.pos = e.seq + 1
+		delete(c.read, e.item.URL)
+	}
+	c.pos = b.nextSeq
+	c.read = make(map[string]bool)
+}
+
+func entryItems(entries []*logEntry) []Item {
+	items := make([]Item, len(entries))
+	for i, e := range entries {
+		items[i] = e.item
+	}
+	return items
+}
+
+// recordReads stores read events in the archive, if there is one.
+func (b *Bot) recordReads(consumer string, items []Item, now time.Time) {
+	if b.Archive == nil || len(items) == 0 {
+		return
+	}
+	if err := b.Archive.RecordReads(consumer, items, now); err != nil {
+		logger.Errorf("Error archiving reads: %s\n", err)
+	}
+}
+
+// Unread returns the items consumer hasn't read, ranked by the Bot's
+// Ranking, and marks them read for that consumer only. Consumers are named
+// by the front ends, like a Slack user or the web UI, and are created the
+// first time they read.
+func (b *Bot) Unread(consumer string) []Item {
+	b.mux.Lock()
+
+	now := time.Now()
+	c := b.cursor(consumer, true)
+	items := entryItems(b.unreadEntries(c))
+	for _, item := range items {
+		b.touch(item.URL, now)
+	}
+	b.Ranking.Sort(items, now)
+	c.pos = b.nextSeq
+	c.read = make(map[string]bool)
+
+	evicted := b.evict(now)
+	b.mux.Unlock()
+
+	b.changed(len(items) + evicted)
+	b.recordReads(consumer, items, now)
+	return items
+}
+
+// Peek returns the items consumer hasn't read, ranked like Unread, without
+// marking them read.
+func (b *Bot) Peek(consumer string) []Item {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	items := entryItems(b.unreadEntries(b.cursor(consumer, false)))
+	b.Ranking.Sort(items, time.Now())
+	return items
+}
+
+// MarkRead marks the items with the given ids, their URLs, read for
+// consumer. It returns the number of items that were unread.
+func (b *Bot) MarkRead(consumer string, ids ...string) int {
+	b.mux.Lock()
+
+	now := time.Now()
+	c := b.cursor(consumer, true)
+	items := make([]Item, 0, len(ids))
+	for _, id := range ids {
+		e, ok := b.entries[id]
+		if !ok || c.isRead(e) {
+			continue
+		}
+		c.read[id] = true
+		b.touch(id, now)
+		items = append(items, e.item)
+	}
+	b.advance(c)
+
+	evicted := b.evict(now)
+	b.mux.Unlock()
+
+	b.changed(len(items) + evicted)
+	b.recordReads(consumer, items, now)
+	return len(items)
+}
+
+// NPending returns the number of items consumer hasn't read.
+func (b *Bot) NPending(consumer string) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.unreadEntries(b.cursor(consumer, false)))
+}
+
+// Consumers returns the names of the consumers that have read items.
+func (b *Bot) Consumers() []string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	names := make([]string, 0, len(b.consumers))
+	for name := range b.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RemoveConsumer forgets a consumer's read position, so its unread items
+// no longer keep read items from being evicted.
+func (b *Bot) RemoveConsumer(consumer string) {
+	b.mux.Lock()
+	delete(b.consumers, consumer)
+	b.mux.Unlock()
+}
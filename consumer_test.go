@@ -0,0 +1,62 @@
+package paperboy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// addItems stores n items from source in b, as if they were just polled.
+func addItems(b *Bot, source string, n int) []Item {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	items := make([]Item, n)
+	for i := range items {
+		items[i] = Item{Title: fmt.Sprintf("%s %d", source, i), URL: fmt.Sprintf("https://example.com/%s/%d", source, i), SourceName: source, FirstSeen: time.Now()}
+		b.appendEntry(items[i], false)
+	}
+	return items
+}
+
+func TestItemsWithoutConsumersStayUnread(t *testing.T) {
+	b := NewBot(nil)
+	b.Retention = RetentionPolicy{MaxItems: 1, Remember: time.Hour}
+	items := addItems(b, "a", 3)
+
+	// nobody has seen the items yet.
+	if n := b.Evict(); n != 0 {
+		t.Fatalf("evicted %d items before any consumer read them", n)
+	}
+	b.Retention.MaxItems = 0
+	b.Flush()
+	if n := b.CacheSize(); n != 3 {
+		t.Fatalf("%d items left after flushing with no consumers, want 3", n)
+	}
+	if got := b.Unread("alice"); len(got) != 3 {
+		t.Fatalf("alice has %d unread items, want 3", len(got))
+	}
+
+	// once the only consumer is removed, newer items are kept for the
+	// next one.
+	b.RemoveConsumer("alice")
+	addItems(b, "b", 2)
+	b.Flush()
+	if n := b.CacheSize(); n != 5 {
+		t.Fatalf("%d items left after flushing, want 5", n)
+	}
+	if got := b.Unread("bob"); len(got) != 5 {
+		t.Fatalf("bob has %d unread items, want 5", len(got))
+	}
+
+	// items every consumer has read go.
+	b.Flush()
+	if n := b.CacheSize(); n != 0 {
+		t.Fatalf("%d items left after every consumer read them", n)
+	}
+	b.mux.Lock()
+	evicted := b.wasEvicted(items[0].URL, time.Now())
+	b.mux.Unlock()
+	if !evicted {
+		t.Fatal("a flushed item isn't remembered")
+	}
+}
@@ -0,0 +1,653 @@
+package paperboy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/logger"
+	"html"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TextFormat is a markup messages, like digests, can be rendered in.
+type TextFormat string
+
+const (
+	// PlainText has no markup.
+	PlainText TextFormat = "text"
+	// Markdown is CommonMark.
+	Markdown TextFormat = "markdown"
+	// HTML is an HTML fragment, for a page or an email.
+	HTML TextFormat = "html"
+	// SlackText is Slack's mrkdwn.
+	SlackText TextFormat = "slack"
+)
+
+// ParseTextFormat returns the format called s, "text", "markdown", "html"
+// or "slack".
+func ParseTextFormat(s string) (TextFormat, error) {
+	switch f := TextFormat(s); f {
+	case PlainText, Markdown, HTML, SlackText:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, use text, markdown, html or slack", s)
+}
+
+// ContentType is the media type of text in format f.
+func (f TextFormat) ContentType() string {
+	switch f {
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// DigestGrouping is how a digest's items are grouped.
+type DigestGrouping string
+
+const (
+	// BySource groups items by their source.
+	BySource DigestGrouping = "source"
+	// ByTopic groups items that share a term in their titles, like the
+	// terms that trend.
+	ByTopic DigestGrouping = "topic"
+)
+
+// DigestOptions selects and groups the items of a digest.
+type DigestOptions struct {
+	// Window is how far back the digest goes, DefaultDigestWindow when
+	// it's zero.
+	Window time.Duration `yaml:"window"`
+	// Limit is the number of items, the best ranked are kept.
+	// DefaultDigestLimit is used when it's zero.
+	Limit int `yaml:"limit"`
+	// GroupBy is BySource when it's empty.
+	GroupBy DigestGrouping `yaml:"group_by"`
+	// Sources limits the digest to items from the named sources.
+	Sources []string `yaml:"sources"`
+	// Query limits the digest to items matching a search, see ParseQuery.
+	Query string `yaml:"query"`
+}
+
+const (
+	// DefaultDigestWindow makes digests cover a day.
+	DefaultDigestWindow = 24 * time.Hour
+	// DefaultDigestLimit is the number of items in a digest when
+	// DigestOptions doesn't say.
+	DefaultDigestLimit = 10
+)
+
+// validate checks that opts make sense.
+func (opts DigestOptions) validate() error {
+	if opts.Window < 0 {
+		return fmt.Errorf("window can't be negative")
+	}
+	if opts.Limit < 0 {
+		return fmt.Errorf("limit can't be negative")
+	}
+	switch opts.GroupBy {
+	case "", BySource, ByTopic:
+	default:
+		return fmt.Errorf("unknown grouping %q, use %s or %s", opts.GroupBy, BySource, ByTopic)
+	}
+	if opts.Query != "" {
+		if _, err := ParseQuery(opts.Query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Digest is the best items of a period, grouped.
+type Digest struct {
+	Title string
+	// From and To are the period the items were first seen in.
+	From, To time.Time
+	GroupBy  DigestGrouping
+	Groups   []DigestGroup
+}
+
+// DigestGroup is the items of a digest from a source or on a topic, best
+// first.
+type DigestGroup struct {
+	Name  string
+	Items []DigestItem
+}
+
+// DigestItem is an item in a digest.
+type DigestItem struct {
+	Item
+	// Also are the other sources the same story was on.
+	Also []string
+}
+
+// Items returns the digest's items, group by group.
+func (d Digest) Items() []Item {
+	items := make([]Item, 0)
+	for _, g := range d.Groups {
+		for _, di := range g.Items {
+			items = append(items, di.Item)
+		}
+	}
+	return items
+}
+
+// Len returns the number of items in the digest.
+func (d Digest) Len() int {
+	n := 0
+	for _, g := range d.Groups {
+		n += len(g.Items)
+	}
+	return n
+}
+
+// Digest returns the best ranked items first seen in the last opts.Window,
+// read or not, with duplicates posted to several sources listed once, and
+// grouped. Building a digest doesn't mark its items read or used.
+func (b *Bot) Digest(opts DigestOptions) (Digest, error) {
+	return b.digest(opts, time.Now())
+}
+
+func (b *Bot) digest(opts DigestOptions, now time.Time) (Digest, error) {
+	if err := opts.validate(); err != nil {
+		return Digest{}, err
+	}
+	var q Query
+	if opts.Query != "" {
+		var err error
+		if q, err = ParseQuery(opts.Query); err != nil {
+			return Digest{}, err
+		}
+	}
+
+	window := opts.Window
+	if window == 0 {
+		window = DefaultDigestWindow
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultDigestLimit
+	}
+	from := now.Add(-window)
+
+	b.mux.Lock()
+	var candidates []Item
+	if q != nil {
+		candidates = b.index.Match(q)
+	} else {
+		candidates = make([]Item, 0, len(b.log))
+		for _, e := range b.log {
+			candidates = append(candidates, e.item)
+		}
+	}
+	items := candidates[:0]
+	for _, item := range candidates {
+		if item.FirstSeen.Before(from) || item.FirstSeen.After(now) {
+			continue
+		}
+		if len(opts.Sources) > 0 && !containsString(opts.Sources, item.SourceName) {
+			continue
+		}
+		items = append(items, item)
+	}
+	b.Ranking.Sort(items, now)
+	b.mux.Unlock()
+
+	ranked := dedupeItems(items)
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	d := Digest{Title: "paperboy digest", From: from, To: now, GroupBy: opts.GroupBy}
+	if d.GroupBy == "" {
+		d.GroupBy = BySource
+	}
+	if d.GroupBy == ByTopic {
+		d.Groups = groupByTopic(ranked)
+	} else {
+		d.Groups = groupBySource(ranked)
+	}
+	return d, nil
+}
+
+// storyKeys returns the keys an item is the same story as other items by:
+// its URL without what doesn't change the page, and its title's words.
+func storyKeys(item Item) []string {
+	keys := make([]string, 0, 2)
+	if u, err := url.Parse(item.URL); err == nil && u.Host != "" {
+		query := u.Query()
+		for name := range query {
+			if strings.HasPrefix(name, "utm_") {
+				query.Del(name)
+			}
+		}
+		host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+		keys = append(keys, "url:"+host+strings.TrimSuffix(u.EscapedPath(), "/")+"?"+query.Encode())
+	} else {
+		keys = append(keys, "url:"+item.URL)
+	}
+
+	if title := strings.Join(words(normalize(item.Title)), " "); title != "" {
+		keys = append(keys, "title:"+title)
+	}
+	return keys
+}
+
+// dedupeItems drops items that are the same story as an item ahead of them,
+// noting their sources on it.
+func dedupeItems(items []Item) []DigestItem {
+	kept := make([]DigestItem, 0, len(items))
+	stories := make(map[string]int)
+	for _, item := range items {
+		keys := storyKeys(item)
+		i, dup := -1, false
+		for _, key := range keys {
+			if i, dup = stories[key]; dup {
+				break
+			}
+		}
+
+		if !dup {
+			i = len(kept)
+			kept = append(kept, DigestItem{Item: item})
+		} else if di := &kept[i]; item.SourceName != di.SourceName && !containsString(di.Also, item.SourceName) {
+			di.Also = append(di.Also, item.SourceName)
+		}
+		for _, key := range keys {
+			if _, ok := stories[key]; !ok {
+				stories[key] = i
+			}
+		}
+	}
+	return kept
+}
+
+// groupBySource groups items by source, the source with the best item
+// first.
+func groupBySource(items []DigestItem) []DigestGroup {
+	groups := make([]DigestGroup, 0)
+	index := make(map[string]int)
+	for _, item := range items {
+		i, ok := index[item.SourceName]
+		if !ok {
+			i = len(groups)
+			index[item.SourceName] = i
+			groups = append(groups, DigestGroup{Name: item.SourceName})
+		}
+		groups[i].Items = append(groups[i].Items, item)
+	}
+	return groups
+}
+
+// groupByTopic groups items by the term in their title that's in the most
+// other titles, preferring pairs of words. Items that don't share a term
+// go last, under "Other".
+func groupByTopic(items []DigestItem) []DigestGroup {
+	terms := make([]map[string]string, len(items))
+	counts := make(map[string]int)
+	for i, item := range items {
+		terms[i] = trendTerms(item.Title)
+		for key := range terms[i] {
+			counts[key]++
+		}
+	}
+
+	groups := make([]DigestGroup, 0)
+	index := make(map[string]int)
+	var other []DigestItem
+	for i, item := range items {
+		best := ""
+		for key := range terms[i] {
+			if counts[key] < 2 {
+				continue
+			}
+			if best == "" || counts[key] > counts[best] ||
+				counts[key] == counts[best] && betterTopic(key, best) {
+				best = key
+			}
+		}
+		if best == "" {
+			other = append(other, item)
+			continue
+		}
+
+		g, ok := index[best]
+		if !ok {
+			g = len(groups)
+			index[best] = g
+			groups = append(groups, DigestGroup{Name: terms[i][best]})
+		}
+		groups[g].Items = append(groups[g].Items, item)
+	}
+
+	if len(other) > 0 {
+		groups = append(groups, DigestGroup{Name: "Other", Items: other})
+	}
+	return groups
+}
+
+// betterTopic reports whether term a makes a better topic than b when
+// they're in as many titles: pairs are more specific than words.
+func betterTopic(a, b string) bool {
+	wa, wb := strings.Count(a, " "), strings.Count(b, " ")
+	if wa != wb {
+		return wa > wb
+	}
+	return a < b
+}
+
+// summary describes the digest's period.
+func (d Digest) summary() string {
+	if d.From.IsZero() {
+		return ""
+	}
+	n := d.Len()
+	what := fmt.Sprintf("%d items", n)
+	if n == 1 {
+		what = "1 item"
+	}
+	layout := "Jan 2 15:04"
+	return fmt.Sprintf("%s from %s to %s", what, d.From.Format(layout), d.To.Format(layout))
+}
+
+// Render renders the digest in format.
+func (d Digest) Render(format TextFormat) string {
+	var buf bytes.Buffer
+	summary := d.summary()
+	// the source is the heading when items are grouped by it.
+	showSource := d.GroupBy != BySource
+
+	switch format {
+	case Markdown:
+		fmt.Fprintf(&buf, "# %s\n\n", markdownEscape(d.Title))
+		if summary != "" {
+			fmt.Fprintf(&buf, "_%s_\n\n", summary)
+		}
+	case HTML:
+		fmt.Fprintf(&buf, "<h1>%s</h1>\n", html.EscapeString(d.Title))
+		if summary != "" {
+			fmt.Fprintf(&buf, "<p>%s</p>\n", html.EscapeString(summary))
+		}
+	case SlackText:
+		fmt.Fprintf(&buf, "*%s*\n", slackEscape(d.Title))
+		if summary != "" {
+			fmt.Fprintf(&buf, "_%s_\n", summary)
+		}
+	default:
+		fmt.Fprintln(&buf, d.Title)
+		if summary != "" {
+			fmt.Fprintln(&buf, summary)
+		}
+	}
+
+	if d.Len() == 0 {
+		switch format {
+		case HTML:
+			fmt.Fprintln(&buf, "<p>Nothing new.</p>")
+		default:
+			fmt.Fprintln(&buf, "Nothing new.")
+		}
+		return buf.String()
+	}
+
+	for _, g := range d.Groups {
+		switch {
+		case g.Name == "":
+		case format == Markdown:
+			fmt.Fprintf(&buf, "## %s\n\n", markdownEscape(g.Name))
+		case format == HTML:
+			fmt.Fprintf(&buf, "<h2>%s</h2>\n", html.EscapeString(g.Name))
+		case format == SlackText:
+			fmt.Fprintf(&buf, "\n*%s*\n", slackEscape(g.Name))
+		default:
+			fmt.Fprintf(&buf, "\n%s\n", g.Name)
+		}
+
+		if format == HTML {
+			fmt.Fprintln(&buf, "<ul>")
+		}
+		for _, item := range g.Items {
+			renderDigestItem(&buf, format, item, showSource)
+		}
+		switch format {
+		case HTML:
+			fmt.Fprintln(&buf, "</ul>")
+		case Markdown:
+			fmt.Fprintln(&buf)
+		}
+	}
+	return buf.String()
+}
+
+// renderDigestItem writes a line for item in format.
+func renderDigestItem(buf *bytes.Buffer, format TextFormat, item DigestItem, showSource bool) {
+	source, also := "", ""
+	if showSource {
+		source = "[" + item.SourceName + "] "
+	}
+	if len(item.Also) > 0 {
+		also = " (also on " + strings.Join(item.Also, ", ") + ")"
+	}
+
+	switch format {
+	case Markdown:
+		link := strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(item.URL)
+		fmt.Fprintf(buf, "- %s[%s](%s)%s\n", markdownEscape(source), markdownEscape(item.Title), link, markdownEscape(also))
+	case HTML:
+		fmt.Fprintf(buf, "<li>%s<a href=\"%s\">%s</a>%s</li>\n", html.EscapeString(source),
+			html.EscapeString(item.URL), html.EscapeString(item.Title), html.EscapeString(also))
+	case SlackText:
+		fmt.Fprintf(buf, "• %s<%s|%s>%s\n", slackEscape(source), slackEscape(item.URL),
+			strings.Replace(slackEscape(item.Title), "|", "¦", -1), slackEscape(also))
+	default:
+		fmt.Fprintf(buf, "- %s%s - %s%s\n", source, item.Title, item.URL, also)
+	}
+}
+
+// markdownEscape escapes the characters that would be taken as markup.
+var markdownEscape = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "`", "\\`", "<", `\<`,
+).Replace
+
+// slackEscape escapes the characters Slack requires escaped.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+// ScheduledDigest sends a digest to a notifier on a schedule.
+type ScheduledDigest struct {
+	Name string `yaml:"name"`
+	// Schedule is when the digest is sent, see ParseSchedule.
+	Schedule string `yaml:"schedule"`
+	// Timezone is the IANA time zone the schedule is in, like
+	// "Europe/Paris", the local one when it's empty.
+	Timezone string `yaml:"timezone"`
+	// Notifier is the name of the notifier the digest is sent with, To is
+	// passed on to it.
+	Notifier      string `yaml:"notifier"`
+	To            string `yaml:"to"`
+	DigestOptions `yaml:",inline"`
+
+	schedule Schedule
+	loc      *time.Location
+}
+
+// compile validates d and prepares its schedule.
+func (d *ScheduledDigest) compile() error {
+	if d.Name == "" {
+		return fmt.Errorf("digest has no name")
+	}
+	if d.Notifier == "" {
+		return fmt.Errorf("digest %s has no notifier", d.Name)
+	}
+
+	schedule, err := ParseSchedule(d.Schedule)
+	if err != nil {
+		return fmt.Errorf("digest %s: %s", d.Name, err)
+	}
+	loc := time.Local
+	if d.Timezone != "" {
+		if loc, err = time.LoadLocation(d.Timezone); err != nil {
+			return fmt.Errorf("digest %s: %s", d.Name, err)
+		}
+	}
+	if err := d.DigestOptions.validate(); err != nil {
+		return fmt.Errorf("digest %s: %s", d.Name, err)
+	}
+	d.schedule, d.loc = schedule, loc
+	return nil
+}
+
+// Next returns when the digest is next sent after t, or the zero time if
+// it's never sent.
+func (d ScheduledDigest) Next(t time.Time) time.Time {
+	if d.loc == nil {
+		return time.Time{}
+	}
+	return d.schedule.Next(t.In(d.loc))
+}
+
+// String describes the digest in one line.
+func (d ScheduledDigest) String() string {
+	s := fmt.Sprintf("%s: %q to %s", d.Name, d.Schedule, d.Notifier)
+	if d.To != "" {
+		s += " " + d.To
+	}
+	if d.Timezone != "" {
+		s += " (" + d.Timezone + ")"
+	}
+	return s
+}
+
+// compileDigests validates digests, returning compiled copies.
+func compileDigests(digests []ScheduledDigest) ([]ScheduledDigest, error) {
+	compiled := make([]ScheduledDigest, len(digests))
+	names := make(map[string]bool)
+	for i, d := range digests {
+		if err := d.compile(); err != nil {
+			return nil, err
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("duplicate digest name %s", d.Name)
+		}
+		names[d.Name] = true
+		compiled[i] = d
+	}
+	return compiled, nil
+}
+
+// SetDigests replaces the Bot's scheduled digests, which are sent while it's
+// running. Digests that were due while the Bot was stopped aren't sent. The
+// Bot's digests are left alone if any of digests is invalid.
+func (b *Bot) SetDigests(digests []ScheduledDigest) error {
+	compiled, err := compileDigests(digests)
+	if err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	b.digests = compiled
+	b.digestDue = nil
+	b.reconfigure()
+	b.mux.Unlock()
+	return nil
+}
+
+// Digests returns a copy of the Bot's scheduled digests.
+func (b *Bot) Digests() []ScheduledDigest {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return append([]ScheduledDigest(nil), b.digests...)
+}
+
+// AddDigest schedules a digest, replacing any digest with the same name.
+func (b *Bot) AddDigest(d ScheduledDigest) error {
+	if err := d.compile(); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	delete(b.digestDue, d.Name)
+	b.reconfigure()
+	for i := range b.digests {
+		if b.digests[i].Name == d.Name {
+			b.digests[i] = d
+			return nil
+		}
+	}
+	b.digests = append(b.digests, d)
+	return nil
+}
+
+// RemoveDigest unschedules the named digest, reporting whether it existed.
+func (b *Bot) RemoveDigest(name string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for i := range b.digests {
+		if b.digests[i].Name == name {
+			b.digests = append(b.digests[:i], b.digests[i+1:]...)
+			delete(b.digestDue, name)
+			b.reconfigure()
+			return true
+		}
+	}
+	return false
+}
+
+// nextDigest returns when the next digest is due, or the zero time if none
+// are scheduled.
+func (b *Bot) nextDigest(now time.Time) time.Time {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.digestDue == nil {
+		b.digestDue = make(map[string]time.Time)
+	}
+	var next time.Time
+	for _, d := range b.digests {
+		due, ok := b.digestDue[d.Name]
+		if !ok {
+			due = d.Next(now)
+			b.digestDue[d.Name] = due
+		}
+		if !due.IsZero() && (next.IsZero() || due.Before(next)) {
+			next = due
+		}
+	}
+	return next
+}
+
+// sendDigests sends the digests that are due at now, logging the ones that
+// fail. Empty digests aren't sent.
+func (b *Bot) sendDigests(now time.Time) {
+	b.mux.Lock()
+	due := make([]ScheduledDigest, 0)
+	for _, d := range b.digests {
+		if at, ok := b.digestDue[d.Name]; ok && !at.IsZero() && !at.After(now) {
+			due = append(due, d)
+			b.digestDue[d.Name] = d.Next(now)
+		}
+	}
+	b.mux.Unlock()
+
+	for _, d := range due {
+		digest, err := b.digest(d.DigestOptions, now)
+		if err != nil {
+			logger.Errorf("Error building digest %s: %s\n", d.Name, err)
+			continue
+		}
+		if digest.Len() == 0 {
+			continue
+		}
+
+		digest.Title = d.Name
+		n := Notification{Subject: d.Name, To: d.To, Items: digest.Items(), Digest: &digest}
+		if err := b.Notify(d.Notifier, n); err != nil {
+			logger.Errorf("Error sending digest %s: %s\n", d.Name, err)
+		}
+	}
+}
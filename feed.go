@@ -0,0 +1,343 @@
+package paperboy
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// FeedFormat is a syndication format the Bot's items can be written in.
+type FeedFormat string
+
+const (
+	// RSS is RSS 2.0.
+	RSS FeedFormat = "rss"
+	// Atom is Atom 1.0.
+	Atom FeedFormat = "atom"
+	// JSONFeed is JSON Feed 1.1.
+	JSONFeed FeedFormat = "json"
+)
+
+// ParseFeedFormat returns the format called s, "rss", "atom" or "json".
+func ParseFeedFormat(s string) (FeedFormat, error) {
+	switch f := FeedFormat(s); f {
+	case RSS, Atom, JSONFeed:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown feed format %q, use rss, atom or json", s)
+}
+
+// ContentType is the media type of feeds in format f.
+func (f FeedFormat) ContentType() string {
+	switch f {
+	case Atom:
+		return "application/atom+xml; charset=utf-8"
+	case JSONFeed:
+		return "application/feed+json; charset=utf-8"
+	}
+	return "application/rss+xml; charset=utf-8"
+}
+
+// Feed is a list of items to syndicate.
+type Feed struct {
+	Title       string
+	Description string
+	// Link is the page the feed is about, FeedURL is where the feed itself
+	// is served. Either can be empty.
+	Link    string
+	FeedURL string
+	Updated time.Time
+	// Items are written in order, usually newest first.
+	Items []Item
+}
+
+// FeedOptions selects the items of a feed.
+type FeedOptions struct {
+	// Source limits the feed to items from the named source.
+	Source string
+	// Query limits the feed to items matching a search, see ParseQuery.
+	Query string
+	// Limit is the number of items, the newest are kept. DefaultFeedLimit
+	// is used when it's zero.
+	Limit int
+}
+
+// DefaultFeedLimit is the number of items in a feed when FeedOptions
+// doesn't say.
+const DefaultFeedLimit = 50
+
+// Feed returns the Bot's items selected by opts, read or not, newest first.
+// Reading the feed doesn't mark its items read or used.
+func (b *Bot) Feed(opts FeedOptions) (Feed, error) {
+	var q Query
+	if opts.Query != "" {
+		var err error
+		if q, err = ParseQuery(opts.Query); err != nil {
+			return Feed{}, err
+		}
+	}
+
+	b.mux.Lock()
+	var items []Item
+	if q != nil {
+		items = b.index.Match(q)
+	} else {
+		items = make([]Item, 0, len(b.log))
+		for _, e := range b.log {
+			items = append(items, e.item)
+		}
+	}
+	b.mux.Unlock()
+
+	kept := items[:0]
+	for _, item := range items {
+		if opts.Source == "" || item.SourceName == opts.Source {
+			kept = append(kept, item)
+		}
+	}
+	items = kept
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].FirstSeen.After(items[j].FirstSeen)
+	})
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultFeedLimit
+	}
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	f := Feed{Title: "paperboy", Description: "News collected by paperboy", Items: items}
+	switch {
+	case opts.Source != "" && opts.Query != "":
+		f.Title = fmt.Sprintf("paperboy: %s on %s", opts.Query, opts.Source)
+	case opts.Source != "":
+		f.Title = "paperboy: " + opts.Source
+	case opts.Query != "":
+		f.Title = "paperboy: " + opts.Query
+	}
+	if len(items) > 0 {
+		f.Updated = items[0].FirstSeen
+	}
+	return f, nil
+}
+
+// WriteFeed writes f to w in format.
+func WriteFeed(w io.Writer, format FeedFormat, f Feed) error {
+	switch format {
+	case RSS:
+		return WriteRSS(w, f)
+	case Atom:
+		return WriteAtom(w, f)
+	case JSONFeed:
+		return WriteJSONFeed(w, f)
+	}
+	return fmt.Errorf("unknown feed format %q", format)
+}
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title    string  `xml:"title"`
+	Link     string  `xml:"link"`
+	GUID     rssGUID `xml:"guid"`
+	PubDate  string  `xml:"pubDate,omitempty"`
+	Category string  `xml:"category,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// WriteRSS writes f to w as RSS 2.0.
+func WriteRSS(w io.Writer, f Feed) error {
+	doc := rssDoc{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Description,
+			Items:       make([]rssItem, len(f.Items)),
+		},
+	}
+	if !f.Updated.IsZero() {
+		doc.Channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+
+	for i, item := range f.Items {
+		ri := rssItem{
+			Title:    item.Title,
+			Link:     item.URL,
+			GUID:     rssGUID{IsPermaLink: true, Value: item.URL},
+			Category: item.SourceName,
+		}
+		if !item.FirstSeen.IsZero() {
+			ri.PubDate = item.FirstSeen.Format(time.RFC1123Z)
+		}
+		doc.Channel.Items[i] = ri
+	}
+	return writeXML(w, doc)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title    string        `xml:"title"`
+	ID       string        `xml:"id"`
+	Updated  string        `xml:"updated"`
+	Link     atomLink      `xml:"link"`
+	Category *atomCategory `xml:"category,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// WriteAtom writes f to w as Atom 1.0.
+func WriteAtom(w io.Writer, f Feed) error {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	// Atom requires an id, the feed's own URL is the most stable one.
+	id := f.FeedURL
+	if id == "" {
+		id = f.Link
+	}
+	if id == "" {
+		id = "urn:paperboy:" + url.PathEscape(f.Title)
+	}
+
+	doc := atomFeed{
+		Title:   f.Title,
+		ID:      id,
+		Updated: updated.Format(time.RFC3339),
+		Author:  atomAuthor{Name: "paperboy"},
+		Entries: make([]atomEntry, len(f.Items)),
+	}
+	if f.Link != "" {
+		doc.Links = append(doc.Links, atomLink{Href: f.Link})
+	}
+	if f.FeedURL != "" {
+		doc.Links = append(doc.Links, atomLink{Href: f.FeedURL, Rel: "self"})
+	}
+
+	for i, item := range f.Items {
+		seen := item.FirstSeen
+		if seen.IsZero() {
+			seen = updated
+		}
+		entry := atomEntry{
+			Title:   item.Title,
+			ID:      item.URL,
+			Updated: seen.Format(time.RFC3339),
+			Link:    atomLink{Href: item.URL},
+		}
+		if item.SourceName != "" {
+			entry.Category = &atomCategory{Term: item.SourceName}
+		}
+		doc.Entries[i] = entry
+	}
+	return writeXML(w, doc)
+}
+
+// writeXML writes v to w as an indented xml document.
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentText   string   `json:"content_text"`
+	DatePublished string   `json:"date_published,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+// WriteJSONFeed writes f to w as JSON Feed 1.1.
+func WriteJSONFeed(w io.Writer, f Feed) error {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		Description: f.Description,
+		HomePageURL: f.Link,
+		FeedURL:     f.FeedURL,
+		Items:       make([]jsonFeedItem, len(f.Items)),
+	}
+
+	for i, item := range f.Items {
+		// items need content, the title is all there is.
+		ji := jsonFeedItem{
+			ID:          item.URL,
+			URL:         item.URL,
+			Title:       item.Title,
+			ContentText: item.Title,
+		}
+		if !item.FirstSeen.IsZero() {
+			ji.DatePublished = item.FirstSeen.Format(time.RFC3339)
+		}
+		if item.SourceName != "" {
+			ji.Tags = []string{item.SourceName}
+		}
+		doc.Items[i] = ji
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,97 @@
+package paperboy
+
+import (
+	"encoding/xml"
+	"golang.org/x/net/html/charset"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Source types, how a source's page is turned into items.
+const (
+	// HTMLSource pages are scraped with the source's Selector and
+	// converter.
+	HTMLSource = "html"
+	// FeedSource pages are RSS or Atom feeds, every entry is an item.
+	FeedSource = "feed"
+)
+
+// feedDoc is an RSS 2.0, RSS 1.0 or Atom document. Elements are matched by
+// name only, so the different namespaces don't matter.
+type feedDoc struct {
+	Channel struct {
+		Items []feedEntry `xml:"item"`
+	} `xml:"channel"`
+	// RSS 1.0 puts items next to the channel.
+	Items   []feedEntry `xml:"item"`
+	Entries []feedEntry `xml:"entry"`
+}
+
+type feedEntry struct {
+	Title string     `xml:"title"`
+	Links []feedLink `xml:"link"`
+	GUID  string     `xml:"guid"`
+	// Comments is the slash module's comment count, RSS's own comments
+	// element is a URL and isn't matched.
+	Comments string `xml:"http://purl.org/rss/1.0/modules/slash/ comments"`
+}
+
+// feedLink is an RSS link, the URL is its text, or an Atom link, the URL is
+// its href.
+type feedLink struct {
+	Href  string `xml:"href,attr"`
+	Rel   string `xml:"rel,attr"`
+	Value string `xml:",chardata"`
+}
+
+// link returns the entry's URL: an Atom alternate link, an RSS link or a
+// permalink guid.
+func (e feedEntry) link() string {
+	for _, l := range e.Links {
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") {
+			return l.Href
+		}
+	}
+	for _, l := range e.Links {
+		if s := strings.TrimSpace(l.Value); s != "" {
+			return s
+		}
+	}
+	if guid := strings.TrimSpace(e.GUID); strings.HasPrefix(guid, "http") {
+		return guid
+	}
+	return ""
+}
+
+// parseFeed returns the items of the RSS or Atom feed in r, in feed order.
+// Relative links are resolved against base.
+func parseFeed(r io.Reader, base *url.URL) ([]Item, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = charset.NewReaderLabel
+
+	doc := feedDoc{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	entries := append(doc.Channel.Items, doc.Items...)
+	entries = append(entries, doc.Entries...)
+	items := make([]Item, 0, len(entries))
+	for _, e := range entries {
+		link := e.link()
+		if link == "" {
+			continue
+		}
+		if u, err := base.Parse(link); err == nil {
+			link = u.String()
+		}
+		items = append(items, Item{
+			Title:    strings.Join(strings.Fields(e.Title), " "),
+			URL:      link,
+			Comments: parseCount(e.Comments),
+		})
+	}
+	return items, nil
+}
@@ -0,0 +1,43 @@
+package paperboy
+
+import (
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// HackerNewsConverter converts Hacker News title links to items, with the
+// points and comment count from the line under each title.
+func HackerNewsConverter(matches []*html.Node) []Item {
+	items := AnchorConverter(matches)
+	for i, match := range matches {
+		row := findAncestor(match, func(n *html.Node) bool {
+			return n.Data == "tr" && hasClass(n, "athing")
+		})
+		if row == nil {
+			continue
+		}
+		subtext := nextElement(row)
+		if subtext == nil {
+			continue
+		}
+
+		if score := findElement(subtext, func(n *html.Node) bool {
+			return hasClass(n, "score")
+		}); score != nil {
+			items[i].Score = parseCount(nodeText(score))
+		}
+		// the comments link reads "12 comments", or "discuss" when there
+		// aren't any.
+		findElement(subtext, func(n *html.Node) bool {
+			if n.Data != "a" {
+				return false
+			}
+			if text := nodeText(n); strings.Contains(text, "comment") {
+				items[i].Comments = parseCount(text)
+				return true
+			}
+			return false
+		})
+	}
+	return items
+}
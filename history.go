@@ -0,0 +1,117 @@
+package paperboy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Sighting is a stretch of polls during which an item stayed at the same
+// position on a source's page.
+type Sighting struct {
+	Source   string `json:"source"`
+	Position int    `json:"position"`
+	// From is when the item got to Position, To is the last poll it was
+	// seen there.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// History is where an item has been on its sources' pages over time.
+type History struct {
+	Item Item `json:"item"`
+	// Sightings are oldest first.
+	Sightings []Sighting `json:"sightings"`
+	// Peak is the best position the item reached on each source.
+	Peak map[string]int `json:"peak"`
+	// OnPage is how long the item was on each source's page.
+	OnPage map[string]time.Duration `json:"onPage"`
+}
+
+// maxSightings is the number of sightings kept per item, the oldest are
+// dropped first.
+const maxSightings = 1000
+
+// recordPositions updates the histories of items returned by a poll at now.
+// A sighting is extended when the item was on the page, at the same
+// position, in the source's previous poll. b.mux must be held.
+func (b *Bot) recordPositions(items []Item, now time.Time) {
+	if b.history == nil {
+		b.history = make(map[string][]Sighting)
+		b.lastPolled = make(map[string]time.Time)
+	}
+
+	polled := make(map[string]bool)
+	for _, item := range items {
+		polled[item.SourceName] = true
+		if _, ok := b.entries[item.URL]; !ok || item.Position == 0 {
+			continue
+		}
+
+		sightings := b.history[item.URL]
+		prev := -1
+		for i := len(sightings) - 1; i >= 0; i-- {
+			if sightings[i].Source == item.SourceName {
+				prev = i
+				break
+			}
+		}
+
+		continued := prev >= 0 && sightings[prev].To.Equal(b.lastPolled[item.SourceName])
+		switch {
+		case continued && sightings[prev].Position == item.Position:
+			sightings[prev].To = now
+		case continued:
+			// it moved some time between the polls, the previous one is
+			// as close as it gets.
+			sightings = append(sightings, Sighting{item.SourceName, item.Position, sightings[prev].To, now})
+		default:
+			sightings = append(sightings, Sighting{item.SourceName, item.Position, now, now})
+		}
+
+		if len(sightings) > maxSightings {
+			sightings = sightings[len(sightings)-maxSightings:]
+		}
+		b.history[item.URL] = sightings
+	}
+
+	for source := range polled {
+		b.lastPolled[source] = now
+	}
+}
+
+// History returns where the item with url has been on its sources' pages,
+// for as long as the Bot has had the item in memory.
+func (b *Bot) History(url string) (History, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	e, ok := b.entries[url]
+	if !ok {
+		return History{}, fmt.Errorf("no item with URL %s", url)
+	}
+
+	h := History{
+		Item:      e.item,
+		Sightings: append([]Sighting(nil), b.history[url]...),
+		Peak:      make(map[string]int),
+		OnPage:    make(map[string]time.Duration),
+	}
+	for _, s := range h.Sightings {
+		if peak, ok := h.Peak[s.Source]; !ok || s.Position < peak {
+			h.Peak[s.Source] = s.Position
+		}
+		h.OnPage[s.Source] += s.To.Sub(s.From)
+	}
+	return h, nil
+}
+
+// Sources returns the names of the sources the item was seen on, sorted.
+func (h History) Sources() []string {
+	sources := make([]string, 0, len(h.Peak))
+	for source := range h.Peak {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
@@ -0,0 +1,315 @@
+package paperboy
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// indexDoc is an item in the index and the number of terms in its title.
+type indexDoc struct {
+	item   Item
+	length int
+}
+
+// Index is an inverted index of item titles, used to search a Bot's items.
+// It isn't safe for concurrent use.
+type Index struct {
+	docs     map[int]*indexDoc
+	ids      map[string]int
+	postings map[string]map[int][]int
+	nextID   int
+	totalLen int
+}
+
+// NewIndex creates an empty Index.
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[int]*indexDoc),
+		ids:      make(map[string]int),
+		postings: make(map[string]map[int][]int),
+	}
+}
+
+// Len returns the number of items in the index.
+func (idx *Index) Len() int {
+	return len(idx.docs)
+}
+
+// isWordRune reports whether r is part of a word.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits s into normalized, stemmed terms.
+func tokenize(s string) []string {
+	terms := words(normalize(s))
+	for i, term := range terms {
+		terms[i] = stem(term)
+	}
+	return terms
+}
+
+// Add indexes item, replacing any item already indexed with the same URL.
+func (idx *Index) Add(item Item) {
+	idx.Remove(item.URL)
+
+	id := idx.nextID
+	idx.nextID++
+
+	terms := tokenize(item.Title)
+	idx.docs[id] = &indexDoc{item: item, length: len(terms)}
+	idx.ids[item.URL] = id
+	idx.totalLen += len(terms)
+
+	for pos, term := range terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[int][]int)
+		}
+		idx.postings[term][id] = append(idx.postings[term][id], pos)
+	}
+}
+
+// Update replaces the indexed copy of item, found by its URL, like when its
+// score changed. Items that aren't indexed are left out.
+func (idx *Index) Update(item Item) {
+	id, ok := idx.ids[item.URL]
+	if !ok {
+		return
+	}
+	if doc := idx.docs[id]; doc.item.Title == item.Title {
+		doc.item = item
+		return
+	}
+	idx.Add(item)
+}
+
+// Remove drops the item with url from the index.
+func (idx *Index) Remove(url string) {
+	id, ok := idx.ids[url]
+	if !ok {
+		return
+	}
+
+	doc := idx.docs[id]
+	for _, term := range tokenize(doc.item.Title) {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+
+	idx.totalLen -= doc.length
+	delete(idx.docs, id)
+	delete(idx.ids, url)
+}
+
+// bm25 scores how well the document id matches term.
+func (idx *Index) bm25(term string, id int) float64 {
+	postings := idx.postings[term]
+	return idx.bm25Freq(len(postings[id]), len(postings), id)
+}
+
+// bm25Freq scores document id for a term it contains termFreq times and
+// that docFreq documents contain.
+func (idx *Index) bm25Freq(termFreq, docFreq, id int) float64 {
+	if termFreq == 0 {
+		return 0
+	}
+
+	tf := float64(termFreq)
+	n := float64(len(idx.docs))
+	df := float64(docFreq)
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+	avgLen := float64(idx.totalLen) / n
+	docLen := float64(idx.docs[id].length)
+	return idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*docLen/avgLen))
+}
+
+// Search returns the items matching query, best match first. See
+// ParseQuery for the query syntax.
+func (idx *Index) Search(query string) ([]Item, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Match(q), nil
+}
+
+// Match returns the items matching q, best match first. Items with the same
+// score are ordered newest first.
+func (idx *Index) Match(q Query) []Item {
+	scores := q.eval(idx)
+
+	type hit struct {
+		doc   *indexDoc
+		score float64
+	}
+	hits := make([]hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, hit{idx.docs[id], score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if !a.doc.item.FirstSeen.Equal(b.doc.item.FirstSeen) {
+			return a.doc.item.FirstSeen.After(b.doc.item.FirstSeen)
+		}
+		return a.doc.item.URL < b.doc.item.URL
+	})
+
+	items := make([]Item, len(hits))
+	for i, h := range hits {
+		items[i] = h.doc.item
+	}
+	return items
+}
+
+// all returns every document with a score of zero.
+func (idx *Index) all() map[int]float64 {
+	scores := make(map[int]float64, len(idx.docs))
+	for id := range idx.docs {
+		scores[id] = 0
+	}
+	return scores
+}
+
+// filter returns every document that keep returns true for.
+func (idx *Index) filter(keep func(Item) bool) map[int]float64 {
+	scores := make(map[int]float64)
+	for id, doc := range idx.docs {
+		if keep(doc.item) {
+			scores[id] = 0
+		}
+	}
+	return scores
+}
+
+// itemDomain returns the host of an item's URL without a leading "www.".
+func itemDomain(item Item) string {
+	u, err := url.Parse(item.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// stem reduces an English word to its stem using steps 1 and 5a of the
+// Porter algorithm, which handle plurals, -ed/-ing and final e. That's enough
+// to match "release" with "released" and "releases" in short titles.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+
+	// step 1a, plurals.
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"):
+	case strings.HasSuffix(word, "s"):
+		word = word[:len(word)-1]
+	}
+
+	// step 1b, -eed, -ed and -ing.
+	trimmed := false
+	switch {
+	case strings.HasSuffix(word, "eed"):
+		if measure(word[:len(word)-3]) > 0 {
+			word = word[:len(word)-1]
+		}
+	case strings.HasSuffix(word, "ed") && hasVowel(word[:len(word)-2]):
+		word, trimmed = word[:len(word)-2], true
+	case strings.HasSuffix(word, "ing") && hasVowel(word[:len(word)-3]):
+		word, trimmed = word[:len(word)-3], true
+	}
+
+	if trimmed {
+		switch {
+		case strings.HasSuffix(word, "at"), strings.HasSuffix(word, "bl"), strings.HasSuffix(word, "iz"):
+			word += "e"
+		case doubleConsonant(word) && !strings.ContainsAny(word[len(word)-1:], "lsz"):
+			word = word[:len(word)-1]
+		case measure(word) == 1 && endsCVC(word):
+			word += "e"
+		}
+	}
+
+	// step 1c, y to i.
+	if strings.HasSuffix(word, "y") && hasVowel(word[:len(word)-1]) {
+		word = word[:len(word)-1] + "i"
+	}
+
+	// step 5a, a final e, so "release" and "released" share a stem.
+	if strings.HasSuffix(word, "e") {
+		base := word[:len(word)-1]
+		if m := measure(base); m > 1 || (m == 1 && !endsCVC(base)) {
+			word = base
+		}
+	}
+	return word
+}
+
+// isConsonant reports whether word[i] is a consonant in the Porter sense,
+// where y is a consonant unless it follows one.
+func isConsonant(word string, i int) bool {
+	switch word[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(word, i-1)
+	}
+	return true
+}
+
+func hasVowel(word string) bool {
+	for i := range word {
+		if !isConsonant(word, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// measure counts the vowel-consonant sequences in word.
+func measure(word string) int {
+	m := 0
+	prevVowel := false
+	for i := range word {
+		vowel := !isConsonant(word, i)
+		if prevVowel && !vowel {
+			m++
+		}
+		prevVowel = vowel
+	}
+	return m
+}
+
+func doubleConsonant(word string) bool {
+	n := len(word)
+	return n >= 2 && word[n-1] == word[n-2] && isConsonant(word, n-1)
+}
+
+// endsCVC reports whether word ends consonant-vowel-consonant, where the last
+// consonant isn't w, x or y.
+func endsCVC(word string) bool {
+	n := len(word)
+	if n < 3 || !isConsonant(word, n-1) || isConsonant(word, n-2) || !isConsonant(word, n-3) {
+		return false
+	}
+	return !strings.ContainsAny(word[n-1:], "wxy")
+}
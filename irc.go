@@ -0,0 +1,490 @@
+package paperboy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"github.com/google/logger"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// IRCConfig says how an IRCClient connects.
+type IRCConfig struct {
+	// Addr is the server's host:port.
+	Addr string
+	// TLS connects with TLS, using TLSConfig if it's set.
+	TLS       bool
+	TLSConfig *tls.Config
+	Nick      string
+	// User and RealName are Nick when they're not set.
+	User     string
+	RealName string
+	// Password is sent with PASS, for servers that need one.
+	Password string
+	// SASLUser logs in with SASL PLAIN and SASLPassword when it's set.
+	SASLUser     string
+	SASLPassword string
+	// Channels are joined after connecting, and again after a kick.
+	Channels []string
+	// FloodBurst messages are sent at once, after that one every
+	// FloodDelay, so the server doesn't disconnect the client for
+	// flooding.
+	FloodBurst int
+	FloodDelay time.Duration
+	// MaxBackoff is the longest the client waits to reconnect. It waits a
+	// second after the first failure, and twice as long after each one
+	// after it.
+	MaxBackoff time.Duration
+}
+
+// Defaults for the IRCConfig settings that aren't set.
+const (
+	DefaultIRCFloodBurst = 5
+	DefaultIRCFloodDelay = 2 * time.Second
+	DefaultIRCMaxBackoff = 5 * time.Minute
+)
+
+// ircPingInterval is how long the client waits for the server to say
+// something before pinging it, and then for a reply before reconnecting.
+const ircPingInterval = 2 * time.Minute
+
+// maxIRCText is the number of bytes of text sent in one message at most,
+// leaving room in the server's 512 byte lines for the prefix it adds.
+const maxIRCText = 400
+
+// IRCMessage is a line sent to or from an IRC server.
+type IRCMessage struct {
+	// Prefix is where the message is from, like nick!user@host.
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// ParseIRCMessage parses a line received from an IRC server, without its
+// line ending. Message tags are dropped.
+func ParseIRCMessage(line string) IRCMessage {
+	var m IRCMessage
+	if strings.HasPrefix(line, "@") {
+		if i := strings.Index(line, " "); i >= 0 {
+			line = strings.TrimLeft(line[i+1:], " ")
+		} else {
+			line = ""
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			i = len(line)
+		}
+		m.Prefix = line[1:i]
+		line = strings.TrimLeft(line[i:], " ")
+	}
+
+	for line != "" {
+		if strings.HasPrefix(line, ":") && m.Command != "" {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		i := strings.Index(line, " ")
+		if i < 0 {
+			i = len(line)
+		}
+		if m.Command == "" {
+			m.Command = strings.ToUpper(line[:i])
+		} else {
+			m.Params = append(m.Params, line[:i])
+		}
+		line = strings.TrimLeft(line[i:], " ")
+	}
+	return m
+}
+
+// Nick returns the nick the message is from, empty if it's from the server.
+func (m IRCMessage) Nick() string {
+	if i := strings.Index(m.Prefix, "!"); i >= 0 {
+		return m.Prefix[:i]
+	}
+	if strings.Contains(m.Prefix, ".") {
+		return ""
+	}
+	return m.Prefix
+}
+
+// Param returns the i-th parameter, empty if there isn't one.
+func (m IRCMessage) Param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// String formats the message as a line to send, without the line ending.
+func (m IRCMessage) String() string {
+	parts := make([]string, 0, len(m.Params)+2)
+	if m.Prefix != "" {
+		parts = append(parts, ":"+m.Prefix)
+	}
+	parts = append(parts, m.Command)
+	for i, p := range m.Params {
+		if i == len(m.Params)-1 && (p == "" || strings.Contains(p, " ") || strings.HasPrefix(p, ":")) {
+			p = ":" + p
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, " ")
+}
+
+// IRCClient is a connection to an IRC server that keeps itself connected,
+// see Run.
+type IRCClient struct {
+	config IRCConfig
+	// pingInterval is ircPingInterval, tests shorten it.
+	pingInterval time.Duration
+	mux          sync.Mutex
+	// session is the current connection, nil while disconnected.
+	session *ircSession
+}
+
+// ircSession is one connection to the server.
+type ircSession struct {
+	conn net.Conn
+	// nick is the client's nick on this connection, it's different from
+	// the configured one when that was taken.
+	nick string
+	// registered is set once the server welcomes the client. It's changed
+	// with the client's mux held, other goroutines read it with it held.
+	registered bool
+	// out are lines waiting for the flood limit.
+	out      chan string
+	done     chan struct{}
+	writeMux sync.Mutex
+}
+
+// ircFatalError is an error reconnecting won't fix, like a wrong password.
+type ircFatalError struct {
+	msg string
+}
+
+func (e ircFatalError) Error() string {
+	return e.msg
+}
+
+// NewIRCClient returns a client that connects as config says, once it's
+// run.
+func NewIRCClient(config IRCConfig) *IRCClient {
+	if config.User == "" {
+		config.User = config.Nick
+	}
+	if config.RealName == "" {
+		config.RealName = config.Nick
+	}
+	if config.FloodBurst <= 0 {
+		config.FloodBurst = DefaultIRCFloodBurst
+	}
+	if config.FloodDelay <= 0 {
+		config.FloodDelay = DefaultIRCFloodDelay
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultIRCMaxBackoff
+	}
+	return &IRCClient{config: config, pingInterval: ircPingInterval}
+}
+
+// Run connects to the server and keeps the client connected until ctx is
+// done, reconnecting with exponential backoff when the connection fails.
+// Messages received once the client is registered are passed to handle,
+// which is called on Run's goroutine. Run returns ctx's error, or an error
+// reconnecting won't fix, like a failed SASL login.
+func (c *IRCClient) Run(ctx context.Context, handle func(IRCMessage)) error {
+	backoff := time.Second
+	for {
+		registered, err := c.connect(ctx, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if fatal, ok := err.(ircFatalError); ok {
+			return fatal
+		}
+		if registered {
+			backoff = time.Second
+		}
+		logger.Errorf("Error on IRC connection to %s, reconnecting in %s: %s\n", c.config.Addr, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+// Nick returns the client's current nick, empty while it's not registered.
+func (c *IRCClient) Nick() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.session == nil || !c.session.registered {
+		return ""
+	}
+	return c.session.nick
+}
+
+// Say sends text to a channel or nick, a message per line, splitting lines
+// too long for IRC. Messages are written at the pace the flood limit allows,
+// Say blocks until they're all queued, which takes a while once the queue is
+// full.
+func (c *IRCClient) Say(target, text string) error {
+	// the target is a parameter of its own, it can't have spaces or start
+	// another line.
+	if target == "" || strings.HasPrefix(target, ":") || strings.ContainsAny(target, " \r\n\x00") {
+		return fmt.Errorf("invalid IRC target %q", target)
+	}
+
+	c.mux.Lock()
+	s := c.session
+	registered := s != nil && s.registered
+	c.mux.Unlock()
+	if !registered {
+		return fmt.Errorf("not connected to %s", c.config.Addr)
+	}
+
+	for _, line := range strings.Split(strings.Replace(text, "\r", "", -1), "\n") {
+		for _, part := range splitIRCText(line, maxIRCText) {
+			msg := IRCMessage{Command: "PRIVMSG", Params: []string{target, part}}
+			select {
+			case s.out <- msg.String():
+			case <-s.done:
+				return fmt.Errorf("disconnected from %s", c.config.Addr)
+			}
+		}
+	}
+	return nil
+}
+
+// splitIRCText splits text into parts of at most max bytes, at spaces when
+// it can and never inside a character. Empty lines are dropped.
+func splitIRCText(text string, max int) []string {
+	parts := make([]string, 0, 1)
+	for text != "" {
+		if len(text) <= max {
+			parts = append(parts, text)
+			break
+		}
+		cut := max
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if i := strings.LastIndex(text[:cut], " "); i > max/2 {
+			cut = i
+		}
+		parts = append(parts, text[:cut])
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+	return parts
+}
+
+// dial connects to the server.
+func (c *IRCClient) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", c.config.Addr)
+	if err != nil || !c.config.TLS {
+		return conn, err
+	}
+
+	config := c.config.TLSConfig
+	if config == nil {
+		host, _, _ := net.SplitHostPort(c.config.Addr)
+		config = &tls.Config{ServerName: host}
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connect runs one connection until it fails or ctx is done, reporting
+// whether the client got registered.
+func (c *IRCClient) connect(ctx context.Context, handle func(IRCMessage)) (bool, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	s := &ircSession{
+		conn: conn,
+		nick: c.config.Nick,
+		out:  make(chan string, 100),
+		done: make(chan struct{}),
+	}
+	c.mux.Lock()
+	c.session = s
+	c.mux.Unlock()
+
+	defer func() {
+		c.mux.Lock()
+		c.session = nil
+		c.mux.Unlock()
+		close(s.done)
+		conn.Close()
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.send(IRCMessage{Command: "QUIT", Params: []string{"Shutting down"}})
+			conn.Close()
+		case <-s.done:
+		}
+	}()
+	go c.flood(s)
+
+	if c.config.SASLUser != "" {
+		s.send(IRCMessage{Command: "CAP", Params: []string{"REQ", "sasl"}})
+	}
+	if c.config.Password != "" {
+		s.send(IRCMessage{Command: "PASS", Params: []string{c.config.Password}})
+	}
+	s.send(IRCMessage{Command: "NICK", Params: []string{s.nick}})
+	s.send(IRCMessage{Command: "USER", Params: []string{c.config.User, "0", "*", c.config.RealName}})
+
+	r := bufio.NewReader(conn)
+	pinged := false
+	// partial is the start of a line the read deadline cut off.
+	var partial string
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.pingInterval))
+		line, err := r.ReadString('\n')
+		line, partial = partial+line, ""
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && !pinged {
+				pinged = true
+				partial = line
+				s.send(IRCMessage{Command: "PING", Params: []string{"paperboy"}})
+				continue
+			}
+			return s.registered, err
+		}
+		pinged = false
+
+		m := ParseIRCMessage(strings.TrimRight(line, "\r\n"))
+		if err := c.handle(s, m); err != nil {
+			return s.registered, err
+		}
+		if s.registered {
+			handle(m)
+		}
+	}
+}
+
+// handle takes care of the messages that keep the connection going.
+func (c *IRCClient) handle(s *ircSession, m IRCMessage) error {
+	switch m.Command {
+	case "PING":
+		s.send(IRCMessage{Command: "PONG", Params: m.Params})
+	case "ERROR":
+		return fmt.Errorf("server closed the connection: %s", m.Param(0))
+
+	case "CAP":
+		switch m.Param(1) {
+		case "ACK":
+			s.send(IRCMessage{Command: "AUTHENTICATE", Params: []string{"PLAIN"}})
+		case "NAK":
+			return ircFatalError{fmt.Sprintf("%s doesn't support SASL", c.config.Addr)}
+		}
+	case "AUTHENTICATE":
+		if m.Param(0) == "+" {
+			creds := "\x00" + c.config.SASLUser + "\x00" + c.config.SASLPassword
+			s.send(IRCMessage{Command: "AUTHENTICATE", Params: []string{base64.StdEncoding.EncodeToString([]byte(creds))}})
+		}
+	case "903": // RPL_SASLSUCCESS
+		s.send(IRCMessage{Command: "CAP", Params: []string{"END"}})
+	case "902", "904", "905", "906": // the SASL errors
+		return ircFatalError{fmt.Sprintf("SASL login as %s failed: %s", c.config.SASLUser, m.Param(len(m.Params)-1))}
+	case "464": // ERR_PASSWDMISMATCH
+		return ircFatalError{fmt.Sprintf("%s rejected the password", c.config.Addr)}
+
+	case "001": // RPL_WELCOME
+		c.mux.Lock()
+		s.registered = true
+		s.nick = m.Param(0)
+		c.mux.Unlock()
+		if len(c.config.Channels) > 0 {
+			s.send(IRCMessage{Command: "JOIN", Params: []string{strings.Join(c.config.Channels, ",")}})
+		}
+	case "432", "433", "436", "437": // the nick is invalid or taken
+		if !s.registered {
+			// try another nick until one's free.
+			c.mux.Lock()
+			s.nick += "_"
+			nick := s.nick
+			c.mux.Unlock()
+			s.send(IRCMessage{Command: "NICK", Params: []string{nick}})
+		}
+	case "NICK":
+		c.mux.Lock()
+		if strings.EqualFold(m.Nick(), s.nick) {
+			s.nick = m.Param(0)
+		}
+		c.mux.Unlock()
+	case "KICK":
+		if strings.EqualFold(m.Param(1), s.nick) {
+			s.send(IRCMessage{Command: "JOIN", Params: []string{m.Param(0)}})
+		}
+	}
+	return nil
+}
+
+// send writes m to the connection right away.
+func (s *ircSession) send(m IRCMessage) error {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := fmt.Fprintf(s.conn, "%s\r\n", m)
+	return err
+}
+
+// flood writes the session's queued lines, FloodBurst at once and then one
+// every FloodDelay. Each line moves a clock FloodDelay ahead, lines wait
+// while the clock is more than a burst ahead of the time.
+func (c *IRCClient) flood(s *ircSession) {
+	burst := time.Duration(c.config.FloodBurst-1) * c.config.FloodDelay
+	clock := time.Now()
+	for {
+		var line string
+		select {
+		case line = <-s.out:
+		case <-s.done:
+			return
+		}
+
+		now := time.Now()
+		if clock.Before(now) {
+			clock = now
+		}
+		if wait := clock.Sub(now) - burst; wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-s.done:
+				return
+			}
+		}
+		clock = clock.Add(c.config.FloodDelay)
+
+		s.writeMux.Lock()
+		s.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		_, err := fmt.Fprintf(s.conn, "%s\r\n", line)
+		s.writeMux.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package paperboy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ircLine is a line an ircServer received.
+type ircLine struct {
+	text string
+	at   time.Time
+}
+
+// ircServer is an IRC server that registers clients, with SASL PLAIN when
+// they ask for it. Nicks in taken are refused. It records every line it
+// receives.
+type ircServer struct {
+	ln    net.Listener
+	taken map[string]bool
+	// sasl is the user and password SASL logins need.
+	sasl string
+	// pong answers the clients' PINGs.
+	pong bool
+
+	mux      sync.Mutex
+	lines    []ircLine
+	conns    []net.Conn
+	accepted int
+	wg       sync.WaitGroup
+}
+
+// newIRCServer starts an ircServer, with TLS if config is set.
+func newIRCServer(t *testing.T, config *tls.Config) *ircServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	s := &ircServer{ln: ln, taken: make(map[string]bool), pong: true}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.drop()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *ircServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mux.Lock()
+		s.conns = append(s.conns, conn)
+		s.accepted++
+		s.mux.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *ircServer) handle(conn net.Conn) {
+	reply := func(format string, args ...interface{}) {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	r := bufio.NewReader(conn)
+	var nick string
+	negotiating := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.mux.Lock()
+		s.lines = append(s.lines, ircLine{line, time.Now()})
+		pong := s.pong
+		s.mux.Unlock()
+
+		m := ParseIRCMessage(line)
+		switch m.Command {
+		case "CAP":
+			switch m.Param(0) {
+			case "REQ":
+				negotiating = true
+				reply(":irc.test CAP * ACK :sasl")
+			case "END":
+				negotiating = false
+				reply(":irc.test 001 %s :Welcome", nick)
+			}
+		case "AUTHENTICATE":
+			if m.Param(0) == "PLAIN" {
+				reply("AUTHENTICATE +")
+				continue
+			}
+			creds, _ := base64.StdEncoding.DecodeString(m.Param(0))
+			if string(creds) == s.sasl {
+				reply(":irc.test 903 %s :SASL authentication successful", nick)
+			} else {
+				reply(":irc.test 904 %s :SASL authentication failed", nick)
+			}
+		case "NICK":
+			s.mux.Lock()
+			taken := s.taken[m.Param(0)]
+			s.mux.Unlock()
+			if taken {
+				reply(":irc.test 433 * %s :Nickname is already in use", m.Param(0))
+				continue
+			}
+			nick = m.Param(0)
+			if !negotiating {
+				reply(":irc.test 001 %s :Welcome", nick)
+			}
+		case "PING":
+			if pong {
+				reply(":irc.test PONG irc.test :%s", m.Param(0))
+			}
+		case "QUIT":
+			return
+		}
+	}
+}
+
+// received returns the lines received that start with prefix.
+func (s *ircServer) received(prefix string) []ircLine {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var lines []ircLine
+	for _, l := range s.lines {
+		if strings.HasPrefix(l.text, prefix) {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// send writes text to every client connected, as it is.
+func (s *ircServer) send(text string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, conn := range s.conns {
+		conn.Write([]byte(text))
+	}
+}
+
+// drop closes the connections to every client.
+func (s *ircServer) drop() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// connections returns the number of connections the server accepted.
+func (s *ircServer) connections() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.accepted
+}
+
+// runIRC runs c until the test ends, passing the messages it handles to the
+// channel returned. stop stops c and returns what Run returned.
+func runIRC(t *testing.T, c *IRCClient) (messages chan IRCMessage, stop func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	messages = make(chan IRCMessage, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx, func(m IRCMessage) {
+			select {
+			case messages <- m:
+			default:
+			}
+		})
+	}()
+	var once sync.Once
+	var err error
+	stop = func() error {
+		once.Do(func() {
+			cancel()
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Error("Run didn't return")
+			}
+		})
+		return err
+	}
+	t.Cleanup(func() { stop() })
+	return messages, stop
+}
+
+// nextPrivmsg returns the next PRIVMSG in messages.
+func nextPrivmsg(t *testing.T, messages chan IRCMessage) IRCMessage {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-messages:
+			if m.Command == "PRIVMSG" {
+				return m
+			}
+		case <-timeout:
+			t.Fatal("no PRIVMSG was handled")
+		}
+	}
+}
+
+func TestIRCClient(t *testing.T) {
+	s := newIRCServer(t, nil)
+	s.taken["pb"] = true
+	c := NewIRCClient(IRCConfig{Addr: s.ln.Addr().String(), Nick: "pb", Channels: []string{"#a", "#b"}, FloodDelay: time.Millisecond})
+	if err := c.Say("#a", "hi"); err == nil {
+		t.Fatal("said something before connecting")
+	}
+	messages, stop := runIRC(t, c)
+
+	// the nick is taken, an _ is added.
+	eventually(t, 5*time.Second, "registering", func() bool { return c.Nick() == "pb_" })
+	eventually(t, 5*time.Second, "joining the channels", func() bool { return len(s.received("JOIN #a,#b")) == 1 })
+	if n := len(s.received("USER pb 0 * pb")); n != 1 {
+		t.Errorf("sent USER %d times, want 1", n)
+	}
+
+	s.send("PING :abc\r\n")
+	eventually(t, 5*time.Second, "answering a PING", func() bool { return len(s.received("PONG abc")) == 1 })
+
+	s.send(":alice!a@example.com PRIVMSG #a :pb_: status\r\n")
+	if m := nextPrivmsg(t, messages); m.Nick() != "alice" || m.Param(1) != "pb_: status" {
+		t.Fatalf("handled %+v", m)
+	}
+
+	// lines are messages of their own, long ones are split.
+	long := strings.TrimSpace(strings.Repeat("word ", 200))
+	if err := c.Say("#a", "one\r\ntwo\n\n"+long); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 5*time.Second, "saying 5 messages", func() bool { return len(s.received("PRIVMSG #a ")) == 5 })
+	sent := s.received("PRIVMSG #a ")
+	for i, want := range []string{"PRIVMSG #a one", "PRIVMSG #a two"} {
+		if sent[i].text != want {
+			t.Errorf("message %d is %q, want %q", i, sent[i].text, want)
+		}
+	}
+	var parts []string
+	for _, l := range sent[2:] {
+		text := ParseIRCMessage(l.text).Param(1)
+		if len(text) > maxIRCText {
+			t.Errorf("sent %d bytes of text, want %d at most", len(text), maxIRCText)
+		}
+		parts = append(parts, text)
+	}
+	if rest := strings.Join(parts, " "); rest != long {
+		t.Errorf("the long line was split into %q", rest)
+	}
+
+	// a target can't smuggle in another command.
+	for _, target := range []string{"", "#a b", "#a\r\nQUIT", "#a\nJOIN #c", ":x"} {
+		if err := c.Say(target, "hi"); err == nil {
+			t.Errorf("said something to %q", target)
+		}
+	}
+	if n := len(s.received("QUIT")) + len(s.received("JOIN #c")); n != 0 {
+		t.Fatalf("an invalid target sent %d commands", n)
+	}
+
+	// when the server drops the connection, the client comes back.
+	s.drop()
+	eventually(t, 5*time.Second, "reconnecting", func() bool { return s.connections() == 2 })
+	eventually(t, 5*time.Second, "registering again", func() bool { return c.Nick() == "pb_" })
+	eventually(t, 5*time.Second, "joining again", func() bool { return len(s.received("JOIN #a,#b")) == 2 })
+
+	if err := stop(); err != context.Canceled {
+		t.Fatalf("Run returned %v, want context.Canceled", err)
+	}
+	eventually(t, 5*time.Second, "quitting", func() bool { return len(s.received("QUIT")) == 1 })
+}
+
+func TestIRCClientSASL(t *testing.T) {
+	// httptest has a certificate for 127.0.0.1 that's handy here.
+	hs := httptest.NewTLSServer(http.NotFoundHandler())
+	hs.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(hs.Certificate())
+	config := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+
+	s := newIRCServer(t, hs.TLS)
+	s.sasl = "\x00account\x00s3cret"
+	c := NewIRCClient(IRCConfig{Addr: s.ln.Addr().String(), TLS: true, TLSConfig: config, Nick: "pb", SASLUser: "account", SASLPassword: "s3cret"})
+	_, stop := runIRC(t, c)
+	eventually(t, 5*time.Second, "logging in", func() bool { return c.Nick() == "pb" })
+	if n := len(s.received("CAP END")); n != 1 {
+		t.Fatalf("ended capability negotiation %d times, want 1", n)
+	}
+	stop()
+
+	// a wrong password isn't worth reconnecting for.
+	c = NewIRCClient(IRCConfig{Addr: s.ln.Addr().String(), TLS: true, TLSConfig: config, Nick: "pb", SASLUser: "account", SASLPassword: "wrong"})
+	done := make(chan error, 1)
+	go func() { done <- c.Run(context.Background(), func(IRCMessage) {}) }()
+	select {
+	case err := <-done:
+		if _, ok := err.(ircFatalError); !ok {
+			t.Fatalf("Run returned %v, want the failed login", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept going after the login failed")
+	}
+}
+
+func TestIRCClientPing(t *testing.T) {
+	s := newIRCServer(t, nil)
+	c := NewIRCClient(IRCConfig{Addr: s.ln.Addr().String(), Nick: "pb"})
+	c.pingInterval = 200 * time.Millisecond
+	messages, _ := runIRC(t, c)
+	eventually(t, 5*time.Second, "registering", func() bool { return c.Nick() == "pb" })
+
+	// a quiet server is pinged, and the client stays when it answers.
+	eventually(t, 5*time.Second, "pinging the server", func() bool { return len(s.received("PING")) >= 2 })
+	if n := s.connections(); n != 1 {
+		t.Fatalf("%d connections to a server that answers pings, want 1", n)
+	}
+
+	// a line cut off by the ping isn't lost. The server stops answering
+	// right after a ping, so the next one comes in the middle of the line.
+	pings := len(s.received("PING"))
+	eventually(t, 5*time.Second, "pinging the server", func() bool { return len(s.received("PING")) > pings })
+	s.mux.Lock()
+	s.pong = false
+	s.mux.Unlock()
+	pings = len(s.received("PING"))
+	s.send(":alice!a@example.com PRIVMSG pb :sta")
+	eventually(t, 5*time.Second, "pinging in the middle of a line", func() bool { return len(s.received("PING")) > pings })
+	s.send("tus\r\n")
+	if m := nextPrivmsg(t, messages); m.Param(1) != "status" {
+		t.Fatalf("handled %q, want status", m.Param(1))
+	}
+
+	// a server that doesn't answer is left.
+	eventually(t, 5*time.Second, "reconnecting to a server that doesn't answer", func() bool { return s.connections() == 2 })
+}
+
+func TestIRCClientFlood(t *testing.T) {
+	s := newIRCServer(t, nil)
+	delay := 100 * time.Millisecond
+	c := NewIRCClient(IRCConfig{Addr: s.ln.Addr().String(), Nick: "pb", FloodBurst: 3, FloodDelay: delay})
+	runIRC(t, c)
+	eventually(t, 5*time.Second, "registering", func() bool { return c.Nick() == "pb" })
+
+	// the burst goes out at once, the rest a delay apart.
+	start := time.Now()
+	if err := c.Say("#a", "1\n2\n3\n4\n5\n6"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 5*time.Second, "saying 6 messages", func() bool { return len(s.received("PRIVMSG")) == 6 })
+	sent := s.received("PRIVMSG")
+	if d := sent[2].at.Sub(start); d > delay/2 {
+		t.Errorf("the burst of 3 took %s", d)
+	}
+	for i := 3; i < len(sent); i++ {
+		// lines are timed when they arrive, a little after they're sent.
+		if d := sent[i].at.Sub(sent[i-1].at); d < delay-10*time.Millisecond {
+			t.Errorf("message %d came %s after the one before, want %s", i+1, d, delay)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package paperboy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mark is what a consumer has attached to an item. The item is copied, so
+// tagged items and the read later list outlive the item's eviction.
+type mark struct {
+	item    Item
+	starred time.Time
+	later   time.Time
+	// tags maps each tag to when it was attached.
+	tags map[string]time.Time
+}
+
+// empty reports whether nothing is attached to the item anymore.
+func (m *mark) empty() bool {
+	return m.starred.IsZero() && m.later.IsZero() && len(m.tags) == 0
+}
+
+// marksFor returns the marks of consumer, by URL, creating them when create
+// is set. b.mux must be held.
+func (b *Bot) marksFor(consumer string, create bool) map[string]*mark {
+	marks, ok := b.marks[consumer]
+	if !ok && create {
+		if b.marks == nil {
+			b.marks = make(map[string]map[string]*mark)
+		}
+		marks = make(map[string]*mark)
+		b.marks[consumer] = marks
+	}
+	return marks
+}
+
+// markItem returns consumer's mark on the item with url, creating it. The
+// item has to be one of the Bot's items, unless it's already marked. b.mux
+// must be held.
+func (b *Bot) markItem(consumer, url string) (*mark, error) {
+	marks := b.marksFor(consumer, true)
+	if m, ok := marks[url]; ok {
+		return m, nil
+	}
+
+	e, ok := b.entries[url]
+	if !ok {
+		return nil, fmt.Errorf("no item with URL %s", url)
+	}
+	m := &mark{item: e.item, tags: make(map[string]time.Time)}
+	marks[url] = m
+	return m, nil
+}
+
+// unmark drops consumer's mark on url once nothing is attached to it. b.mux
+// must be held.
+func (b *Bot) unmark(consumer, url string) {
+	marks := b.marksFor(consumer, false)
+	if m, ok := marks[url]; ok && m.empty() {
+		delete(marks, url)
+	}
+	if len(marks) == 0 {
+		delete(b.marks, consumer)
+	}
+}
+
+// isStarred reports whether any consumer starred the item with url. b.mux
+// must be held.
+func (b *Bot) isStarred(url string) bool {
+	for _, marks := range b.marks {
+		if m, ok := marks[url]; ok && !m.starred.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// markedItems returns consumer's marked items for which at returns a time,
+// most recent first. b.mux must be held.
+func (b *Bot) markedItems(consumer string, at func(*mark) time.Time) []Item {
+	list := make([]*mark, 0)
+	for _, m := range b.marksFor(consumer, false) {
+		if !at(m).IsZero() {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ti, tj := at(list[i]), at(list[j])
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return list[i].item.URL < list[j].item.URL
+	})
+
+	items := make([]Item, len(list))
+	for i, m := range list {
+		items[i] = m.item
+	}
+	return items
+}
+
+// normalizeTag lower cases a tag and strips a leading #, so "#Go" and "go"
+// are the same tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// Star stars the item with url for consumer. Starred items are never evicted
+// or flushed.
+func (b *Bot) Star(consumer, url string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	m, err := b.markItem(consumer, url)
+	if err != nil {
+		return err
+	}
+	if m.starred.IsZero() {
+		m.starred = time.Now()
+	}
+	return nil
+}
+
+// Unstar removes consumer's star from the item with url, reporting whether
+// it was starred.
+func (b *Bot) Unstar(consumer, url string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	m, ok := b.marksFor(consumer, false)[url]
+	if !ok || m.starred.IsZero() {
+		return false
+	}
+	m.starred = time.Time{}
+	b.unmark(consumer, url)
+	return true
+}
+
+// Starred returns the items consumer starred, most recently starred first.
+func (b *Bot) Starred(consumer string) []Item {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.markedItems(consumer, func(m *mark) time.Time { return m.starred })
+}
+
+// Tag attaches tags to the item with url for consumer.
+func (b *Bot) Tag(consumer, url string, tags ...string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	m, err := b.markItem(consumer, url)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, tag := range tags {
+		if tag = normalizeTag(tag); tag != "" {
+			if _, ok := m.tags[tag]; !ok {
+				m.tags[tag] = now
+			}
+		}
+	}
+	b.unmark(consumer, url)
+	return nil
+}
+
+// Untag removes tags from consumer's item with url. It returns the number of
+// tags removed.
+func (b *Bot) Untag(consumer, url string, tags ...string) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	m, ok := b.marksFor(consumer, false)[url]
+	if !ok {
+		return 0
+	}
+	removed := 0
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if _, ok := m.tags[tag]; ok {
+			delete(m.tags, tag)
+			removed++
+		}
+	}
+	b.unmark(consumer, url)
+	return removed
+}
+
+// ItemTags returns the tags consumer attached to the item with url, sorted.
+func (b *Bot) ItemTags(consumer, url string) []string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	tags := make([]string, 0)
+	if m, ok := b.marksFor(consumer, false)[url]; ok {
+		for tag := range m.tags {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Tags returns consumer's tags and the number of items with each.
+func (b *Bot) Tags(consumer string) map[string]int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	counts := make(map[string]int)
+	for _, m := range b.marksFor(consumer, false) {
+		for tag := range m.tags {
+			counts[tag]++
+		}
+	}
+	return counts
+}
+
+// Tagged returns consumer's items with tag, most recently tagged first.
+func (b *Bot) Tagged(consumer, tag string) []Item {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	tag = normalizeTag(tag)
+	return b.markedItems(consumer, func(m *mark) time.Time { return m.tags[tag] })
+}
+
+// AddReadLater adds the item with url to consumer's read later list.
+func (b *Bot) AddReadLater(consumer, url string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	m, err := b.markItem(consumer, url)
+	if err != nil {
+		return err
+	}
+	if m.later.IsZero() {
+		m.later = time.Now()
+	}
+	return nil
+}
+
+// RemoveReadLater takes the item with url off consumer's read later list,
+// reporting whether it was on it.
+func (b *Bot) RemoveReadLater(consumer, url string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	m, ok := b.marksFor(consumer, false)[url]
+	if !ok || m.later.IsZero() {
+		return false
+	}
+	m.later = time.Time{}
+	b.unmark(consumer, url)
+	return true
+}
+
+// ReadLater returns consumer's read later list, most recently added first.
+func (b *Bot) ReadLater(consumer string) []Item {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.markedItems(consumer, func(m *mark) time.Time { return m.later })
+}
@@ -0,0 +1,51 @@
+package paperboy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTaggedOrder(t *testing.T) {
+	b := NewBot(nil)
+	items := addItems(b, "a", 2)
+	tag := func(url string, tags ...string) {
+		if err := b.Tag("alice", url, tags...); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// adding rust to the first item doesn't make it the latest go item.
+	tag(items[0].URL, "go")
+	tag(items[1].URL, "go", "rust")
+	tag(items[0].URL, "rust")
+	tag(items[1].URL, "go")
+
+	check := func(b *Bot, tag string, want ...Item) {
+		t.Helper()
+		got := b.Tagged("alice", tag)
+		if len(got) != len(want) {
+			t.Fatalf("%s items are %v, want %v", tag, got, want)
+		}
+		for i := range want {
+			if got[i].URL != want[i].URL {
+				t.Fatalf("%s items are %v, want %v", tag, got, want)
+			}
+		}
+	}
+	check(b, "go", items[1], items[0])
+	check(b, "rust", items[0], items[1])
+
+	// the order survives a restart.
+	var buf bytes.Buffer
+	if err := b.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewBot(nil)
+	if err := restored.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	check(restored, "go", items[1], items[0])
+	check(restored, "rust", items[0], items[1])
+}
@@ -0,0 +1,116 @@
+package paperboy
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// apostrophes are the characters titles use for an apostrophe.
+var apostrophes = strings.NewReplacer("’", "'", "‘", "'", "ʼ", "'", "′", "'", "`", "'")
+
+// folder applies Unicode case folding, which handles more than
+// strings.ToLower, like "ß" matching "ss".
+var folder = cases.Fold()
+
+// normalize prepares text for indexing and searching. It applies NFKC
+// normalization, so "ﬁ" matches "fi" and full-width letters match their
+// ASCII forms, strips diacritics, so "café" matches "cafe", folds case and
+// makes all apostrophes ASCII.
+func normalize(s string) string {
+	// decompose so diacritics become separate marks that can be removed,
+	// then recompose what's left.
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFKC)
+	stripped, _, err := transform.String(t, s)
+	if err != nil {
+		stripped = norm.NFKC.String(s)
+	}
+	return apostrophes.Replace(folder.String(stripped))
+}
+
+// words splits normalized text into words. Possessive 's is dropped and
+// other apostrophes are removed, so "Kubernetes’s" is "kubernetes" and
+// "don't" is "dont".
+func words(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return !isWordRune(r) && r != '\''
+	})
+
+	words := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.TrimSuffix(field, "'s")
+		field = strings.Replace(field, "'", "", -1)
+		if field != "" {
+			words = append(words, field)
+		}
+	}
+	return words
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b, counting insertions, deletions, substitutions and transpositions of
+// adjacent runes as one edit each. It gives up and returns max+1 once the
+// distance is known to be more than max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+
+	// rows for i-2, i-1 and i.
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d := minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d = minInt(d, prev2[j-2]+1)
+			}
+			cur[j] = d
+			if d < rowMin {
+				rowMin = d
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func minInt(n int, ns ...int) int {
+	for _, m := range ns {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
+
+// maxEdits is how many typos a fuzzy search allows in a word, short words
+// need to match exactly or they'd match almost anything.
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 3:
+		return 0
+	case n <= 7:
+		return 1
+	}
+	return 2
+}
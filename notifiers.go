@@ -0,0 +1,173 @@
+package paperboy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifierClient is the HTTP client notifiers use when they aren't given
+// one.
+var notifierClient = &http.Client{Timeout: 10 * time.Second}
+
+// WriterNotifier returns a notifier that writes notifications to w in
+// format, one at a time.
+func WriterNotifier(w io.Writer, format TextFormat) Notifier {
+	var mux sync.Mutex
+	return NotifierFunc(func(n Notification) error {
+		mux.Lock()
+		defer mux.Unlock()
+		_, err := io.WriteString(w, n.Render(format)+"\n")
+		return err
+	})
+}
+
+// FileNotifier returns a notifier that appends notifications to the file at
+// path in format, creating it if needed.
+func FileNotifier(path string, format TextFormat) Notifier {
+	return appendNotifier(path, func(w io.Writer, n Notification) error {
+		_, err := io.WriteString(w, n.Render(format)+"\n")
+		return err
+	})
+}
+
+// jsonlRecord is a line of a JSONLNotifier's file.
+type jsonlRecord struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	To      string    `json:"to,omitempty"`
+	Item    Item      `json:"item"`
+}
+
+// JSONLNotifier returns a notifier that appends a json object to the file
+// at path for each item it's notified of, one per line.
+func JSONLNotifier(path string) Notifier {
+	return appendNotifier(path, func(w io.Writer, n Notification) error {
+		enc := json.NewEncoder(w)
+		now := time.Now()
+		for _, item := range n.Items {
+			if err := enc.Encode(jsonlRecord{now, n.Subject, n.To, item}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// appendNotifier returns a notifier that appends to the file at path with
+// write. The notification is written to a buffer first, so a failed
+// notification doesn't leave half of it in the file.
+func appendNotifier(path string, write func(io.Writer, Notification) error) Notifier {
+	var mux sync.Mutex
+	return NotifierFunc(func(n Notification) error {
+		var buf bytes.Buffer
+		if err := write(&buf, n); err != nil {
+			return err
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// SlackWebhook posts notifications to a Slack incoming webhook, formatted
+// for Slack.
+type SlackWebhook struct {
+	URL string
+	// Client is the HTTP client requests are sent with, one with a 10
+	// second timeout when it's nil.
+	Client *http.Client
+}
+
+// Notify posts n, to the channel in n.To if it's set and the webhook
+// allows it.
+func (s *SlackWebhook) Notify(n Notification) error {
+	body, err := json.Marshal(struct {
+		Text    string `json:"text"`
+		Channel string `json:"channel,omitempty"`
+	}{n.Render(SlackText), n.To})
+	if err != nil {
+		return err
+	}
+	return postJSON(s.Client, s.URL, nil, body)
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// settingFormat returns the format in nc's format setting, PlainText when
+// it's not set.
+func settingFormat(nc NotifierConfig) (TextFormat, error) {
+	if nc.Settings["format"] == "" {
+		return PlainText, nil
+	}
+	return ParseTextFormat(nc.Settings["format"])
+}
+
+// requireSettings checks that nc has the named settings.
+func requireSettings(nc NotifierConfig, names ...string) error {
+	for _, name := range names {
+		if nc.Settings[name] == "" {
+			return fmt.Errorf("%s notifiers need the %s setting", nc.Type, name)
+		}
+	}
+	return nil
+}
+
+// newStdoutNotifier builds "stdout" notifiers, which print in their format
+// setting.
+func newStdoutNotifier(nc NotifierConfig) (Notifier, error) {
+	format, err := settingFormat(nc)
+	if err != nil {
+		return nil, err
+	}
+	return WriterNotifier(os.Stdout, format), nil
+}
+
+// newFileNotifier builds "file" notifiers, which append to their path in
+// their format setting, jsonl being one more format.
+func newFileNotifier(nc NotifierConfig) (Notifier, error) {
+	if err := requireSettings(nc, "path"); err != nil {
+		return nil, err
+	}
+	if nc.Settings["format"] == "jsonl" {
+		return JSONLNotifier(nc.Settings["path"]), nil
+	}
+	format, err := settingFormat(nc)
+	if err != nil {
+		return nil, err
+	}
+	return FileNotifier(nc.Settings["path"], format), nil
+}
+
+// newSlackNotifier builds "slack" notifiers, which post to the incoming
+// webhook in their webhook setting.
+func newSlackNotifier(nc NotifierConfig) (Notifier, error) {
+	if err := requireSettings(nc, "webhook"); err != nil {
+		return nil, err
+	}
+	return &SlackWebhook{URL: nc.Settings["webhook"]}, nil
+}
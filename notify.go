@@ -0,0 +1,170 @@
+package paperboy
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Notification is a message about one or more items, like an alert from a
+// watchlist.
+type Notification struct {
+	// Subject is a one line summary.
+	Subject string
+	// To is where the notifier should deliver the notification, like a
+	// Slack channel. Notifiers with a single destination ignore it.
+	To    string
+	Items []Item
+	// Digest is set for digests, notifiers can render it in their own
+	// format.
+	Digest *Digest
+}
+
+// Text renders the notification as plain text, the subject followed by one
+// line per item, or the digest if it's one.
+func (n Notification) Text() string {
+	if n.Digest != nil {
+		return n.Digest.Render(PlainText)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, n.Subject)
+	for _, item := range n.Items {
+		fmt.Fprintf(&buf, "[%s] %s - %s\n", item.SourceName, item.Title, item.URL)
+	}
+	return buf.String()
+}
+
+// Render renders the notification in format, digests keep their groups.
+func (n Notification) Render(format TextFormat) string {
+	if n.Digest != nil {
+		return n.Digest.Render(format)
+	}
+	if format == PlainText {
+		return n.Text()
+	}
+
+	items := make([]DigestItem, len(n.Items))
+	for i, item := range n.Items {
+		items[i] = DigestItem{Item: item}
+	}
+	d := Digest{Title: n.Subject, Groups: []DigestGroup{{Items: items}}}
+	return d.Render(format)
+}
+
+// filter returns a copy of n with only the items keep returns true for,
+// digests keep their groups that still have items.
+func (n Notification) filter(keep func(Item) bool) Notification {
+	items := make([]Item, 0, len(n.Items))
+	for _, item := range n.Items {
+		if keep(item) {
+			items = append(items, item)
+		}
+	}
+	n.Items = items
+	if n.Digest == nil {
+		return n
+	}
+
+	d := *n.Digest
+	d.Groups = make([]DigestGroup, 0, len(n.Digest.Groups))
+	for _, g := range n.Digest.Groups {
+		kept := make([]DigestItem, 0, len(g.Items))
+		for _, di := range g.Items {
+			if keep(di.Item) {
+				kept = append(kept, di)
+			}
+		}
+		if len(kept) > 0 {
+			d.Groups = append(d.Groups, DigestGroup{Name: g.Name, Items: kept})
+		}
+	}
+	n.Digest = &d
+	return n
+}
+
+// Notifier delivers notifications, to a chat channel, a file, or anywhere
+// else.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// PartialError is returned by notifiers that deliver a notification's items
+// one at a time when only some of them were delivered. Retrying with just
+// the Undelivered items doesn't send the others twice.
+type PartialError struct {
+	Undelivered []Item
+	Err         error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d items not delivered: %s", len(e.Undelivered), e.Err)
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(n Notification) error
+
+// Notify calls f(n).
+func (f NotifierFunc) Notify(n Notification) error {
+	return f(n)
+}
+
+// notifierStarter is a notifier with background work, like a Webhook's
+// queue. Building a notifier from its config doesn't start anything, so a
+// config that's rejected leaves nothing running, the Bot starts it once
+// it's registered.
+type notifierStarter interface {
+	start()
+}
+
+// startNotifier starts n if it's a notifierStarter.
+func startNotifier(n Notifier) {
+	if s, ok := n.(notifierStarter); ok {
+		s.start()
+	}
+}
+
+// AddNotifier registers a notifier under name, replacing any notifier
+// already registered under it. Watchlists refer to notifiers by name.
+func (b *Bot) AddNotifier(name string, n Notifier) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.notifiers == nil {
+		b.notifiers = make(map[string]Notifier)
+	}
+	startNotifier(n)
+	b.notifiers[name] = n
+}
+
+// RemoveNotifier unregisters the named notifier.
+func (b *Bot) RemoveNotifier(name string) {
+	b.mux.Lock()
+	delete(b.notifiers, name)
+	b.mux.Unlock()
+}
+
+// Notifiers returns the names of the registered notifiers.
+func (b *Bot) Notifiers() []string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	names := make([]string, 0, len(b.notifiers))
+	for name := range b.notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Notify delivers n with the named notifier.
+func (b *Bot) Notify(name string, n Notification) error {
+	b.mux.Lock()
+	notifier, ok := b.notifiers[name]
+	b.mux.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no notifier named %s", name)
+	}
+	return notifier.Notify(n)
+}
@@ -0,0 +1,125 @@
+package paperboy
+
+import (
+	"encoding/xml"
+	"fmt"
+	"golang.org/x/net/html/charset"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type opmlDoc struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+// opmlOutline is a feed, a web page or a folder of outlines. Selector and
+// Converter aren't standard OPML, they let html sources survive an export
+// and import.
+type opmlOutline struct {
+	Text      string        `xml:"text,attr"`
+	Title     string        `xml:"title,attr,omitempty"`
+	Type      string        `xml:"type,attr,omitempty"`
+	XMLURL    string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL   string        `xml:"htmlUrl,attr,omitempty"`
+	Selector  string        `xml:"selector,attr,omitempty"`
+	Converter string        `xml:"converter,attr,omitempty"`
+	Outlines  []opmlOutline `xml:"outline"`
+}
+
+// source returns the source the outline describes, if it's a feed or an
+// html source exported by paperboy.
+func (o opmlOutline) source() (Source, bool) {
+	name := o.Title
+	if name == "" {
+		name = o.Text
+	}
+
+	switch {
+	case o.XMLURL != "":
+		if name == "" {
+			name = o.XMLURL
+			if u, err := url.Parse(o.XMLURL); err == nil && u.Host != "" {
+				name = u.Host
+			}
+		}
+		return Source{Name: name, URL: o.XMLURL, Type: FeedSource}, true
+	case o.HTMLURL != "" && o.Selector != "":
+		return Source{Name: name, URL: o.HTMLURL, Selector: o.Selector, Converter: o.Converter}, true
+	}
+	return Source{}, false
+}
+
+// ReadOPML returns the sources in an OPML document, from every folder. Feeds
+// become feed sources. Plain web pages are skipped, there's no telling what
+// to scrape from them. Names are made unique by numbering repeats.
+func ReadOPML(r io.Reader) ([]Source, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = charset.NewReaderLabel
+
+	doc := opmlDoc{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	sources := make([]Source, 0)
+	names := make(map[string]int)
+	var walk func(outlines []opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			if source, ok := o.source(); ok {
+				source.Name = strings.TrimSpace(source.Name)
+				names[source.Name]++
+				if n := names[source.Name]; n > 1 {
+					source.Name = fmt.Sprintf("%s (%d)", source.Name, n)
+				}
+				sources = append(sources, source)
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(doc.Body.Outlines)
+
+	if err := validateSources(sources); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// WriteOPML writes sources to w as an OPML 2.0 document.
+func WriteOPML(w io.Writer, sources []Source) error {
+	doc := opmlDoc{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       "paperboy sources",
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, s := range sources {
+		o := opmlOutline{Text: s.Name, Title: s.Name}
+		if s.Type == FeedSource {
+			o.Type = "rss"
+			o.XMLURL = s.URL
+		} else {
+			o.Type = "link"
+			o.HTMLURL = s.URL
+			o.Selector = s.Selector
+			o.Converter = s.Converter
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, o)
+	}
+	return writeXML(w, doc)
+}
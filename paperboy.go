@@ -3,13 +3,16 @@ package paperboy
 // The paperboy package is can be used to get news Items from various news Sources.
 
 import (
+	"context"
 	"fmt"
 	"github.com/andybalholm/cascadia"
 	"github.com/google/logger"
 	"golang.org/x/net/html"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Item represents a news article.
@@ -17,14 +20,33 @@ type Item struct {
 	Title      string
 	URL        string
 	SourceName string
+	// FirstSeen is when the Bot first stored the item.
+	FirstSeen time.Time
+	// Score is the item's points or votes, for sources that report them.
+	Score int
+	// Comments is the number of comments on the item, for sources that
+	// report them.
+	Comments int
+	// Position is where the item was on its source's page when it was
+	// fetched, starting at 1.
+	Position int
 }
 
 // Source is a web site that paperboy will get news Items from.
 type Source struct {
-	Name        string
-	URL         string
-	Selector    string
-	ConvertFunc func(matches []*html.Node) []Item
+	Name        string                            `json:"name" yaml:"name"`
+	URL         string                            `json:"url" yaml:"url"`
+	Selector    string                            `json:"selector,omitempty" yaml:"selector"`
+	ConvertFunc func(matches []*html.Node) []Item `json:"-" yaml:"-"`
+	// Type is HTMLSource, the default, or FeedSource. Feed sources don't
+	// need a Selector or converter.
+	Type string `json:"type,omitempty" yaml:"type"`
+	// Converter names a registered converter, see RegisterConverter. It's
+	// used when ConvertFunc isn't set, like for sources read from a file,
+	// and defaults to "anchor".
+	Converter string `json:"converter,omitempty" yaml:"converter"`
+	// Paused sources aren't polled.
+	Paused bool `json:"paused,omitempty" yaml:"paused"`
 }
 
 // attributeMap will build a map from the attributes defined in an
@@ -37,6 +59,84 @@ func attributeMap(node *html.Node) (attrs map[string]string) {
 	return
 }
 
+// hasClass reports whether node has class in its class attribute.
+func hasClass(node *html.Node, class string) bool {
+	for _, c := range strings.Fields(attributeMap(node)["class"]) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// findAncestor returns the closest element above node that match accepts,
+// nil if there isn't one.
+func findAncestor(node *html.Node, match func(*html.Node) bool) *html.Node {
+	for n := node.Parent; n != nil; n = n.Parent {
+		if n.Type == html.ElementNode && match(n) {
+			return n
+		}
+	}
+	return nil
+}
+
+// findElement returns the first element under node, in document order, that
+// match accepts, nil if there isn't one.
+func findElement(node *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			return c
+		}
+		if found := findElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// nextElement returns the element after node at the same level, nil if
+// there isn't one.
+func nextElement(node *html.Node) *html.Node {
+	for n := node.NextSibling; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode {
+			return n
+		}
+	}
+	return nil
+}
+
+// nodeText returns the text under node, with runs of spaces, non-breaking
+// ones included, collapsed.
+func nodeText(node *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(node)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// parseCount returns the number text starts with, like 12 in "12 points",
+// or 0 when it doesn't start with one.
+func parseCount(text string) int {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.Replace(fields[0], ",", "", -1))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
 // AnchorConverter will convert a anchor tag to an item, using the href
 // attribute as the URL and the first child element's data as the Title.
 func AnchorConverter(matches []*html.Node) []Item {
@@ -51,18 +151,30 @@ func AnchorConverter(matches []*html.Node) []Item {
 	return items
 }
 
+// fetchTimeout limits how long a request to a source can take, so stopping
+// a Bot never waits on a source that doesn't answer.
+const fetchTimeout = 30 * time.Second
+
 // GetItems will make the http request and run a CSS selector on the
-// response's body, if the response code is 200.
+// response's body, or read it as a feed for feed sources, if the response
+// code is 200.
 func GetItems(source Source) ([]Item, error) {
+	return GetItemsContext(context.Background(), source)
+}
+
+// GetItemsContext gets items like GetItems, giving up on the request when
+// ctx is done.
+func GetItemsContext(ctx context.Context, source Source) ([]Item, error) {
 
 	req, err := http.NewRequest("GET", source.URL, nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	req = req.WithContext(ctx)
 	// some sources will block based on User-Agent.
 	req.Header.Add("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/60.0.3112.101 Safari/537.36")
 
-	client := http.Client{}
+	client := http.Client{Timeout: fetchTimeout}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -73,31 +185,54 @@ func GetItems(source Source) ([]Item, error) {
 		return nil, fmt.Errorf("unexpected response code (%d) from: %s", resp.StatusCode, source.URL)
 	}
 
+	if source.Type == FeedSource {
+		return parseFeed(resp.Body, req.URL)
+	}
+
 	docNode, err := html.Parse(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	cssSelector := cascadia.MustCompile(source.Selector)
-	items := source.ConvertFunc(cssSelector.MatchAll(docNode))
+	cssSelector, err := cascadia.Compile(source.Selector)
+	if err != nil {
+		return nil, err
+	}
+	convert, err := source.converter()
+	if err != nil {
+		return nil, err
+	}
+	items := convert(cssSelector.MatchAll(docNode))
 
 	return items, nil
 }
 
 // GetAll concurrently requests items from multiple sources.
 func GetAll(sources []Source) chan Item {
+	return GetAllContext(context.Background(), sources)
+}
+
+// GetAllContext requests items like GetAll. When ctx is done the requests
+// are abandoned and the channel is closed, whether or not the items were
+// received. Items from the same source are sent in page order.
+func GetAllContext(ctx context.Context, sources []Source) chan Item {
 	var wg sync.WaitGroup
 	out := make(chan Item)
 	sourceSink := func(source Source) {
-		items, err := GetItems(source)
+		defer wg.Done()
+		items, err := GetItemsContext(ctx, source)
 		if err != nil {
-			logger.Errorf("Error getting items from %s: %s\n", source, err)
+			logger.Errorf("Error getting items from %s: %s\n", source.Name, err)
 		}
-		for _, item := range items {
+		for i, item := range items {
 			item.SourceName = source.Name
-			out <- item
+			item.Position = i + 1
+			select {
+			case out <- item:
+			case <-ctx.Done():
+				return
+			}
 		}
-		wg.Done()
 	}
 
 	wg.Add(len(sources))
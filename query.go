@@ -0,0 +1,440 @@
+package paperboy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed search query that can be matched against an Index.
+type Query interface {
+	// eval returns the ids of the matching documents and their scores.
+	eval(idx *Index) map[int]float64
+}
+
+// SearchOptions changes how a query is matched.
+type SearchOptions struct {
+	// Fuzzy lets every word in the query match words with typos, as if
+	// each word was followed by ~.
+	Fuzzy bool
+}
+
+// termQuery matches titles containing a single term, or when fuzzy is set
+// terms within a few edits of it.
+type termQuery struct {
+	term  string
+	fuzzy bool
+}
+
+func (q termQuery) eval(idx *Index) map[int]float64 {
+	scores := make(map[int]float64)
+	max := maxEdits(q.term)
+	if !q.fuzzy || max == 0 {
+		for id := range idx.postings[q.term] {
+			scores[id] = idx.bm25(q.term, id)
+		}
+		return scores
+	}
+
+	// every spelling is scored as if it was the same term, a match with
+	// typos scoring less than an exact one, so results are ordered by how
+	// closely they match rather than by how rare a misspelling is.
+	edits := make(map[int]int)
+	freqs := make(map[int]int)
+	for term, postings := range idx.postings {
+		d := editDistance(q.term, term, max)
+		if d > max {
+			continue
+		}
+		for id, positions := range postings {
+			if e, ok := edits[id]; !ok || d < e {
+				edits[id] = d
+				freqs[id] = len(positions)
+			}
+		}
+	}
+
+	for id, d := range edits {
+		scores[id] = idx.bm25Freq(freqs[id], len(edits), id) / float64(1+d)
+	}
+	return scores
+}
+
+// phraseQuery matches titles containing terms next to each other, in order.
+type phraseQuery struct {
+	terms []string
+}
+
+func (q phraseQuery) eval(idx *Index) map[int]float64 {
+	scores := make(map[int]float64)
+	if len(q.terms) == 0 {
+		return scores
+	}
+
+	for id, starts := range idx.postings[q.terms[0]] {
+		for _, start := range starts {
+			if idx.phraseAt(q.terms[1:], id, start+1) {
+				score := 0.0
+				for _, term := range q.terms {
+					score += idx.bm25(term, id)
+				}
+				scores[id] = score
+				break
+			}
+		}
+	}
+	return scores
+}
+
+// phraseAt reports whether terms appear in document id starting at pos.
+func (idx *Index) phraseAt(terms []string, id, pos int) bool {
+	for i, term := range terms {
+		found := false
+		for _, p := range idx.postings[term][id] {
+			if p == pos+i {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// andQuery matches documents matched by all of its clauses.
+type andQuery struct {
+	clauses []Query
+}
+
+func (q andQuery) eval(idx *Index) map[int]float64 {
+	var scores map[int]float64
+	for i, clause := range q.clauses {
+		matches := clause.eval(idx)
+		if i == 0 {
+			scores = matches
+			continue
+		}
+		for id, score := range scores {
+			if s, ok := matches[id]; ok {
+				scores[id] = score + s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores
+}
+
+// orQuery matches documents matched by any of its clauses.
+type orQuery struct {
+	clauses []Query
+}
+
+func (q orQuery) eval(idx *Index) map[int]float64 {
+	scores := make(map[int]float64)
+	for _, clause := range q.clauses {
+		for id, score := range clause.eval(idx) {
+			scores[id] += score
+		}
+	}
+	return scores
+}
+
+// notQuery matches documents that aren't matched by its clause.
+type notQuery struct {
+	clause Query
+}
+
+func (q notQuery) eval(idx *Index) map[int]float64 {
+	scores := idx.all()
+	for id := range q.clause.eval(idx) {
+		delete(scores, id)
+	}
+	return scores
+}
+
+// matchItem reports whether item matches q, evaluating q against an index
+// of just that item.
+func matchItem(q Query, item Item) bool {
+	idx := NewIndex()
+	idx.Add(item)
+	return len(q.eval(idx)) > 0
+}
+
+// filterQuery matches items by a field rather than by title.
+type filterQuery struct {
+	keep func(Item) bool
+}
+
+func (q filterQuery) eval(idx *Index) map[int]float64 {
+	return idx.filter(q.keep)
+}
+
+// queryToken is a word, a quoted phrase or an operator in a query string.
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+// lexQuery splits a query string into tokens. Parentheses are tokens of
+// their own and double quotes group a phrase.
+func lexQuery(s string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, queryToken{text: string(r)})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated phrase: %s", string(runes[i:]))
+			}
+			tokens = append(tokens, queryToken{text: string(runes[i+1 : end]), quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '(' && runes[end] != ')' {
+				// allow field:"quoted value".
+				if runes[end] == '"' {
+					end++
+					for end < len(runes) && runes[end] != '"' {
+						end++
+					}
+				}
+				if end < len(runes) {
+					end++
+				}
+			}
+			tokens = append(tokens, queryToken{text: string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser over query tokens.
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	opts   SearchOptions
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// isOperator reports whether the next token is the unquoted operator op.
+func (p *queryParser) isOperator(op string) bool {
+	tok, ok := p.peek()
+	return ok && !tok.quoted && tok.text == op
+}
+
+// ParseQuery parses a search query. Words match titles containing the word,
+// or a word with the same stem, and "quoted phrases" match words in order.
+// Terms are combined with AND, which is implied, OR and NOT, which can also
+// be written as a leading -, and grouped with parentheses.
+//
+// A word followed by ~, like kuberentes~, also matches words with one or two
+// typos, ranked below exact matches.
+//
+// Field filters restrict the items searched:
+//
+//	source:Reddit     items from the Reddit source
+//	domain:github.com items linking to github.com or its subdomains
+//	before:2017-09-01 items first seen before a date
+//	after:2017-09-01  items first seen on or after a date
+func ParseQuery(s string) (Query, error) {
+	return SearchOptions{}.Parse(s)
+}
+
+// Parse parses a search query like ParseQuery, applying the options.
+func (opts SearchOptions) Parse(s string) (Query, error) {
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	p := &queryParser{tokens: tokens, opts: opts}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+	return q, nil
+}
+
+func (p *queryParser) parseOr() (Query, error) {
+	clause, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := []Query{clause}
+	for p.isOperator("OR") {
+		p.pos++
+		clause, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return orQuery{clauses}, nil
+}
+
+func (p *queryParser) parseAnd() (Query, error) {
+	clauses := make([]Query, 0)
+	for {
+		if p.isOperator("AND") {
+			p.pos++
+		}
+
+		tok, ok := p.peek()
+		if !ok || (!tok.quoted && (tok.text == ")" || tok.text == "OR")) {
+			break
+		}
+
+		clause, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil, fmt.Errorf("expected a search term")
+	case 1:
+		return clauses[0], nil
+	}
+	return andQuery{clauses}, nil
+}
+
+func (p *queryParser) parseUnary() (Query, error) {
+	tok, _ := p.peek()
+	if !tok.quoted && (tok.text == "NOT" || tok.text == "-") {
+		p.pos++
+		clause, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{clause}, nil
+	}
+
+	if !tok.quoted && len(tok.text) > 1 && strings.HasPrefix(tok.text, "-") {
+		p.tokens[p.pos].text = tok.text[1:]
+		clause, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{clause}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (Query, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("expected a search term")
+	}
+	p.pos++
+
+	if tok.quoted {
+		return phraseQuery{tokenize(tok.text)}, nil
+	}
+
+	if tok.text == "(" {
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOperator(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return q, nil
+	}
+
+	if field := strings.SplitN(tok.text, ":", 2); len(field) == 2 && field[1] != "" {
+		if q, ok, err := parseField(strings.ToLower(field[0]), strings.Trim(field[1], `"`)); ok {
+			return q, err
+		}
+	}
+
+	fuzzy := p.opts.Fuzzy || strings.HasSuffix(tok.text, "~")
+	terms := tokenize(strings.TrimSuffix(tok.text, "~"))
+	switch len(terms) {
+	case 0:
+		return nil, fmt.Errorf("no words in %q", tok.text)
+	case 1:
+		return termQuery{terms[0], fuzzy}, nil
+	}
+	// words joined by punctuation, like "x/net", are searched as a phrase.
+	return phraseQuery{terms}, nil
+}
+
+// parseDate accepts dates with or without a time.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339, "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+}
+
+// parseField returns a filter for a field:value token, ok is false when name
+// isn't a known field.
+func parseField(name, value string) (q Query, ok bool, err error) {
+	switch name {
+	case "source":
+		return filterQuery{func(item Item) bool {
+			return strings.EqualFold(item.SourceName, value)
+		}}, true, nil
+
+	case "domain":
+		domain := strings.TrimPrefix(strings.ToLower(value), "www.")
+		return filterQuery{func(item Item) bool {
+			d := itemDomain(item)
+			return d == domain || strings.HasSuffix(d, "."+domain)
+		}}, true, nil
+
+	case "before", "after":
+		t, err := parseDate(value)
+		if err != nil {
+			return nil, true, err
+		}
+		if name == "before" {
+			return filterQuery{func(item Item) bool {
+				return item.FirstSeen.Before(t)
+			}}, true, nil
+		}
+		return filterQuery{func(item Item) bool {
+			return !item.FirstSeen.Before(t)
+		}}, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,99 @@
+package paperboy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RankPolicy decides the order unread items are presented in. An item's rank
+// grows with its score and comments, is multiplied by its source's weight
+// and halves every HalfLife since it was first seen.
+type RankPolicy struct {
+	// HalfLife is how long it takes for an item's rank to halve, zero
+	// turns off the time decay.
+	HalfLife time.Duration `yaml:"half_life"`
+	// ScoreWeight and CommentWeight scale the log of an item's score and
+	// comment count.
+	ScoreWeight   float64 `yaml:"score_weight"`
+	CommentWeight float64 `yaml:"comment_weight"`
+	// SourceWeight multiplies the rank of items from a source, sources
+	// that aren't listed have a weight of 1.
+	SourceWeight map[string]float64 `yaml:"source_weight"`
+}
+
+// DefaultRanking ranks newer items first, unless an older one is much more
+// popular.
+var DefaultRanking = RankPolicy{
+	HalfLife:      6 * time.Hour,
+	ScoreWeight:   1,
+	CommentWeight: 0.5,
+}
+
+// Rank scores item at now, higher ranks are presented first.
+func (p RankPolicy) Rank(item Item, now time.Time) float64 {
+	popularity := 1 +
+		p.ScoreWeight*math.Log1p(math.Max(0, float64(item.Score))) +
+		p.CommentWeight*math.Log1p(math.Max(0, float64(item.Comments)))
+
+	weight := 1.0
+	if w, ok := p.SourceWeight[item.SourceName]; ok {
+		weight = w
+	}
+
+	decay := 1.0
+	if p.HalfLife > 0 {
+		age := now.Sub(item.FirstSeen)
+		if age < 0 {
+			age = 0
+		}
+		decay = math.Exp2(-float64(age) / float64(p.HalfLife))
+	}
+	return weight * popularity * decay
+}
+
+// Sort orders items by rank at now, highest first. Items with the same rank
+// are ordered newest first, then by URL, so the order is always the same.
+func (p RankPolicy) Sort(items []Item, now time.Time) {
+	ranks := make(map[string]float64, len(items))
+	for _, item := range items {
+		ranks[item.URL] = p.Rank(item, now)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if ra, rb := ranks[a.URL], ranks[b.URL]; ra != rb {
+			return ra > rb
+		}
+		if !a.FirstSeen.Equal(b.FirstSeen) {
+			return a.FirstSeen.After(b.FirstSeen)
+		}
+		return a.URL < b.URL
+	})
+}
+
+// ParseSourceWeights parses a comma separated list of source=weight pairs,
+// like "HackerNews=2,Reddit=0.5".
+func ParseSourceWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("source weight %q isn't source=weight", pair)
+		}
+		w, err := strconv.ParseFloat(pair[i+1:], 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("source weight %q: weight must be a number of at least 0", pair)
+		}
+		weights[pair[:i]] = w
+	}
+	return weights, nil
+}
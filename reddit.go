@@ -5,15 +5,34 @@ import (
 	"strings"
 )
 
-// RedditConverter converts matched nodes to an absolute URL.
+// RedditConverter converts matched nodes to an absolute URL, with the score
+// and comment count of the post they're in.
 func RedditConverter(matches []*html.Node) []Item {
 	cleanedItems := make([]Item, 0)
 	items := AnchorConverter(matches)
-	for _, item := range items {
+	for i, item := range items {
 		if !strings.HasPrefix(item.URL, "http") {
 			item.URL = "https://reddit.com" + item.URL
 		}
+		item.Score, item.Comments = redditCounts(matches[i])
 		cleanedItems = append(cleanedItems, item)
 	}
 	return cleanedItems
 }
+
+// redditCounts returns the score and comment count of the post node is in,
+// from the data attributes of old Reddit's div.thing or the attributes of
+// a shreddit-post.
+func redditCounts(node *html.Node) (score, comments int) {
+	post := findAncestor(node, func(n *html.Node) bool {
+		return hasClass(n, "thing") || n.Data == "shreddit-post"
+	})
+	if post == nil {
+		return 0, 0
+	}
+	attrs := attributeMap(post)
+	if post.Data == "shreddit-post" {
+		return parseCount(attrs["score"]), parseCount(attrs["comment-count"])
+	}
+	return parseCount(attrs["data-score"]), parseCount(attrs["data-comments-count"])
+}
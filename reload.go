@@ -0,0 +1,471 @@
+package paperboy
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/logger"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultConfigCheck is how often WatchConfig checks the config file for
+// changes when it isn't told.
+const DefaultConfigCheck = 5 * time.Second
+
+// ConfigChanges is what changed when a Bot was reconfigured. Sources, rules,
+// notifiers, digests and sinks are listed by name.
+type ConfigChanges struct {
+	SourcesAdded     []string
+	SourcesRemoved   []string
+	SourcesChanged   []string
+	RulesAdded       []string
+	RulesRemoved     []string
+	RulesChanged     []string
+	NotifiersAdded   []string
+	NotifiersRemoved []string
+	NotifiersChanged []string
+	DigestsAdded     []string
+	DigestsRemoved   []string
+	DigestsChanged   []string
+	SinksAdded       []string
+	SinksRemoved     []string
+	SinksChanged     []string
+	// Settings are the config keys of the settings that changed, like
+	// "poll" or "ranking".
+	Settings []string
+	// Restart are the config keys that changed but only take effect when
+	// the Bot is restarted, like "storage.archive".
+	Restart []string
+}
+
+// Empty reports whether nothing changed.
+func (c ConfigChanges) Empty() bool {
+	return len(c.lines()) == 0
+}
+
+func (c ConfigChanges) String() string {
+	lines := c.lines()
+	if len(lines) == 0 {
+		return "no changes"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lines describes each kind of change that happened on its own line.
+func (c ConfigChanges) lines() []string {
+	lists := []struct {
+		what  string
+		names []string
+	}{
+		{"sources added", c.SourcesAdded},
+		{"sources removed", c.SourcesRemoved},
+		{"sources changed", c.SourcesChanged},
+		{"rules added", c.RulesAdded},
+		{"rules removed", c.RulesRemoved},
+		{"rules changed", c.RulesChanged},
+		{"notifiers added", c.NotifiersAdded},
+		{"notifiers removed", c.NotifiersRemoved},
+		{"notifiers changed", c.NotifiersChanged},
+		{"digests added", c.DigestsAdded},
+		{"digests removed", c.DigestsRemoved},
+		{"digests changed", c.DigestsChanged},
+		{"sinks added", c.SinksAdded},
+		{"sinks removed", c.SinksRemoved},
+		{"sinks changed", c.SinksChanged},
+		{"settings changed", c.Settings},
+		{"needs a restart", c.Restart},
+	}
+
+	lines := make([]string, 0)
+	for _, l := range lists {
+		if len(l.names) > 0 {
+			lines = append(lines, l.what+": "+strings.Join(l.names, ", "))
+		}
+	}
+	return lines
+}
+
+// diffNames compares two sets of things described by name, returning the
+// names that are only in next, only in prev, and in both but described
+// differently.
+func diffNames(prev, next map[string]string) (added, removed, changed []string) {
+	for name, desc := range next {
+		old, ok := prev[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case old != desc:
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := next[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// describeSources describes each source by name, for diffNames.
+func describeSources(sources []Source) map[string]string {
+	descs := make(map[string]string, len(sources))
+	for _, s := range sources {
+		descs[s.Name] = fmt.Sprintf("%s %s %s %s %t", s.URL, s.Type, s.Selector, s.Converter, s.Paused)
+	}
+	return descs
+}
+
+// describeRules describes each rule by name, for diffNames.
+func describeRules(rules []Rule) map[string]string {
+	descs := make(map[string]string, len(rules))
+	for _, r := range rules {
+		descs[r.Name] = r.String()
+	}
+	return descs
+}
+
+// describeNotifiers describes each notifier config by name, for diffNames.
+func describeNotifiers(notifiers []NotifierConfig) map[string]string {
+	descs := make(map[string]string, len(notifiers))
+	for _, nc := range notifiers {
+		// fmt prints maps sorted by key.
+		descs[nc.Name] = fmt.Sprint(nc.Type, nc.Settings)
+	}
+	return descs
+}
+
+// describeSinks describes each sink by name, for diffNames.
+func describeSinks(sinks []Sink) map[string]string {
+	descs := make(map[string]string, len(sinks))
+	for _, s := range sinks {
+		descs[s.Name] = fmt.Sprint(s.String(), s.Retries, s.RetryDelay)
+	}
+	return descs
+}
+
+// describeDigests describes each scheduled digest by name, for diffNames.
+func describeDigests(digests []ScheduledDigest) map[string]string {
+	descs := make(map[string]string, len(digests))
+	for _, d := range digests {
+		descs[d.Name] = fmt.Sprint(d.String(), d.DigestOptions)
+	}
+	return descs
+}
+
+// readRulesFile reads the json rules file at path.
+func readRulesFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules, err := ReadRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %s", path, err)
+	}
+	return rules, nil
+}
+
+// ApplyConfig reconfigures a running Bot with c, usually a reloaded version
+// of the config it was made with, and reports what changed.
+//
+// Sources added to, removed from or changed in c since the last config are
+// added to, removed from or replaced in the Bot's sources, sources added at
+// runtime are left alone. The rules are replaced with the ones in
+// c.Storage.Rules, if it's set. Notifiers are rebuilt when their config
+// changed, the old ones are closed if they're io.Closers, and the settings
+// are applied from the next poll on. Scheduled digests are replaced when
+// any of them changed, and sinks are restarted when theirs did.
+//
+// Everything is checked and built before any of it is applied, so the Bot
+// is left alone, and nothing new is left running, if c is invalid or any of
+// its rules or notifiers can't be loaded. Configs are applied one at a
+// time, a call waits for the one before it to finish.
+func (b *Bot) ApplyConfig(c *Config) (ConfigChanges, error) {
+	b.applyMux.Lock()
+	defer b.applyMux.Unlock()
+
+	var changes ConfigChanges
+	if err := c.Validate(); err != nil {
+		return changes, err
+	}
+
+	var rules []Rule
+	if c.Storage.Rules != "" {
+		var err error
+		if rules, err = readRulesFile(c.Storage.Rules); err != nil {
+			return changes, err
+		}
+	}
+
+	digests, err := compileDigests(c.Digests)
+	if err != nil {
+		return changes, err
+	}
+	sinks, err := compileSinks(c.Sinks)
+	if err != nil {
+		return changes, err
+	}
+
+	b.mux.Lock()
+	prev := b.config
+	b.mux.Unlock()
+	if prev == nil {
+		prev = &Config{}
+	}
+	changes.DigestsAdded, changes.DigestsRemoved, changes.DigestsChanged =
+		diffNames(describeDigests(prev.Digests), describeDigests(c.Digests))
+	changes.SinksAdded, changes.SinksRemoved, changes.SinksChanged =
+		diffNames(describeSinks(prev.Sinks), describeSinks(c.Sinks))
+	changes.SourcesAdded, changes.SourcesRemoved, changes.SourcesChanged =
+		diffNames(describeSources(prev.Sources), describeSources(c.Sources))
+
+	// building notifiers has no side effects, see notifierStarter, they're
+	// only started once the rest of the config is accepted.
+	changes.NotifiersAdded, changes.NotifiersRemoved, changes.NotifiersChanged =
+		diffNames(describeNotifiers(prev.Notifiers), describeNotifiers(c.Notifiers))
+	built := make(map[string]Notifier)
+	for _, nc := range c.Notifiers {
+		if !containsString(changes.NotifiersAdded, nc.Name) && !containsString(changes.NotifiersChanged, nc.Name) {
+			continue
+		}
+		build, ok := notifierType(nc.Type)
+		if !ok {
+			return ConfigChanges{}, fmt.Errorf("notifier %s: unknown type %q", nc.Name, nc.Type)
+		}
+		n, err := build(nc)
+		if err != nil {
+			return ConfigChanges{}, fmt.Errorf("notifier %s: %s", nc.Name, err)
+		}
+		built[nc.Name] = n
+	}
+
+	b.mux.Lock()
+	sources, recorded := mergeSources(b.sources, c.Sources, changes)
+	// rules and sinks are checked against the sources they'll be used
+	// with, including the ones added at runtime.
+	if err := checkRuleScores(sources, rules); err != nil {
+		b.mux.Unlock()
+		return ConfigChanges{}, err
+	}
+	for _, s := range sinks {
+		if s.MinScore == 0 {
+			continue
+		}
+		if err := checkScoreSources(sources, s.Sources); err != nil {
+			b.mux.Unlock()
+			return ConfigChanges{}, fmt.Errorf("sink %s: %s", s.Name, err)
+		}
+	}
+
+	// nothing can fail from here on.
+	b.sources = sources
+
+	if c.Storage.Rules != "" {
+		changes.RulesAdded, changes.RulesRemoved, changes.RulesChanged =
+			diffNames(describeRules(b.rules), describeRules(rules))
+		b.rules = rules
+	}
+
+	replaced := make([]Notifier, 0)
+	for _, name := range changes.NotifiersRemoved {
+		if n, ok := b.notifiers[name]; ok {
+			replaced = append(replaced, n)
+		}
+		delete(b.notifiers, name)
+	}
+	if len(built) > 0 && b.notifiers == nil {
+		b.notifiers = make(map[string]Notifier)
+	}
+	for name, n := range built {
+		if old, ok := b.notifiers[name]; ok {
+			replaced = append(replaced, old)
+		}
+		startNotifier(n)
+		b.notifiers[name] = n
+	}
+
+	settings := []struct {
+		key        string
+		prev, next interface{}
+		apply      func()
+	}{
+		{"poll", b.PollFrequency, c.Poll, func() { b.PollFrequency = c.Poll }},
+		{"autosave", b.Autosave, c.Autosave, func() { b.Autosave = c.Autosave }},
+		{"retention", b.Retention, c.Retention, func() { b.Retention = c.Retention }},
+		{"ranking", b.Ranking, c.Ranking, func() { b.Ranking = c.Ranking }},
+		{"trends", b.Trends, c.Trends, func() { b.Trends = c.Trends }},
+		{"storage.sources", b.SourcesPath, c.Storage.Sources, func() { b.SourcesPath = c.Storage.Sources }},
+		{"storage.rules", prev.Storage.Rules, c.Storage.Rules, func() {}},
+	}
+	for _, s := range settings {
+		if !reflect.DeepEqual(s.prev, s.next) {
+			s.apply()
+			changes.Settings = append(changes.Settings, s.key)
+		}
+	}
+
+	// the archive is open and the watches are owned by the front ends.
+	if prev.Storage.Archive != c.Storage.Archive {
+		changes.Restart = append(changes.Restart, "storage.archive")
+	}
+	if prev.Storage.Watches != c.Storage.Watches {
+		changes.Restart = append(changes.Restart, "storage.watches")
+	}
+	if !reflect.DeepEqual(prev.Credentials, c.Credentials) {
+		changes.Restart = append(changes.Restart, "credentials")
+	}
+
+	if len(changes.DigestsAdded)+len(changes.DigestsRemoved)+len(changes.DigestsChanged) > 0 {
+		b.digests = digests
+		b.digestDue = nil
+	}
+
+	b.config = c
+	b.reconfigure()
+	b.mux.Unlock()
+
+	// notifiers like queued webhooks have goroutines to stop.
+	for _, n := range replaced {
+		if closer, ok := n.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Errorf("Error closing notifier: %s\n", err)
+			}
+		}
+	}
+
+	// sinks subscribe and start their own goroutines. They were checked
+	// above, so adding them only fails if the sources changed since.
+	var sinkErr error
+	for _, name := range changes.SinksRemoved {
+		b.RemoveSink(name)
+	}
+	for _, s := range sinks {
+		if containsString(changes.SinksAdded, s.Name) || containsString(changes.SinksChanged, s.Name) {
+			if err := b.AddSink(s); err != nil && sinkErr == nil {
+				sinkErr = err
+			}
+		}
+	}
+
+	if len(changes.SourcesAdded)+len(changes.SourcesRemoved)+len(changes.SourcesChanged) > 0 {
+		if b.Archive != nil && len(recorded) > 0 {
+			if err := b.Archive.RecordSources(recorded); err != nil {
+				logger.Errorf("Error archiving sources: %s\n", err)
+			}
+		}
+		if err := b.saveSources(); err != nil {
+			return changes, err
+		}
+	}
+	return changes, sinkErr
+}
+
+// mergeSources returns current with the source changes applied, taking the
+// added and changed sources from sources, and the sources it added or
+// replaced. current isn't modified.
+func mergeSources(current, sources []Source, changes ConfigChanges) ([]Source, []Source) {
+	kept := current[:0:0]
+	for _, s := range current {
+		if !containsString(changes.SourcesRemoved, s.Name) {
+			kept = append(kept, s)
+		}
+	}
+
+	recorded := make([]Source, 0)
+	for _, source := range sources {
+		if !containsString(changes.SourcesAdded, source.Name) && !containsString(changes.SourcesChanged, source.Name) {
+			continue
+		}
+		recorded = append(recorded, source)
+
+		replaced := false
+		for i := range kept {
+			if kept[i].Name == source.Name {
+				kept[i] = source
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			kept = append(kept, source)
+		}
+	}
+	return kept, recorded
+}
+
+// Config returns the config the Bot was made or last reconfigured with, or
+// nil if it wasn't made from a config.
+func (b *Bot) Config() *Config {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.config
+}
+
+// WatchConfig reloads c's file and applies it to the Bot whenever the file
+// changes, checking every interval, or a signal is received on reload, like
+// SIGHUP. It returns when ctx is done. Each reload is passed to report, if
+// it's not nil, along with any error. When the config can't be reloaded or
+// applied the Bot keeps running with the last good one.
+func (b *Bot) WatchConfig(ctx context.Context, c *Config, interval time.Duration, reload <-chan os.Signal, report func(ConfigChanges, error)) {
+	if interval <= 0 {
+		interval = DefaultConfigCheck
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// a file is taken to have changed when its size or time does, which
+	// catches editors that replace the file as well as ones that write to
+	// it.
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(c.Path())
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	modTime, size := stat()
+
+	apply := func() {
+		next, err := c.Reload()
+		var changes ConfigChanges
+		if err == nil {
+			changes, err = b.ApplyConfig(next)
+			if err == nil {
+				c = next
+			}
+		}
+		if report != nil {
+			report(changes, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			modTime, size = stat()
+			apply()
+		case <-ticker.C:
+			t, s := stat()
+			if t.Equal(modTime) && s == size {
+				continue
+			}
+			// a file that's gone is probably being replaced, the next
+			// check will see the new one.
+			modTime, size = t, s
+			if s >= 0 {
+				apply()
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package paperboy
+
+import (
+	"sort"
+	"time"
+)
+
+// RetentionPolicy limits how many read items a Bot keeps in memory, only
+// items that every consumer has read, and nobody starred, are evicted. URLs
+// of evicted items are remembered for Remember, so they aren't announced as
+// unread again when a source still lists them. Snapshots keep them, so they
+// are remembered across restarts too.
+type RetentionPolicy struct {
+	// MaxAge evicts read items first seen longer ago, zero keeps items
+	// regardless of age.
+	MaxAge time.Duration `yaml:"max_age"`
+	// MaxItems is the number of read items kept, the least recently used
+	// are evicted first. Zero doesn't limit the number of items.
+	MaxItems int `yaml:"max_items"`
+	// SourceQuota limits the number of read items kept per source name,
+	// again evicting the least recently used first.
+	SourceQuota map[string]int `yaml:"source_quota"`
+	// Remember is how long the URLs of evicted items are remembered.
+	Remember time.Duration `yaml:"remember"`
+}
+
+// DefaultRetention keeps every read item and remembers evicted URLs for 30
+// days.
+var DefaultRetention = RetentionPolicy{
+	Remember: 30 * 24 * time.Hour,
+}
+
+// byLastUsed sorts URLs from least to most recently used.
+func (b *Bot) byLastUsed(urls []string) {
+	sort.Slice(urls, func(i, j int) bool {
+		ti, tj := b.lastUsed[urls[i]], b.lastUsed[urls[j]]
+		if ti.Equal(tj) {
+			return urls[i] < urls[j]
+		}
+		return ti.Before(tj)
+	})
+}
+
+// forget removes an item and remembers its URL as evicted. b.mux must be
+// held.
+func (b *Bot) forget(url string, now time.Time) {
+	b.removeEntry(url)
+	delete(b.lastUsed, url)
+	delete(b.alerted, url)
+	delete(b.history, url)
+	b.evictedURLs().add(url, now)
+}
+
+// evictedURLs returns the filter of evicted URLs, creating it for the
+// retention policy if needed. b.mux must be held.
+func (b *Bot) evictedURLs() *timeBloom {
+	if b.evicted == nil {
+		remember := b.Retention.Remember
+		if remember <= 0 {
+			remember = DefaultRetention.Remember
+		}
+		width := 24 * time.Hour
+		if remember < width {
+			width = remember
+		}
+		b.evicted = newTimeBloom(remember, width, 10000)
+	}
+	return b.evicted
+}
+
+// wasEvicted reports whether url belongs to an item that was evicted, or
+// flushed, recently. b.mux must be held.
+func (b *Bot) wasEvicted(url string, now time.Time) bool {
+	return b.evicted != nil && b.evicted.has(url, now)
+}
+
+// touch marks an item as used now. b.mux must be held.
+func (b *Bot) touch(url string, now time.Time) {
+	b.lastUsed[url] = now
+}
+
+// Evict applies the Bot's retention policy to its read items and returns the
+// number of items evicted.
+func (b *Bot) Evict() int {
+	b.mux.Lock()
+	evicted := b.evict(time.Now())
+	b.mux.Unlock()
+
+	b.changed(evicted)
+	return evicted
+}
+
+// readItems returns the items every consumer has read, by URL. b.mux must
+// be held.
+func (b *Bot) readItems() map[string]Item {
+	read := make(map[string]Item)
+	for url, e := range b.entries {
+		if b.readByAll(e) {
+			read[url] = e.item
+		}
+	}
+	return read
+}
+
+func (b *Bot) evict(now time.Time) int {
+	policy := b.Retention
+	evicted := 0
+	read := b.readItems()
+	for url := range read {
+		if b.isStarred(url) {
+			delete(read, url)
+		}
+	}
+
+	if policy.MaxAge > 0 {
+		cutoff := now.Add(-policy.MaxAge)
+		for url, item := range read {
+			if item.FirstSeen.Before(cutoff) {
+				b.forget(url, now)
+				delete(read, url)
+				evicted++
+			}
+		}
+	}
+
+	if len(policy.SourceQuota) > 0 {
+		bySource := make(map[string][]string)
+		for url, item := range read {
+			if _, limited := policy.SourceQuota[item.SourceName]; limited {
+				bySource[item.SourceName] = append(bySource[item.SourceName], url)
+			}
+		}
+
+		for source, urls := range bySource {
+			excess := len(urls) - policy.SourceQuota[source]
+			if excess <= 0 {
+				continue
+			}
+			b.byLastUsed(urls)
+			for _, url := range urls[:excess] {
+				b.forget(url, now)
+				delete(read, url)
+				evicted++
+			}
+		}
+	}
+
+	if policy.MaxItems > 0 && len(read) > policy.MaxItems {
+		urls := make([]string, 0, len(read))
+		for url := range read {
+			urls = append(urls, url)
+		}
+		b.byLastUsed(urls)
+		for _, url := range urls[:len(urls)-policy.MaxItems] {
+			b.forget(url, now)
+			evicted++
+		}
+	}
+
+	return evicted
+}
@@ -0,0 +1,337 @@
+package paperboy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RuleAction is what a Rule does with the items it matches.
+type RuleAction string
+
+const (
+	// Exclude drops matching items.
+	Exclude RuleAction = "exclude"
+	// Include keeps matching items. When there are include rules for
+	// an item's source, or global ones, items have to match one of them.
+	Include RuleAction = "include"
+)
+
+// Rule decides whether an item is stored by the Bot. A rule matches an item
+// when all of its conditions that are set match.
+type Rule struct {
+	Name   string     `json:"name"`
+	Action RuleAction `json:"action"`
+	// Source limits the rule to items from one source, rules without a
+	// source are global.
+	Source string `json:"source,omitempty"`
+	// Keyword matches titles containing the word or phrase, compared like
+	// search terms.
+	Keyword string `json:"keyword,omitempty"`
+	// Regex matches titles, it's case sensitive unless it starts with (?i).
+	Regex string `json:"regex,omitempty"`
+	// Domain matches items linking to the domain or its subdomains.
+	Domain string `json:"domain,omitempty"`
+	// MinScore matches items with at least this score. Only some sources
+	// report scores, so it needs a Source that does, like one using the
+	// hackernews or reddit converter.
+	MinScore int `json:"minScore,omitempty"`
+
+	re      *regexp.Regexp
+	keyword []string
+}
+
+// Suppression records an item the Bot didn't store and why.
+type Suppression struct {
+	Item Item      `json:"item"`
+	Rule string    `json:"rule"`
+	Time time.Time `json:"time"`
+}
+
+// maxSuppressions is the number of suppressed items the Bot remembers.
+const maxSuppressions = 1000
+
+// compile validates r and prepares it for matching.
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule has no name")
+	}
+
+	if r.Action != Include && r.Action != Exclude {
+		return fmt.Errorf("rule %s: action must be %q or %q", r.Name, Include, Exclude)
+	}
+
+	if r.Keyword == "" && r.Regex == "" && r.Domain == "" && r.MinScore == 0 {
+		return fmt.Errorf("rule %s has no conditions", r.Name)
+	}
+	if r.MinScore != 0 && r.Source == "" {
+		return fmt.Errorf("rule %s: min_score needs a source, not every source reports scores", r.Name)
+	}
+
+	r.re = nil
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("rule %s: %s", r.Name, err)
+		}
+		r.re = re
+	}
+
+	r.keyword = tokenize(r.Keyword)
+	if r.Keyword != "" && len(r.keyword) == 0 {
+		return fmt.Errorf("rule %s: keyword %q has no words", r.Name, r.Keyword)
+	}
+	return nil
+}
+
+// containsPhrase reports whether phrase appears in terms.
+func containsPhrase(terms, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(terms); i++ {
+		match := true
+		for j, term := range phrase {
+			if terms[i+j] != term {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether item matches every condition of r. The rule's
+// source isn't checked.
+func (r *Rule) Matches(item Item) bool {
+	if len(r.keyword) > 0 && !containsPhrase(tokenize(item.Title), r.keyword) {
+		return false
+	}
+
+	if r.re != nil && !r.re.MatchString(item.Title) {
+		return false
+	}
+
+	if r.Domain != "" {
+		domain := strings.TrimPrefix(strings.ToLower(r.Domain), "www.")
+		d := itemDomain(item)
+		if d != domain && !strings.HasSuffix(d, "."+domain) {
+			return false
+		}
+	}
+
+	return item.Score >= r.MinScore
+}
+
+// String describes the rule in one line.
+func (r Rule) String() string {
+	conditions := make([]string, 0, 4)
+	if r.Source != "" {
+		conditions = append(conditions, "source:"+r.Source)
+	}
+	if r.Keyword != "" {
+		conditions = append(conditions, fmt.Sprintf("keyword:%q", r.Keyword))
+	}
+	if r.Regex != "" {
+		conditions = append(conditions, "regex:"+r.Regex)
+	}
+	if r.Domain != "" {
+		conditions = append(conditions, "domain:"+r.Domain)
+	}
+	if r.MinScore != 0 {
+		conditions = append(conditions, fmt.Sprintf("score>=%d", r.MinScore))
+	}
+	return fmt.Sprintf("%s: %s %s", r.Name, r.Action, strings.Join(conditions, " "))
+}
+
+// compileRules validates rules, returning compiled copies.
+func compileRules(rules []Rule) ([]Rule, error) {
+	compiled := make([]Rule, len(rules))
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule name %s", rule.Name)
+		}
+		names[rule.Name] = true
+		compiled[i] = rule
+	}
+	return compiled, nil
+}
+
+// ReadRules decodes a json list of rules from r and validates them.
+func ReadRules(r io.Reader) ([]Rule, error) {
+	rules := make([]Rule, 0)
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, err
+	}
+	return compileRules(rules)
+}
+
+// WriteRules encodes rules to w as a json list.
+func WriteRules(w io.Writer, rules []Rule) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(rules)
+}
+
+// applyRules returns the name of the rule that suppresses item, or "" if
+// item should be stored. Exclude rules are checked first, then global and
+// per source include rules.
+func applyRules(rules []Rule, item Item) string {
+	var global, source []string
+	globalMatched, sourceMatched := false, false
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Source != "" && rule.Source != item.SourceName {
+			continue
+		}
+
+		matches := rule.Matches(item)
+		if rule.Action == Exclude {
+			if matches {
+				return rule.Name
+			}
+			continue
+		}
+
+		if rule.Source == "" {
+			global = append(global, rule.Name)
+			globalMatched = globalMatched || matches
+		} else {
+			source = append(source, rule.Name)
+			sourceMatched = sourceMatched || matches
+		}
+	}
+
+	if len(global) > 0 && !globalMatched {
+		return "not included by " + strings.Join(global, ", ")
+	}
+	if len(source) > 0 && !sourceMatched {
+		return "not included by " + strings.Join(source, ", ")
+	}
+	return ""
+}
+
+// checkRuleScores checks that the rules with a min_score are for sources
+// that report scores.
+func checkRuleScores(sources []Source, rules []Rule) error {
+	for _, rule := range rules {
+		if rule.MinScore == 0 {
+			continue
+		}
+		if err := checkScoreSources(sources, []string{rule.Source}); err != nil {
+			return fmt.Errorf("rule %s: %s", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// SetRules replaces the Bot's rules, which are applied to every item before
+// it's stored. The Bot's rules are left alone if any of rules is invalid.
+func (b *Bot) SetRules(rules []Rule) error {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if err := checkRuleScores(b.sources, compiled); err != nil {
+		return err
+	}
+	b.rules = compiled
+	return nil
+}
+
+// Rules returns a copy of the Bot's rules.
+func (b *Bot) Rules() []Rule {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return append([]Rule(nil), b.rules...)
+}
+
+// AddRule adds a rule, replacing any rule with the same name.
+func (b *Bot) AddRule(rule Rule) error {
+	if err := rule.compile(); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if err := checkRuleScores(b.sources, []Rule{rule}); err != nil {
+		return err
+	}
+	for i := range b.rules {
+		if b.rules[i].Name == rule.Name {
+			b.rules[i] = rule
+			return nil
+		}
+	}
+	b.rules = append(b.rules, rule)
+	return nil
+}
+
+// RemoveRule removes the named rule, reporting whether it existed.
+func (b *Bot) RemoveRule(name string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for i := range b.rules {
+		if b.rules[i].Name == name {
+			b.rules = append(b.rules[:i], b.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// suppress records that rule kept item from being stored. b.mux must be
+// held.
+func (b *Bot) suppress(item Item, rule string, now time.Time) {
+	if b.suppressed == nil {
+		b.suppressed = make(map[string]Suppression)
+	}
+	b.suppressed[item.URL] = Suppression{Item: item, Rule: rule, Time: now}
+
+	if len(b.suppressed) <= maxSuppressions {
+		return
+	}
+
+	// drop the oldest.
+	oldest := ""
+	for url, s := range b.suppressed {
+		if oldest == "" || s.Time.Before(b.suppressed[oldest].Time) {
+			oldest = url
+		}
+	}
+	delete(b.suppressed, oldest)
+}
+
+// Suppressed returns the items the Bot's rules kept it from storing, most
+// recent first. Each item is listed once, with the last rule that dropped
+// it.
+func (b *Bot) Suppressed() []Suppression {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	list := make([]Suppression, 0, len(b.suppressed))
+	for _, s := range b.suppressed {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Time.Equal(list[j].Time) {
+			return list[i].Time.After(list[j].Time)
+		}
+		return list[i].Item.URL < list[j].Item.URL
+	})
+	return list
+}
@@ -0,0 +1,202 @@
+package paperboy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron-like schedule, see ParseSchedule.
+type Schedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	// when either day field starts with *, days have to match both,
+	// otherwise either, like in cron.
+	anyDom, anyDow bool
+}
+
+// scheduleAliases are the named schedules cron knows.
+var scheduleAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseSchedule parses a cron schedule of five fields: minute, hour, day of
+// the month, month and day of the week, like "0 8 * * mon-fri" for 8:00 on
+// weekdays. Fields are * or comma separated numbers and ranges, optionally
+// with a /step, months and days can be written as jan and mon. Sunday is 0
+// or 7. The aliases @hourly, @daily, @weekly, @monthly and @yearly work too.
+func ParseSchedule(spec string) (Schedule, error) {
+	s := Schedule{spec: spec}
+	expanded := strings.TrimSpace(spec)
+	if alias, ok := scheduleAliases[strings.ToLower(expanded)]; ok {
+		expanded = alias
+	}
+
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("schedule %q: want 5 fields, minute hour day month weekday", spec)
+	}
+
+	parsers := []struct {
+		name     string
+		bits     *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{"minute", &s.minute, 0, 59, nil},
+		{"hour", &s.hour, 0, 23, nil},
+		{"day", &s.dom, 1, 31, nil},
+		{"month", &s.month, 1, 12, monthNames},
+		{"weekday", &s.dow, 0, 7, dayNames},
+	}
+	for i, p := range parsers {
+		bits, err := parseScheduleField(fields[i], p.min, p.max, p.names)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("schedule %q: %s %s", spec, p.name, err)
+		}
+		*p.bits = bits
+	}
+
+	// 7 is another Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDom = strings.HasPrefix(fields[2], "*")
+	s.anyDow = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseScheduleField returns the values field allows as bits.
+func parseScheduleField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q isn't a number", s)
+		}
+		if n < min || n > max {
+			return 0, fmt.Errorf("%d isn't between %d and %d", n, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("step %q isn't a positive number", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch i := strings.Index(part, "-"); {
+		case part == "*":
+		case i >= 0:
+			var err error
+			if lo, err = value(part[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(part[i+1:]); err != nil {
+				return 0, err
+			}
+			// a weekday range can end on Sunday, like fri-sun.
+			if max == 7 && hi == 0 && lo > 0 {
+				hi = 7
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %s goes backwards", part)
+			}
+		default:
+			n, err := value(part)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			if step == 1 {
+				hi = n
+			}
+		}
+
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+func (s Schedule) String() string {
+	return s.spec
+}
+
+// IsZero reports whether s is the zero Schedule, which never runs.
+func (s Schedule) IsZero() bool {
+	return s.minute == 0
+}
+
+// dayMatches reports whether s runs on t's day.
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t that s runs, in t's location. It
+// returns the zero time if s doesn't run in the next five years, like on
+// February 30th.
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.IsZero() {
+		return time.Time{}
+	}
+
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	// times in a daylight saving gap don't exist, time.Date can move them
+	// back before t. The next hour is after the gap.
+	forward := func(next time.Time) time.Time {
+		if next.After(t) {
+			return next
+		}
+		return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+	}
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = forward(time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+		case !s.dayMatches(t):
+			t = forward(time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = forward(time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,43 @@
+package paperboy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a Monday.
+	from := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		spec string
+		want []string
+	}{
+		{"0 9 * * fri-sun", []string{"2024-03-08 09:00", "2024-03-09 09:00", "2024-03-10 09:00", "2024-03-15 09:00"}},
+		{"0 9 * * 5-7", []string{"2024-03-08 09:00", "2024-03-09 09:00", "2024-03-10 09:00", "2024-03-15 09:00"}},
+		{"0 9 * * mon-sun", []string{"2024-03-05 09:00", "2024-03-06 09:00", "2024-03-07 09:00", "2024-03-08 09:00"}},
+		// a stepped day of the month still has to be a Monday.
+		{"0 9 */2 * mon", []string{"2024-03-11 09:00", "2024-03-25 09:00", "2024-04-01 09:00", "2024-04-15 09:00"}},
+		// both day fields restricted is either.
+		{"0 9 1 * mon", []string{"2024-03-11 09:00", "2024-03-18 09:00", "2024-03-25 09:00", "2024-04-01 09:00"}},
+	} {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %s", tt.spec, err)
+			continue
+		}
+		next := from
+		for _, want := range tt.want {
+			next = s.Next(next)
+			if got := next.Format("2006-01-02 15:04"); got != want {
+				t.Errorf("%s: got %s, want %s", tt.spec, got, want)
+				break
+			}
+		}
+	}
+
+	for _, spec := range []string{"0 9 * * sat-mon", "0 9 * * 3-1", "0 9 * *", "60 * * * *", "0 9 * * xyz"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%s: parsed", spec)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package paperboy
+
+import (
+	"fmt"
+	"github.com/google/logger"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sink delivers the Bot's new items through a notifier as they're stored,
+// the ones matching its filters.
+type Sink struct {
+	Name string `yaml:"name"`
+	// Notifier is the name of the notifier items are delivered with, see
+	// AddNotifier. To is passed on to it.
+	Notifier string `yaml:"notifier"`
+	To       string `yaml:"to"`
+	// Filter is a search query items have to match, see ParseQuery. Every
+	// item matches when it's empty.
+	Filter string `yaml:"filter"`
+	// Sources limits the sink to items from the named sources.
+	Sources []string `yaml:"sources"`
+	// MinScore limits the sink to items with at least this score. Only
+	// some sources report scores, so it needs Sources that all do.
+	MinScore int `yaml:"min_score"`
+	// Retries is the number of times a failed delivery is tried again,
+	// waiting RetryDelay before the first retry and twice as long before
+	// each one after it.
+	Retries    int           `yaml:"retries"`
+	RetryDelay time.Duration `yaml:"retry_delay"`
+
+	q Query
+}
+
+// DefaultRetryDelay is how long a sink waits before retrying a delivery
+// when it doesn't say.
+const DefaultRetryDelay = 5 * time.Second
+
+// maxSinkBatch is the number of items a sink delivers in one notification
+// at most.
+const maxSinkBatch = 50
+
+// maxDeliveries is the number of deliveries the Bot remembers.
+const maxDeliveries = 1000
+
+// compile validates s and parses its filter.
+func (s *Sink) compile() error {
+	if s.Name == "" {
+		return fmt.Errorf("sink has no name")
+	}
+	if s.Notifier == "" {
+		return fmt.Errorf("sink %s has no notifier", s.Name)
+	}
+	if s.Retries < 0 {
+		return fmt.Errorf("sink %s: retries can't be negative", s.Name)
+	}
+	if s.RetryDelay < 0 {
+		return fmt.Errorf("sink %s: retry delay can't be negative", s.Name)
+	}
+	if s.MinScore != 0 && len(s.Sources) == 0 {
+		return fmt.Errorf("sink %s: min_score needs sources, not every source reports scores", s.Name)
+	}
+
+	s.q = nil
+	if s.Filter != "" {
+		q, err := ParseQuery(s.Filter)
+		if err != nil {
+			return fmt.Errorf("sink %s: %s", s.Name, err)
+		}
+		s.q = q
+	}
+	return nil
+}
+
+// Matches reports whether item passes every filter of s.
+func (s *Sink) Matches(item Item) bool {
+	if len(s.Sources) > 0 && !containsString(s.Sources, item.SourceName) {
+		return false
+	}
+	if item.Score < s.MinScore {
+		return false
+	}
+	return s.q == nil || matchItem(s.q, item)
+}
+
+// String describes the sink in one line.
+func (s Sink) String() string {
+	conditions := make([]string, 0, 3)
+	if s.Filter != "" {
+		conditions = append(conditions, fmt.Sprintf("%q", s.Filter))
+	}
+	if len(s.Sources) > 0 {
+		conditions = append(conditions, "sources:"+strings.Join(s.Sources, ","))
+	}
+	if s.MinScore != 0 {
+		conditions = append(conditions, fmt.Sprintf("score>=%d", s.MinScore))
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "every item")
+	}
+
+	to := s.Notifier
+	if s.To != "" {
+		to += " " + s.To
+	}
+	return fmt.Sprintf("%s: %s -> %s", s.Name, strings.Join(conditions, " "), to)
+}
+
+// compileSinks validates sinks, returning compiled copies.
+func compileSinks(sinks []Sink) ([]Sink, error) {
+	compiled := make([]Sink, len(sinks))
+	names := make(map[string]bool)
+	for i, s := range sinks {
+		if err := s.compile(); err != nil {
+			return nil, err
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate sink name %s", s.Name)
+		}
+		names[s.Name] = true
+		compiled[i] = s
+	}
+	return compiled, nil
+}
+
+// Delivery records a sink delivering items, or failing to.
+type Delivery struct {
+	Sink     string    `json:"sink"`
+	Notifier string    `json:"notifier"`
+	Time     time.Time `json:"time"`
+	Items    int       `json:"items"`
+	// Attempts is the number of times the delivery was tried.
+	Attempts int `json:"attempts"`
+	// Error is the last attempt's error, empty when the delivery
+	// succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// sinkRunner is a sink's subscription and the goroutine delivering its
+// items.
+type sinkRunner struct {
+	sink   Sink
+	cancel func()
+	stop   chan struct{}
+}
+
+// AddSink starts delivering new items to a sink, replacing any sink with
+// the same name. Each sink has its own subscription, see Subscribe, so a
+// slow or failing sink doesn't hold up the Bot or the other sinks.
+func (b *Bot) AddSink(s Sink) error {
+	if err := s.compile(); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if s.MinScore != 0 {
+		if err := checkScoreSources(b.sources, s.Sources); err != nil {
+			return fmt.Errorf("sink %s: %s", s.Name, err)
+		}
+	}
+	if old, ok := b.sinks[s.Name]; ok {
+		old.close()
+	}
+	if b.sinks == nil {
+		b.sinks = make(map[string]*sinkRunner)
+	}
+
+	items, cancel := b.Subscribe(s.Matches)
+	r := &sinkRunner{sink: s, cancel: cancel, stop: make(chan struct{})}
+	b.sinks[s.Name] = r
+	go b.runSink(r, items)
+	return nil
+}
+
+// RemoveSink stops the named sink, reporting whether it existed. Items it
+// hasn't delivered yet are dropped.
+func (b *Bot) RemoveSink(name string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	r, ok := b.sinks[name]
+	if ok {
+		r.close()
+		delete(b.sinks, name)
+	}
+	return ok
+}
+
+// Sinks returns the Bot's sinks, ordered by name.
+func (b *Bot) Sinks() []Sink {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	sinks := make([]Sink, 0, len(b.sinks))
+	for _, r := range b.sinks {
+		sinks = append(sinks, r.sink)
+	}
+	sort.Slice(sinks, func(i, j int) bool {
+		return sinks[i].Name < sinks[j].Name
+	})
+	return sinks
+}
+
+// Deliveries returns the Bot's most recent deliveries, most recent first.
+func (b *Bot) Deliveries() []Delivery {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	list := make([]Delivery, len(b.deliveries))
+	for i, d := range b.deliveries {
+		list[len(list)-1-i] = d
+	}
+	return list
+}
+
+// close unsubscribes the sink and stops its goroutine.
+func (r *sinkRunner) close() {
+	r.cancel()
+	close(r.stop)
+}
+
+// runSink delivers the sink's items until it's stopped. Items that arrive
+// together are delivered in one notification.
+func (b *Bot) runSink(r *sinkRunner, items <-chan Item) {
+	for {
+		var item Item
+		var ok bool
+		select {
+		case item, ok = <-items:
+			if !ok {
+				return
+			}
+		case <-r.stop:
+			return
+		}
+
+		batch := []Item{item}
+	more:
+		for len(batch) < maxSinkBatch {
+			select {
+			case item, ok := <-items:
+				if !ok {
+					break more
+				}
+				batch = append(batch, item)
+			default:
+				break more
+			}
+		}
+
+		subject := fmt.Sprintf("%s: %d new items", r.sink.Name, len(batch))
+		if len(batch) == 1 {
+			subject = fmt.Sprintf("%s: new item", r.sink.Name)
+		}
+		b.deliverToSink(r, Notification{Subject: subject, To: r.sink.To, Items: batch})
+	}
+}
+
+// deliverToSink sends n with the sink's notifier, retrying as the sink
+// says, and records the delivery.
+func (b *Bot) deliverToSink(r *sinkRunner, n Notification) {
+	s := r.sink
+	delay := s.RetryDelay
+	if delay == 0 {
+		delay = DefaultRetryDelay
+	}
+
+	d := Delivery{Sink: s.Name, Notifier: s.Notifier, Items: len(n.Items)}
+	var err error
+	for {
+		d.Attempts++
+		if err = b.Notify(s.Notifier, n); err == nil || d.Attempts > s.Retries {
+			break
+		}
+		// only what wasn't delivered is tried again.
+		if partial, ok := err.(*PartialError); ok {
+			n.Items = partial.Undelivered
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+			continue
+		case <-r.stop:
+		}
+		break
+	}
+
+	d.Time = time.Now()
+	if err != nil {
+		d.Error = err.Error()
+		logger.Errorf("Error delivering %d items to sink %s: %s\n", len(n.Items), s.Name, err)
+	}
+	b.recordDelivery(d)
+}
+
+// recordDelivery adds d to the delivery log.
+func (b *Bot) recordDelivery(d Delivery) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.deliveries = append(b.deliveries, d)
+	if len(b.deliveries) > maxDeliveries {
+		b.deliveries = append([]Delivery(nil), b.deliveries[len(b.deliveries)-maxDeliveries:]...)
+	}
+}
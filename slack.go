@@ -22,9 +22,14 @@ type responseSelf struct {
 	ID string `json:"id"`
 }
 
-// StartRTM creates a session with Slack's Real Time Messaging API.
+// StartRTM creates a session with Slack's Real Time Messaging API, using the
+// token in the SLACK_API_TOKEN environment variable.
 func StartRTM() (wsurl, id string) {
-	token := os.Getenv("SLACK_API_TOKEN")
+	return StartRTMWithToken(os.Getenv("SLACK_API_TOKEN"))
+}
+
+// StartRTMWithToken creates a session like StartRTM with the given token.
+func StartRTMWithToken(token string) (wsurl, id string) {
 	url := fmt.Sprintf("https://slack.com/api/rtm.start?token=%s", token)
 
 	client := &http.Client{}
@@ -59,6 +64,7 @@ type Message struct {
 	ID      uint64 `json:"id"`
 	Type    string `json:"type"`
 	Channel string `json:"channel"`
+	User    string `json:"user,omitempty"`
 	Text    string `json:"text"`
 }
 
@@ -73,5 +79,7 @@ var counter uint64
 // PostMessage sends a message back to Slack.
 func PostMessage(ws *websocket.Conn, m Message) error {
 	m.ID = atomic.AddUint64(&counter, 1)
+	// the user is only set on received messages.
+	m.User = ""
 	return websocket.JSON.Send(ws, m)
 }
@@ -0,0 +1,313 @@
+package paperboy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SMTPSecurity is how an SMTPNotifier encrypts its connection.
+type SMTPSecurity string
+
+const (
+	// SMTPOpportunistic upgrades the connection with STARTTLS when the
+	// server offers it, like smtp.SendMail.
+	SMTPOpportunistic SMTPSecurity = ""
+	// SMTPStartTLS requires STARTTLS, mail isn't sent to servers that
+	// don't offer it.
+	SMTPStartTLS SMTPSecurity = "starttls"
+	// SMTPTLS connects with TLS from the start, usually on port 465.
+	SMTPTLS SMTPSecurity = "tls"
+	// SMTPPlain never encrypts the connection.
+	SMTPPlain SMTPSecurity = "none"
+)
+
+// smtpTimeout is how long an SMTPNotifier waits for the server to connect
+// and for the whole conversation.
+const smtpTimeout = 30 * time.Second
+
+// EmailSubscription is an address that's emailed the items of every
+// notification that match a query, see ParseQuery, like
+// "source:HackerNews golang". Every item matches when Query is empty.
+type EmailSubscription struct {
+	Address string
+	Query   string
+}
+
+// SMTPNotifier emails notifications, as plain text with an HTML
+// alternative.
+type SMTPNotifier struct {
+	// Addr is the server's host:port.
+	Addr string
+	From string
+	// To are the addresses notifications are sent to, unless the
+	// notification's To lists its own, separated by commas.
+	To []string
+	// Subscribers are each emailed the items matching their subscription
+	// separately, when the notification doesn't list its own addresses.
+	// Nothing is sent to subscribers none of the items match.
+	Subscribers []EmailSubscription
+	// Auth authenticates with the server, it's not used when nil.
+	Auth     smtp.Auth
+	Security SMTPSecurity
+	// TLSConfig is used for TLS and STARTTLS, one for Addr's host when
+	// it's nil.
+	TLSConfig *tls.Config
+}
+
+// Notify emails n. When some of the emails can't be sent the others still
+// are, and the first error is returned.
+func (s *SMTPNotifier) Notify(n Notification) error {
+	to := s.To
+	var subscribers []EmailSubscription
+	if n.To != "" {
+		to = splitList(n.To)
+	} else {
+		subscribers = s.Subscribers
+	}
+	if len(to) == 0 && len(subscribers) == 0 {
+		return fmt.Errorf("no one to email")
+	}
+
+	var errs []error
+	if len(to) > 0 {
+		if err := s.send(to, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, sub := range subscribers {
+		sn := n
+		if sub.Query != "" {
+			q, err := ParseQuery(sub.Query)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("subscription of %s: %s", sub.Address, err))
+				continue
+			}
+			sn = n.filter(func(item Item) bool { return matchItem(q, item) })
+		}
+		if len(sn.Items) == 0 {
+			continue
+		}
+		if err := s.send([]string{sub.Address}, sn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return fmt.Errorf("%s, and %d more errors", errs[0], len(errs)-1)
+}
+
+// checkAddresses returns an error for the first address that's empty or
+// has a line break, which would let it add headers or commands of its own.
+func checkAddresses(addrs ...string) error {
+	for _, addr := range addrs {
+		if addr == "" || strings.ContainsAny(addr, "\r\n") {
+			return fmt.Errorf("invalid email address %q", addr)
+		}
+	}
+	return nil
+}
+
+// send emails n to the addresses in to.
+func (s *SMTPNotifier) send(to []string, n Notification) error {
+	// to usually comes from a watchlist or sink, and goes into the headers.
+	if err := checkAddresses(append([]string{s.From}, to...)...); err != nil {
+		return err
+	}
+	msg, err := s.message(to, n)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if s.Security == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.Addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.Security == SMTPOpportunistic || s.Security == SMTPStartTLS {
+		ok, _ := c.Extension("STARTTLS")
+		switch {
+		case ok:
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		case s.Security == SMTPStartTLS:
+			return fmt.Errorf("%s doesn't support STARTTLS", s.Addr)
+		}
+	}
+
+	if s.Auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s doesn't support authentication", s.Addr)
+		}
+		if err := c.Auth(s.Auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return fmt.Errorf("%s: %s", addr, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message builds the email for n, a multipart/alternative message with the
+// plain text and HTML renderings of n.
+func (s *SMTPNotifier) message(to []string, n Notification) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		text        string
+	}{
+		{"text/plain; charset=utf-8", n.Render(PlainText)},
+		{"text/html; charset=utf-8", n.Render(HTML)},
+	}
+	for _, p := range parts {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(strings.Replace(p.text, "\n", "\r\n", -1))); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	domain := "paperboy"
+	if i := strings.LastIndex(s.From, "@"); i >= 0 {
+		domain = strings.Trim(s.From[i+1:], "> ")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", newDeliveryID(), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// newSMTPNotifier builds "smtp" notifiers, which email the comma separated
+// addresses in their to setting through the server at addr, logging in
+// with username and password if they're set. The security setting is
+// starttls, tls or none, STARTTLS is used when the server offers it if it's
+// not set. Settings like subscriber_alice@example.com subscribe an address
+// to the items matching the setting's query.
+func newSMTPNotifier(nc NotifierConfig) (Notifier, error) {
+	if err := requireSettings(nc, "addr", "from"); err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(nc.Settings["addr"])
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMTPNotifier{
+		Addr:     nc.Settings["addr"],
+		From:     nc.Settings["from"],
+		To:       splitList(nc.Settings["to"]),
+		Security: SMTPSecurity(nc.Settings["security"]),
+	}
+	if err := checkAddresses(append([]string{s.From}, s.To...)...); err != nil {
+		return nil, err
+	}
+	switch s.Security {
+	case SMTPOpportunistic, SMTPStartTLS, SMTPTLS, SMTPPlain:
+	default:
+		return nil, fmt.Errorf("security %q isn't starttls, tls or none", s.Security)
+	}
+
+	for key, query := range nc.Settings {
+		if !strings.HasPrefix(key, "subscriber_") {
+			continue
+		}
+		if query != "" {
+			if _, err := ParseQuery(query); err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+		}
+		address := strings.TrimPrefix(key, "subscriber_")
+		if err := checkAddresses(address); err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		s.Subscribers = append(s.Subscribers, EmailSubscription{address, query})
+	}
+	// settings are a map, keep the order the same every time.
+	sort.Slice(s.Subscribers, func(i, j int) bool {
+		return s.Subscribers[i].Address < s.Subscribers[j].Address
+	})
+	if len(s.To) == 0 && len(s.Subscribers) == 0 {
+		return nil, fmt.Errorf("smtp notifiers need the to setting or subscribers")
+	}
+
+	if nc.Settings["username"] != "" {
+		s.Auth = smtp.PlainAuth("", nc.Settings["username"], nc.Settings["password"], host)
+	}
+	return s, nil
+}
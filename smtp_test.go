@@ -0,0 +1,363 @@
+package paperboy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpSession is one connection to an smtpServer.
+type smtpSession struct {
+	tls  bool
+	from string
+	rcpt []string
+	data []byte
+}
+
+// smtpServer is an SMTP server that accepts every message, offering
+// STARTTLS when it has a TLS config. It records the sessions that sent
+// something.
+type smtpServer struct {
+	ln  net.Listener
+	tls *tls.Config
+
+	mux      sync.Mutex
+	sessions []smtpSession
+	wg       sync.WaitGroup
+}
+
+// newSMTPServer starts an smtpServer, and returns it with a TLS config a
+// client can verify it with.
+func newSMTPServer(t *testing.T, starttls bool) (*smtpServer, *tls.Config) {
+	// httptest has a certificate for 127.0.0.1 that's handy here.
+	hs := httptest.NewTLSServer(http.NotFoundHandler())
+	hs.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(hs.Certificate())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln}
+	if starttls {
+		s.tls = hs.TLS
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.wg.Wait()
+	})
+	return s, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func (s *smtpServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	var sess smtpSession
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP test")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(line[len(verb):])
+		switch verb {
+		case "EHLO", "HELO":
+			if s.tls != nil && !sess.tls {
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250 STARTTLS")
+			} else {
+				tp.PrintfLine("250 localhost")
+			}
+		case "STARTTLS":
+			if s.tls == nil || sess.tls {
+				tp.PrintfLine("502 not now")
+				continue
+			}
+			tp.PrintfLine("220 go ahead")
+			tc := tls.Server(conn, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			conn = tc
+			tp = textproto.NewConn(conn)
+			sess = smtpSession{tls: true}
+		case "MAIL":
+			sess.from = smtpPath(arg)
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			sess.rcpt = append(sess.rcpt, smtpPath(arg))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			if sess.data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mux.Lock()
+			s.sessions = append(s.sessions, sess)
+			s.mux.Unlock()
+			tp.PrintfLine("250 queued")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 %s isn't implemented", verb)
+		}
+	}
+}
+
+// smtpPath returns the address in a MAIL or RCPT argument, like
+// "TO:<a@example.com>".
+func smtpPath(arg string) string {
+	start, end := strings.Index(arg, "<"), strings.Index(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
+
+// received returns the sessions that sent a message.
+func (s *smtpServer) received() []smtpSession {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]smtpSession(nil), s.sessions...)
+}
+
+// parseEmail returns the headers of an email sent by an SMTPNotifier, and
+// its parts by content type.
+func parseEmail(t *testing.T, data []byte) (mail.Header, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	parts := make(map[string]string)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		body, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[p.Header.Get("Content-Type")] = string(body)
+	}
+	return msg.Header, parts
+}
+
+var smtpItems = []Item{
+	{Title: "Go 2 released", URL: "https://example.com/go2", SourceName: "HackerNews"},
+	{Title: "Rust & friends", URL: "https://example.com/rust", SourceName: "Reddit"},
+}
+
+func TestSMTPNotifierStartTLS(t *testing.T) {
+	srv, tlsConfig := newSMTPServer(t, true)
+	s := &SMTPNotifier{
+		Addr:      srv.ln.Addr().String(),
+		From:      "paperboy@example.com",
+		To:        []string{"alice@example.com", "bob@example.com"},
+		Security:  SMTPStartTLS,
+		TLSConfig: tlsConfig,
+	}
+	if err := s.Notify(Notification{Subject: "2 new items ✓", Items: smtpItems}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("server got %d messages, want 1", len(got))
+	}
+	sess := got[0]
+	if !sess.tls {
+		t.Error("message was sent without STARTTLS")
+	}
+	if sess.from != "paperboy@example.com" || strings.Join(sess.rcpt, ",") != "alice@example.com,bob@example.com" {
+		t.Errorf("envelope is from %q to %q", sess.from, sess.rcpt)
+	}
+
+	header, parts := parseEmail(t, sess.data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != "2 new items ✓" {
+		t.Errorf("subject is %q: %v", subject, err)
+	}
+	if to := header.Get("To"); to != "alice@example.com, bob@example.com" {
+		t.Errorf("To header is %q", to)
+	}
+	if header.Get("Message-ID") == "" || header.Get("Date") == "" {
+		t.Error("Message-ID or Date header missing")
+	}
+
+	text, html := parts["text/plain; charset=utf-8"], parts["text/html; charset=utf-8"]
+	if len(parts) != 2 || text == "" || html == "" {
+		t.Fatalf("got parts %v, want plain text and HTML", parts)
+	}
+	for _, item := range smtpItems {
+		if !strings.Contains(text, item.Title) || !strings.Contains(text, item.URL) {
+			t.Errorf("plain text part is missing %s:\n%s", item.Title, text)
+		}
+		if !strings.Contains(html, `href="`+item.URL+`"`) {
+			t.Errorf("HTML part doesn't link %s:\n%s", item.URL, html)
+		}
+	}
+	if !strings.Contains(html, "Rust &amp; friends") {
+		t.Errorf("HTML part isn't escaped:\n%s", html)
+	}
+}
+
+func TestSMTPNotifierOpportunistic(t *testing.T) {
+	n := Notification{Subject: "s", Items: smtpItems[:1]}
+
+	// STARTTLS is used when it's offered.
+	srv, tlsConfig := newSMTPServer(t, true)
+	s := &SMTPNotifier{Addr: srv.ln.Addr().String(), From: "paperboy@example.com", To: []string{"a@example.com"}, TLSConfig: tlsConfig}
+	if err := s.Notify(n); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.received(); len(got) != 1 || !got[0].tls {
+		t.Fatalf("got %+v, want 1 message over TLS", got)
+	}
+
+	// the message is sent in plain text when it's not, unless STARTTLS is
+	// required.
+	srv, tlsConfig = newSMTPServer(t, false)
+	s = &SMTPNotifier{Addr: srv.ln.Addr().String(), From: "paperboy@example.com", To: []string{"a@example.com"}, TLSConfig: tlsConfig}
+	if err := s.Notify(n); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.received(); len(got) != 1 || got[0].tls {
+		t.Fatalf("got %+v, want 1 message in plain text", got)
+	}
+	s.Security = SMTPStartTLS
+	if err := s.Notify(n); err == nil {
+		t.Fatal("sent a message that requires STARTTLS to a server without it")
+	}
+	if got := srv.received(); len(got) != 1 {
+		t.Fatalf("server got %d messages, want 1", len(got))
+	}
+}
+
+func TestSMTPNotifierSubscribers(t *testing.T) {
+	srv, _ := newSMTPServer(t, false)
+	s := &SMTPNotifier{
+		Addr:     srv.ln.Addr().String(),
+		From:     "paperboy@example.com",
+		Security: SMTPPlain,
+		Subscribers: []EmailSubscription{
+			{Address: "all@example.com"},
+			{Address: "hn@example.com", Query: "source:HackerNews"},
+			{Address: "rust@example.com", Query: "rust"},
+			{Address: "none@example.com", Query: "haskell"},
+		},
+	}
+	if err := s.Notify(Notification{Subject: "s", Items: smtpItems}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"all@example.com":  {"Go 2 released", "Rust & friends"},
+		"hn@example.com":   {"Go 2 released"},
+		"rust@example.com": {"Rust & friends"},
+	}
+	got := make(map[string][]string)
+	for _, sess := range srv.received() {
+		if len(sess.rcpt) != 1 {
+			t.Fatalf("message sent to %v, want one subscriber", sess.rcpt)
+		}
+		_, parts := parseEmail(t, sess.data)
+		for _, item := range smtpItems {
+			if strings.Contains(parts["text/plain; charset=utf-8"], item.Title) {
+				got[sess.rcpt[0]] = append(got[sess.rcpt[0]], item.Title)
+			}
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("subscribers got %v, want %v", got, want)
+	}
+	for addr, titles := range want {
+		sort.Strings(got[addr])
+		if strings.Join(got[addr], "|") != strings.Join(titles, "|") {
+			t.Errorf("%s got %v, want %v", addr, got[addr], titles)
+		}
+	}
+
+	// a notification's own addresses replace the subscribers.
+	if err := s.Notify(Notification{Subject: "s", To: "x@example.com, y@example.com", Items: smtpItems}); err != nil {
+		t.Fatal(err)
+	}
+	sessions := srv.received()
+	if last := sessions[len(sessions)-1]; strings.Join(last.rcpt, ",") != "x@example.com,y@example.com" {
+		t.Errorf("message sent to %v, want the notification's addresses", last.rcpt)
+	}
+	if len(sessions) != len(want)+1 {
+		t.Errorf("server got %d messages, want %d", len(sessions), len(want)+1)
+	}
+}
+
+func TestSMTPNotifierRejectsLineBreaks(t *testing.T) {
+	srv, _ := newSMTPServer(t, false)
+	s := &SMTPNotifier{Addr: srv.ln.Addr().String(), From: "paperboy@example.com", Security: SMTPPlain, To: []string{"a@example.com"}}
+	for _, to := range []string{
+		"a@example.com\r\nBcc: evil@example.com",
+		"a@example.com\nSubject: spam",
+	} {
+		if err := s.Notify(Notification{Subject: "s", To: to, Items: smtpItems}); err == nil {
+			t.Errorf("sent to %q", to)
+		}
+	}
+	if got := srv.received(); len(got) != 0 {
+		t.Fatalf("server got %d messages, want none", len(got))
+	}
+
+	_, err := newSMTPNotifier(NotifierConfig{Settings: map[string]string{
+		"addr": srv.ln.Addr().String(), "from": "paperboy@example.com", "to": "a@example.com\nBcc: b@example.com",
+	}})
+	if err == nil {
+		t.Error("built a notifier with a line break in its to setting")
+	}
+
+	// the messages themselves are fine.
+	if err := s.Notify(Notification{Subject: "s", Items: smtpItems}); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.received(); len(got) != 1 {
+		t.Fatalf("server got %d messages, want 1", len(got))
+	}
+}
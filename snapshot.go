@@ -0,0 +1,393 @@
+package paperboy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format Dump writes.
+//
+// Version 1 is the format Dump wrote before snapshots were versioned, a
+// json object of the items every consumer had read keyed by URL. DumpAll's
+// json list of items is read as version 1 too. Version 3 added the URLs of
+// evicted items, which version 2 snapshots load without.
+const SnapshotVersion = 3
+
+// Snapshot is the state of a Bot as Dump writes it and Load reads it.
+type Snapshot struct {
+	Version int       `json:"version"`
+	Saved   time.Time `json:"saved"`
+	// Items are in the order they were added to the Bot.
+	Items []SnapshotItem `json:"items"`
+	// Consumers maps each consumer to the URLs of the items it hasn't
+	// read.
+	Consumers map[string][]string `json:"consumers"`
+	Sources   []Source            `json:"sources"`
+	// Marks maps each consumer to its starred, tagged and read later
+	// items.
+	Marks map[string][]SnapshotMark `json:"marks,omitempty"`
+	// Evicted remembers the URLs of evicted items, so they aren't
+	// announced again after a restart.
+	Evicted []SnapshotBloom `json:"evicted,omitempty"`
+}
+
+// SnapshotItem is an item and what the Bot knows about it.
+type SnapshotItem struct {
+	Item
+	// Read is set for items every consumer has read, including ones that
+	// don't exist yet.
+	Read      bool       `json:"read,omitempty"`
+	LastUsed  time.Time  `json:"lastUsed"`
+	Sightings []Sighting `json:"sightings,omitempty"`
+}
+
+// SnapshotMark is what a consumer has attached to an item.
+type SnapshotMark struct {
+	Item    Item      `json:"item"`
+	Starred time.Time `json:"starred"`
+	Later   time.Time `json:"later"`
+	Tags    []string  `json:"tags,omitempty"`
+	// TagsAdded is when each tag was attached. Older snapshots only have
+	// Tagged, when the last tag was attached.
+	TagsAdded map[string]time.Time `json:"tagsAdded,omitempty"`
+	Tagged    time.Time            `json:"tagged"`
+}
+
+// SnapshotBloom is a time bucket of the Bloom filter of evicted URLs.
+type SnapshotBloom struct {
+	Start  time.Time `json:"start"`
+	Hashes uint32    `json:"hashes"`
+	// Bits are the filter's bits, 8 little endian bytes per 64 bits.
+	Bits []byte `json:"bits"`
+	// Count is the number of URLs added to the filter.
+	Count int `json:"count"`
+}
+
+// LoadStrategy decides what Load does with what the Bot already has.
+type LoadStrategy int
+
+const (
+	// Merge adds the snapshot's items, consumers, marks and sources to
+	// the ones the Bot has. Where both have the same one, the Bot's is
+	// kept.
+	Merge LoadStrategy = iota
+	// Replace drops the Bot's items, consumers and marks, and its sources
+	// if the snapshot has any, in favor of the snapshot's.
+	Replace
+)
+
+// ParseLoadStrategy returns the strategy called s, "merge" or "replace".
+func ParseLoadStrategy(s string) (LoadStrategy, error) {
+	switch s {
+	case "merge":
+		return Merge, nil
+	case "replace":
+		return Replace, nil
+	}
+	return Merge, fmt.Errorf("unknown load strategy %q, use merge or replace", s)
+}
+
+// snapshot captures the Bot's state. b.mux must be held.
+func (b *Bot) snapshot(now time.Time) Snapshot {
+	s := Snapshot{
+		Version:   SnapshotVersion,
+		Saved:     now,
+		Items:     make([]SnapshotItem, len(b.log)),
+		Consumers: make(map[string][]string, len(b.consumers)),
+		Sources:   append([]Source(nil), b.sources...),
+	}
+
+	for i, e := range b.log {
+		s.Items[i] = SnapshotItem{
+			Item:      e.item,
+			Read:      b.readByAll(e),
+			LastUsed:  b.lastUsed[e.item.URL],
+			Sightings: b.history[e.item.URL],
+		}
+	}
+
+	for name, c := range b.consumers {
+		unread := make([]string, 0)
+		for _, e := range b.unreadEntries(c) {
+			unread = append(unread, e.item.URL)
+		}
+		s.Consumers[name] = unread
+	}
+
+	if len(b.marks) > 0 {
+		s.Marks = make(map[string][]SnapshotMark, len(b.marks))
+	}
+	for consumer, marks := range b.marks {
+		list := make([]SnapshotMark, 0, len(marks))
+		for _, m := range marks {
+			sm := SnapshotMark{Item: m.item, Starred: m.starred, Later: m.later, Tags: make([]string, 0, len(m.tags))}
+			for tag, added := range m.tags {
+				sm.Tags = append(sm.Tags, tag)
+				if sm.TagsAdded == nil {
+					sm.TagsAdded = make(map[string]time.Time, len(m.tags))
+				}
+				sm.TagsAdded[tag] = added
+				if added.After(sm.Tagged) {
+					sm.Tagged = added
+				}
+			}
+			sort.Strings(sm.Tags)
+			list = append(list, sm)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Item.URL < list[j].Item.URL
+		})
+		s.Marks[consumer] = list
+	}
+
+	if b.evicted != nil {
+		b.evicted.expire(now)
+		for _, bucket := range b.evicted.buckets {
+			s.Evicted = append(s.Evicted, SnapshotBloom{bucket.start, bucket.filter.hashes, bucket.filter.bytes(), bucket.count})
+		}
+	}
+	return s
+}
+
+// ReadSnapshot decodes a snapshot of any version, upgrading older ones to
+// the current version.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return readItemList(data)
+	}
+
+	// version 1 had no version field, though an item's URL could have
+	// been "version".
+	var header struct {
+		Version interface{} `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	version, ok := header.Version.(float64)
+	if !ok {
+		return readVersion1(data)
+	}
+	if version > SnapshotVersion {
+		return nil, fmt.Errorf("snapshot version %v is newer than this paperboy, which reads up to version %d",
+			version, SnapshotVersion)
+	}
+
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	for _, bucket := range s.Evicted {
+		if _, err := bloomFilterFromBytes(bucket.Bits, bucket.Hashes); err != nil {
+			return nil, err
+		}
+	}
+	s.Version = SnapshotVersion
+	return s, nil
+}
+
+// readVersion1 upgrades a map of read items by URL.
+func readVersion1(data []byte) (*Snapshot, error) {
+	items := make(map[string]Item)
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+
+	// map order is lost, the oldest items go first.
+	list := make([]Item, 0, len(items))
+	for _, item := range items {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].FirstSeen.Equal(list[j].FirstSeen) {
+			return list[i].FirstSeen.Before(list[j].FirstSeen)
+		}
+		return list[i].URL < list[j].URL
+	})
+	return upgradeItems(list), nil
+}
+
+// readItemList upgrades the list of items DumpAll writes.
+func readItemList(data []byte) (*Snapshot, error) {
+	list := make([]Item, 0)
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return upgradeItems(list), nil
+}
+
+// upgradeItems makes a snapshot of items from a version 1 snapshot, which
+// are loaded as read like they always were.
+func upgradeItems(items []Item) *Snapshot {
+	s := &Snapshot{Version: SnapshotVersion, Items: make([]SnapshotItem, len(items))}
+	for i, item := range items {
+		s.Items[i] = SnapshotItem{Item: item, Read: true}
+	}
+	return s
+}
+
+// Dump writes a snapshot of the Bot's items, read state, sources and marks
+// to w as json.
+func (b *Bot) Dump(w io.Writer) error {
+	_, err := b.dump(w)
+	return err
+}
+
+// dump writes a snapshot to w like Dump, and returns the number of changes
+// it includes, see changed.
+func (b *Bot) dump(w io.Writer) (int, error) {
+	b.mux.Lock()
+	s := b.snapshot(time.Now())
+	changes := b.changes
+	b.mux.Unlock()
+
+	enc := json.NewEncoder(w)
+	return changes, enc.Encode(s)
+}
+
+// Load merges a snapshot written by Dump, of any version, into the Bot.
+// Loaded items don't count as changes for the autosave policy.
+func (b *Bot) Load(r io.Reader) error {
+	return b.LoadWith(r, Merge)
+}
+
+// LoadWith loads a snapshot written by Dump, of any version, using strategy.
+// The Bot is left alone if the snapshot can't be read.
+func (b *Bot) LoadWith(r io.Reader, strategy LoadStrategy) error {
+	s, err := ReadSnapshot(r)
+	if err != nil {
+		return err
+	}
+	if err := validateSources(s.Sources); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	if strategy == Replace {
+		b.reset(len(s.Sources) > 0)
+	}
+	sourcesChanged := b.restoreSnapshot(s, time.Now())
+	b.mux.Unlock()
+
+	if sourcesChanged {
+		return b.saveSources()
+	}
+	return nil
+}
+
+// reset drops the Bot's items, consumers and marks, and its sources if
+// sources is set. b.mux must be held.
+func (b *Bot) reset(sources bool) {
+	b.log = nil
+	b.entries = make(map[string]*logEntry)
+	b.consumers = make(map[string]*cursor)
+	b.lastUsed = make(map[string]time.Time)
+	b.index = NewIndex()
+	b.marks = nil
+	b.alerted = nil
+	b.history = nil
+	if sources {
+		b.sources = nil
+	}
+}
+
+// restoreSnapshot adds what's in s that the Bot doesn't have, reporting
+// whether sources were added. b.mux must be held.
+func (b *Bot) restoreSnapshot(s *Snapshot, now time.Time) bool {
+	added := make([]*logEntry, 0, len(s.Items))
+	for _, si := range s.Items {
+		if _, exists := b.entries[si.URL]; exists {
+			continue
+		}
+
+		// items saved before FirstSeen existed would be evicted right
+		// away by a MaxAge policy.
+		item := si.Item
+		if item.FirstSeen.IsZero() {
+			item.FirstSeen = now
+		}
+		added = append(added, b.appendEntry(item, si.Read))
+
+		lastUsed := si.LastUsed
+		if lastUsed.IsZero() {
+			lastUsed = now
+		}
+		b.touch(item.URL, lastUsed)
+
+		if len(si.Sightings) > 0 {
+			if b.history == nil {
+				b.history = make(map[string][]Sighting)
+			}
+			b.history[item.URL] = si.Sightings
+		}
+	}
+
+	// the added items are unread for consumers the snapshot doesn't know,
+	// like for consumers that didn't exist yet.
+	for name, urls := range s.Consumers {
+		unread := makeSet(urls)
+		c := b.cursor(name, true)
+		for _, e := range added {
+			if !unread[e.item.URL] {
+				c.read[e.item.URL] = true
+			}
+		}
+		b.advance(c)
+	}
+
+	for consumer, list := range s.Marks {
+		marks := b.marksFor(consumer, true)
+		for _, sm := range list {
+			if _, exists := marks[sm.Item.URL]; exists {
+				continue
+			}
+			m := &mark{sm.Item, sm.Starred, sm.Later, make(map[string]time.Time)}
+			for _, tag := range sm.Tags {
+				added, ok := sm.TagsAdded[tag]
+				if !ok {
+					added = sm.Tagged
+				}
+				m.tags[tag] = added
+			}
+			if !m.empty() {
+				marks[sm.Item.URL] = m
+			}
+		}
+		if len(marks) == 0 {
+			delete(b.marks, consumer)
+		}
+	}
+
+	for _, bucket := range s.Evicted {
+		// ReadSnapshot checked the filters.
+		filter, _ := bloomFilterFromBytes(bucket.Bits, bucket.Hashes)
+		b.evictedURLs().merge(bucket.Start, filter, bucket.Count)
+	}
+
+	sourcesChanged := false
+	for _, source := range s.Sources {
+		exists := false
+		for _, existing := range b.sources {
+			if existing.Name == source.Name {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			b.sources = append(b.sources, source)
+			sourcesChanged = true
+		}
+	}
+	return sourcesChanged
+}
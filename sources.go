@@ -0,0 +1,400 @@
+package paperboy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/andybalholm/cascadia"
+	"github.com/google/logger"
+	"golang.org/x/net/html"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+)
+
+// converters maps names to the converters sources can refer to with their
+// Converter field.
+var converters = map[string]func(matches []*html.Node) []Item{
+	"anchor":     AnchorConverter,
+	"hackernews": HackerNewsConverter,
+	"reddit":     RedditConverter,
+}
+
+// scoringConverters are the converters that fill in items' scores.
+var scoringConverters = map[string]bool{
+	"hackernews": true,
+	"reddit":     true,
+}
+
+var convertersMux sync.RWMutex
+
+// RegisterConverter makes convert available to sources as name.
+func RegisterConverter(name string, convert func(matches []*html.Node) []Item) {
+	convertersMux.Lock()
+	converters[name] = convert
+	delete(scoringConverters, name)
+	convertersMux.Unlock()
+}
+
+// RegisterScoringConverter registers convert like RegisterConverter, for
+// converters that fill in items' scores, so min_score can be used with
+// their sources.
+func RegisterScoringConverter(name string, convert func(matches []*html.Node) []Item) {
+	convertersMux.Lock()
+	converters[name] = convert
+	scoringConverters[name] = true
+	convertersMux.Unlock()
+}
+
+// Converters returns the names of the registered converters.
+func Converters() []string {
+	convertersMux.RLock()
+	defer convertersMux.RUnlock()
+
+	names := make([]string, 0, len(converters))
+	for name := range converters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// converter returns the function that turns the source's matches into
+// items.
+func (s Source) converter() (func(matches []*html.Node) []Item, error) {
+	if s.ConvertFunc != nil {
+		return s.ConvertFunc, nil
+	}
+
+	name := s.Converter
+	if name == "" {
+		name = "anchor"
+	}
+	convertersMux.RLock()
+	convert, ok := converters[name]
+	convertersMux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("source %s: no converter named %s", s.Name, name)
+	}
+	return convert, nil
+}
+
+// Validate checks that s has a name, an http or https URL and, unless it's a
+// feed, a valid selector and a converter.
+func (s Source) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("source has no name")
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("source %s: %s", s.Name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("source %s: URL must be an absolute http or https URL", s.Name)
+	}
+
+	switch s.Type {
+	case FeedSource:
+		return nil
+	case "", HTMLSource:
+	default:
+		return fmt.Errorf("source %s: unknown type %s, use %s or %s", s.Name, s.Type, HTMLSource, FeedSource)
+	}
+
+	if _, err = cascadia.Compile(s.Selector); err != nil {
+		return fmt.Errorf("source %s: invalid selector: %s", s.Name, err)
+	}
+
+	_, err = s.converter()
+	return err
+}
+
+// reportsScores reports whether the source's items have scores, which only
+// the converters registered as scoring ones fill in. Feeds and the other
+// converters leave every item with a score of 0, and a ConvertFunc is only
+// known by the name it's registered under.
+func (s Source) reportsScores() bool {
+	if s.Type == FeedSource {
+		return false
+	}
+	convertersMux.RLock()
+	defer convertersMux.RUnlock()
+	return scoringConverters[s.Converter]
+}
+
+// checkScoreSources returns an error when one of the named sources doesn't
+// report scores, so a min_score condition on it would never match. Names
+// that aren't in sources are skipped.
+func checkScoreSources(sources []Source, names []string) error {
+	for _, source := range sources {
+		if containsString(names, source.Name) && !source.reportsScores() {
+			return fmt.Errorf("source %s doesn't report scores, min_score can't be used with it", source.Name)
+		}
+	}
+	return nil
+}
+
+// validateSources validates every source and checks their names are
+// unique.
+func validateSources(sources []Source) error {
+	names := make(map[string]bool)
+	for _, source := range sources {
+		if err := source.Validate(); err != nil {
+			return err
+		}
+		if names[source.Name] {
+			return fmt.Errorf("duplicate source name %s", source.Name)
+		}
+		names[source.Name] = true
+	}
+	return nil
+}
+
+// ReadSources decodes a json list of sources from r and validates them.
+func ReadSources(r io.Reader) ([]Source, error) {
+	sources := make([]Source, 0)
+	if err := json.NewDecoder(r).Decode(&sources); err != nil {
+		return nil, err
+	}
+	if err := validateSources(sources); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// checkSavable returns an error for the first source with a ConvertFunc and
+// no Converter name, it would be read back with the anchor converter.
+func checkSavable(sources []Source) error {
+	for _, s := range sources {
+		if s.ConvertFunc != nil && s.Converter == "" && s.Type != FeedSource {
+			return fmt.Errorf("source %s: its ConvertFunc can't be saved, register it and set Converter to its name", s.Name)
+		}
+	}
+	return nil
+}
+
+// WriteSources encodes sources to w as a json list. Sources only keep their
+// converter's name, so ConvertFuncs have to be registered to be read back,
+// and sources without a Converter name are rejected.
+func WriteSources(w io.Writer, sources []Source) error {
+	if err := checkSavable(sources); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(sources)
+}
+
+// Sources returns a copy of the Bot's sources.
+func (b *Bot) Sources() []Source {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return append([]Source(nil), b.sources...)
+}
+
+// activeSources returns the sources that aren't paused.
+func (b *Bot) activeSources() []Source {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	active := make([]Source, 0, len(b.sources))
+	for _, source := range b.sources {
+		if !source.Paused {
+			active = append(active, source)
+		}
+	}
+	return active
+}
+
+// SetSources replaces the Bot's sources. The Bot's sources are left alone if
+// any of sources is invalid.
+func (b *Bot) SetSources(sources []Source) error {
+	if err := validateSources(sources); err != nil {
+		return err
+	}
+	if err := b.checkSourcesSavable(sources); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	b.sources = append([]Source(nil), sources...)
+	b.mux.Unlock()
+	return b.saveSources()
+}
+
+// AddSource adds a source, it's polled from the Bot's next poll on.
+func (b *Bot) AddSource(source Source) error {
+	if err := source.Validate(); err != nil {
+		return err
+	}
+	if err := b.checkSourcesSavable([]Source{source}); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	for _, s := range b.sources {
+		if s.Name == source.Name {
+			b.mux.Unlock()
+			return fmt.Errorf("there's already a source named %s", source.Name)
+		}
+	}
+	b.sources = append(b.sources, source)
+	b.mux.Unlock()
+
+	if b.Archive != nil {
+		if err := b.Archive.RecordSources([]Source{source}); err != nil {
+			logger.Errorf("Error archiving sources: %s\n", err)
+		}
+	}
+	return b.saveSources()
+}
+
+// ImportSources adds the sources whose names and URLs the Bot doesn't have
+// yet, returning the ones it added. Nothing is added if any of sources is
+// invalid.
+func (b *Bot) ImportSources(sources []Source) ([]Source, error) {
+	if err := validateSources(sources); err != nil {
+		return nil, err
+	}
+	if err := b.checkSourcesSavable(sources); err != nil {
+		return nil, err
+	}
+
+	b.mux.Lock()
+	known := make(map[string]bool)
+	for _, s := range b.sources {
+		known[s.Name] = true
+		known[s.URL] = true
+	}
+	added := make([]Source, 0)
+	for _, s := range sources {
+		if known[s.Name] || known[s.URL] {
+			continue
+		}
+		b.sources = append(b.sources, s)
+		added = append(added, s)
+	}
+	b.mux.Unlock()
+
+	if len(added) == 0 {
+		return added, nil
+	}
+	if b.Archive != nil {
+		if err := b.Archive.RecordSources(added); err != nil {
+			logger.Errorf("Error archiving sources: %s\n", err)
+		}
+	}
+	return added, b.saveSources()
+}
+
+// RemoveSource removes the named source, reporting whether it existed. Its
+// items are kept.
+func (b *Bot) RemoveSource(name string) (bool, error) {
+	b.mux.Lock()
+	removed := false
+	for i := range b.sources {
+		if b.sources[i].Name == name {
+			b.sources = append(b.sources[:i], b.sources[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	b.mux.Unlock()
+
+	if !removed {
+		return false, nil
+	}
+	return true, b.saveSources()
+}
+
+// setPaused pauses or resumes the named source, reporting whether it
+// existed.
+func (b *Bot) setPaused(name string, paused bool) (bool, error) {
+	b.mux.Lock()
+	found := false
+	for i := range b.sources {
+		if b.sources[i].Name == name {
+			b.sources[i].Paused = paused
+			found = true
+			break
+		}
+	}
+	b.mux.Unlock()
+
+	if !found {
+		return false, nil
+	}
+	return true, b.saveSources()
+}
+
+// PauseSource stops the named source being polled until it's resumed,
+// reporting whether it exists.
+func (b *Bot) PauseSource(name string) (bool, error) {
+	return b.setPaused(name, true)
+}
+
+// ResumeSource resumes polling the named source, reporting whether it
+// exists.
+func (b *Bot) ResumeSource(name string) (bool, error) {
+	return b.setPaused(name, false)
+}
+
+// checkSourcesSavable checks sources can be saved, if the Bot saves its
+// sources.
+func (b *Bot) checkSourcesSavable(sources []Source) error {
+	b.mux.Lock()
+	path := b.SourcesPath
+	b.mux.Unlock()
+	if path == "" {
+		return nil
+	}
+	return checkSavable(sources)
+}
+
+// saveSources writes the source list to SourcesPath, if it's set.
+func (b *Bot) saveSources() error {
+	b.mux.Lock()
+	path := b.SourcesPath
+	b.mux.Unlock()
+	if path == "" {
+		return nil
+	}
+
+	b.saveMux.Lock()
+	defer b.saveMux.Unlock()
+
+	sources := b.Sources()
+	return writeFileAtomic(path, 0, func(w io.Writer) error {
+		return WriteSources(w, sources)
+	})
+}
+
+// RestoreSources replaces the Bot's sources with the ones saved to
+// SourcesPath. It isn't an error for the file not to exist, the Bot keeps
+// its sources.
+func (b *Bot) RestoreSources() error {
+	if b.SourcesPath == "" {
+		return nil
+	}
+
+	f, err := os.Open(b.SourcesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sources, err := ReadSources(f)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %s", b.SourcesPath, err)
+	}
+
+	b.mux.Lock()
+	b.sources = sources
+	b.mux.Unlock()
+	return nil
+}
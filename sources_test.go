@@ -0,0 +1,61 @@
+package paperboy
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSourceReportsScores(t *testing.T) {
+	custom := func(matches []*html.Node) []Item { return AnchorConverter(matches) }
+	RegisterConverter("test-plain", custom)
+	RegisterScoringConverter("test-scored", custom)
+
+	for _, tt := range []struct {
+		source Source
+		want   bool
+	}{
+		{Source{Name: "hn", Converter: "hackernews"}, true},
+		{Source{Name: "reddit", Converter: "reddit"}, true},
+		{Source{Name: "scored", Converter: "test-scored"}, true},
+		{Source{Name: "default"}, false},
+		{Source{Name: "anchor", Converter: "anchor"}, false},
+		{Source{Name: "plain", Converter: "test-plain"}, false},
+		{Source{Name: "func", ConvertFunc: custom}, false},
+		{Source{Name: "feed", Type: FeedSource, Converter: "hackernews"}, false},
+	} {
+		if got := tt.source.reportsScores(); got != tt.want {
+			t.Errorf("source %s reports scores %v, want %v", tt.source.Name, got, tt.want)
+		}
+	}
+}
+
+func TestSavedSourcesKeepConverters(t *testing.T) {
+	RegisterScoringConverter("test-saved", HackerNewsConverter)
+	path := filepath.Join(t.TempDir(), "sources.json")
+	b := NewBot(nil)
+	b.SourcesPath = path
+
+	unnamed := Source{Name: "unnamed", URL: "https://example.com/a", Selector: "a", ConvertFunc: AnchorConverter}
+	if err := b.AddSource(unnamed); err == nil {
+		t.Fatal("added a source whose converter can't be saved")
+	}
+	if n := len(b.Sources()); n != 0 {
+		t.Fatalf("Bot has %d sources after a failed add", n)
+	}
+
+	named := Source{Name: "named", URL: "https://example.com/b", Selector: "a", Converter: "test-saved", ConvertFunc: HackerNewsConverter}
+	if err := b.AddSource(named); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewBot(nil)
+	restored.SourcesPath = path
+	if err := restored.RestoreSources(); err != nil {
+		t.Fatal(err)
+	}
+	sources := restored.Sources()
+	if len(sources) != 1 || sources[0].Converter != "test-saved" || !sources[0].reportsScores() {
+		t.Fatalf("restored sources %+v, want named with its converter", sources)
+	}
+}
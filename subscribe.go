@@ -0,0 +1,105 @@
+package paperboy
+
+import (
+	"github.com/google/logger"
+	"sync"
+)
+
+// DropPolicy decides which item is lost when a subscriber's buffer is full.
+type DropPolicy int
+
+const (
+	// DropOldest discards the oldest buffered item to make room for the
+	// new one.
+	DropOldest DropPolicy = iota
+	// DropNewest discards the new item, keeping what's buffered.
+	DropNewest
+)
+
+// DefaultSubscriberBuffer is the number of items buffered for each
+// subscriber.
+const DefaultSubscriberBuffer = 100
+
+// subscriber is a listener registered with Subscribe.
+type subscriber struct {
+	ch      chan Item
+	filter  func(Item) bool
+	dropped int
+	once    sync.Once
+}
+
+// Subscribe registers a listener for new items. Items for which filter
+// returns true, or every item when filter is nil, are sent on the returned
+// channel as soon as the Bot stores them. The Bot never waits for a slow
+// subscriber, when its buffer is full items are dropped according to the
+// Bot's DropPolicy. Calling cancel unsubscribes and closes the channel, it's
+// safe to call more than once.
+func (b *Bot) Subscribe(filter func(Item) bool) (<-chan Item, func()) {
+	size := b.SubscriberBuffer
+	if size <= 0 {
+		size = DefaultSubscriberBuffer
+	}
+
+	s := &subscriber{ch: make(chan Item, size), filter: filter}
+
+	b.subMux.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[*subscriber]bool)
+	}
+	b.subscribers[s] = true
+	b.subMux.Unlock()
+
+	cancel := func() {
+		s.once.Do(func() {
+			b.subMux.Lock()
+			delete(b.subscribers, s)
+			close(s.ch)
+			b.subMux.Unlock()
+		})
+	}
+	return s.ch, cancel
+}
+
+// publish sends items to every subscriber that wants them.
+func (b *Bot) publish(items []Item) {
+	if len(items) == 0 {
+		return
+	}
+
+	b.subMux.Lock()
+	defer b.subMux.Unlock()
+
+	for s := range b.subscribers {
+		for _, item := range items {
+			if s.filter == nil || s.filter(item) {
+				b.deliver(s, item)
+			}
+		}
+	}
+}
+
+// deliver sends item to s without blocking. b.subMux must be held, which
+// also makes the publisher the only sender.
+func (b *Bot) deliver(s *subscriber, item Item) {
+	select {
+	case s.ch <- item:
+		return
+	default:
+	}
+
+	if b.SlowSubscribers == DropOldest {
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- item:
+		default:
+		}
+	}
+
+	s.dropped++
+	if s.dropped == 1 || s.dropped%100 == 0 {
+		logger.Warningf("Subscriber is too slow, %d items dropped\n", s.dropped)
+	}
+}
@@ -0,0 +1,348 @@
+package paperboy
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TrendPolicy configures how the Bot detects trending terms. A term trends
+// when it's in more titles during the last Window than the Baseline before
+// it predicts.
+type TrendPolicy struct {
+	// Window is the recent period terms are counted in.
+	Window time.Duration `yaml:"window"`
+	// Baseline is the period before Window that the expected count is
+	// taken from.
+	Baseline time.Duration `yaml:"baseline"`
+	// MinCount is the number of items a term has to be in during Window
+	// to trend.
+	MinCount int `yaml:"min_count"`
+}
+
+// DefaultTrends compares the last hour with the day before it.
+var DefaultTrends = TrendPolicy{
+	Window:   time.Hour,
+	Baseline: 24 * time.Hour,
+	MinCount: 3,
+}
+
+// Trend is a term that's in more titles than usual.
+type Trend struct {
+	Term string `json:"term"`
+	// Count is the number of items with the term in the window.
+	Count int `json:"count"`
+	// Expected is the count predicted by the baseline.
+	Expected float64 `json:"expected"`
+	// Score is how far Count is above Expected, trends are ordered by it.
+	Score float64 `json:"score"`
+	// Sources are the names of the sources the term was seen on.
+	Sources []string `json:"sources"`
+	// Examples are the most recent items with the term.
+	Examples []Item `json:"examples"`
+}
+
+// maxTrendExamples is the number of example items kept per term and bucket.
+const maxTrendExamples = 3
+
+// trendBuckets is the number of buckets Window is divided into, the window
+// slides a bucket at a time.
+const trendBuckets = 6
+
+// minBaselineBuckets is the number of buckets the baseline needs before
+// anything trends. Without a baseline every term would be above it, like
+// right after the Bot starts.
+const minBaselineBuckets = trendBuckets
+
+// stopwords are words too common in titles to trend.
+var stopwords = makeSet(strings.Fields(`
+	a about after all also am an and any are as at be been before being but
+	by can could did do does doing done for from get gets got had has have
+	how i if in into is it its just like make more most my new no not now
+	of off on one only or our out over says should so some than that the
+	their them then there these they this those to too two up us use using
+	via vs was way we were what when where which who why will with without
+	would you your
+	ask show hn tell launch video update year years day days today week
+	first back here still people time best free really want need made
+`))
+
+// makeSet returns a set of the strings in list.
+func makeSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, s := range list {
+		set[s] = true
+	}
+	return set
+}
+
+// trendTerms returns the terms in title that can trend, each once: words
+// that aren't stopwords or numbers, and pairs of them, so names like "rust
+// foundation" can trend too. Terms are keyed by their stem and map to the
+// word, or words, as written.
+func trendTerms(title string) map[string]string {
+	terms := make(map[string]string)
+	prev, prevStem := "", ""
+	for _, word := range words(normalize(title)) {
+		if len([]rune(word)) < 3 || stopwords[word] || isNumber(word) {
+			prev, prevStem = "", ""
+			continue
+		}
+
+		s := stem(word)
+		if _, ok := terms[s]; !ok {
+			terms[s] = word
+		}
+		if prev != "" {
+			if _, ok := terms[prevStem+" "+s]; !ok {
+				terms[prevStem+" "+s] = prev + " " + word
+			}
+		}
+		prev, prevStem = word, s
+	}
+	return terms
+}
+
+// isNumber reports whether s is all digits.
+func isNumber(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// trendBucket counts the terms of the items first seen in a period.
+type trendBucket struct {
+	start    time.Time
+	counts   map[string]int
+	sources  map[string]map[string]bool
+	examples map[string][]Item
+}
+
+// trendTracker counts terms in buckets, covering the window and the
+// baseline before it.
+type trendTracker struct {
+	policy  TrendPolicy
+	width   time.Duration
+	buckets []*trendBucket
+	// forms maps term keys to how they were last written.
+	forms map[string]string
+}
+
+func newTrendTracker(policy TrendPolicy) *trendTracker {
+	width := policy.Window / trendBuckets
+	if width <= 0 {
+		width = time.Minute
+	}
+	return &trendTracker{
+		policy: policy,
+		width:  width,
+		forms:  make(map[string]string),
+	}
+}
+
+// bucket returns the bucket for at, adding it if it's newer than the others.
+// Items older than the newest bucket are counted in it.
+func (t *trendTracker) bucket(at time.Time) *trendBucket {
+	start := at.Truncate(t.width)
+	if n := len(t.buckets); n > 0 && !start.After(t.buckets[n-1].start) {
+		return t.buckets[n-1]
+	}
+
+	b := &trendBucket{
+		start:    start,
+		counts:   make(map[string]int),
+		sources:  make(map[string]map[string]bool),
+		examples: make(map[string][]Item),
+	}
+	t.buckets = append(t.buckets, b)
+	t.expire(at)
+	return b
+}
+
+// expire drops buckets older than the window and baseline, and the forms
+// of terms that are no longer counted.
+func (t *trendTracker) expire(now time.Time) {
+	cutoff := now.Add(-t.policy.Window - t.policy.Baseline)
+	i := 0
+	for i < len(t.buckets) && t.buckets[i].start.Add(t.width).Before(cutoff) {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	t.buckets = append([]*trendBucket(nil), t.buckets[i:]...)
+
+	for key := range t.forms {
+		counted := false
+		for _, b := range t.buckets {
+			if b.counts[key] > 0 {
+				counted = true
+				break
+			}
+		}
+		if !counted {
+			delete(t.forms, key)
+		}
+	}
+}
+
+// add counts the terms in item's title.
+func (t *trendTracker) add(item Item) {
+	b := t.bucket(item.FirstSeen)
+	for key, form := range trendTerms(item.Title) {
+		t.forms[key] = form
+		b.counts[key]++
+		if b.sources[key] == nil {
+			b.sources[key] = make(map[string]bool)
+		}
+		b.sources[key][item.SourceName] = true
+
+		examples := append(b.examples[key], item)
+		if len(examples) > maxTrendExamples {
+			examples = examples[1:]
+		}
+		b.examples[key] = examples
+	}
+}
+
+// trending returns the n terms that rose the most at now.
+func (t *trendTracker) trending(now time.Time, n int) []Trend {
+	windowStart := now.Add(-t.policy.Window)
+	baselineStart := windowStart.Add(-t.policy.Baseline)
+
+	recent := make(map[string]*Trend)
+	baseline := make(map[string]int)
+	baselineBuckets := 0
+	var oldest time.Time
+	for _, b := range t.buckets {
+		if b.start.Before(baselineStart) || b.start.After(now) {
+			continue
+		}
+		if b.start.Before(windowStart) {
+			if baselineBuckets == 0 {
+				oldest = b.start
+			}
+			baselineBuckets++
+			for key, count := range b.counts {
+				baseline[key] += count
+			}
+			continue
+		}
+
+		for key, count := range b.counts {
+			trend, ok := recent[key]
+			if !ok {
+				trend = &Trend{Term: t.forms[key]}
+				recent[key] = trend
+			}
+			trend.Count += count
+			for source := range b.sources[key] {
+				if !containsString(trend.Sources, source) {
+					trend.Sources = append(trend.Sources, source)
+				}
+			}
+			trend.Examples = append(trend.Examples, b.examples[key]...)
+		}
+	}
+
+	if baselineBuckets < minBaselineBuckets {
+		return []Trend{}
+	}
+
+	// the baseline is scaled down to the length of the window, by the span
+	// it covers so far rather than the configured one, which it only covers
+	// once the Bot has run for that long.
+	covered := windowStart.Sub(oldest)
+	if covered > t.policy.Baseline {
+		covered = t.policy.Baseline
+	}
+	windows := math.Max(1, float64(covered)/float64(t.policy.Window))
+	trends := make([]Trend, 0)
+	for key, trend := range recent {
+		if trend.Count < t.policy.MinCount {
+			continue
+		}
+		trend.Expected = float64(baseline[key]) / windows
+		if float64(trend.Count) <= trend.Expected {
+			continue
+		}
+
+		// like a z-score for a Poisson count, terms on more sources
+		// count a little more.
+		trend.Score = (float64(trend.Count) - trend.Expected) / math.Sqrt(trend.Expected+1) *
+			math.Sqrt(float64(len(trend.Sources)))
+
+		sort.Strings(trend.Sources)
+		sort.Slice(trend.Examples, func(i, j int) bool {
+			return trend.Examples[i].FirstSeen.After(trend.Examples[j].FirstSeen)
+		})
+		if len(trend.Examples) > maxTrendExamples {
+			trend.Examples = trend.Examples[:maxTrendExamples]
+		}
+		trends = append(trends, *trend)
+	}
+
+	// pairs go before their words when they score the same, so
+	// dropSubterms keeps the more specific term.
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Score != trends[j].Score {
+			return trends[i].Score > trends[j].Score
+		}
+		wi, wj := strings.Count(trends[i].Term, " "), strings.Count(trends[j].Term, " ")
+		if wi != wj {
+			return wi > wj
+		}
+		return trends[i].Term < trends[j].Term
+	})
+	return dropSubterms(trends, n)
+}
+
+// dropSubterms returns the first n trends, skipping words that only trend
+// as part of a pair ahead of them, like "log4j" after "log4j vulnerability"
+// with the same count.
+func dropSubterms(trends []Trend, n int) []Trend {
+	top := make([]Trend, 0, n)
+	for _, trend := range trends {
+		if len(top) == n {
+			break
+		}
+		covered := false
+		for _, t := range top {
+			if t.Count == trend.Count && containsString(strings.Fields(t.Term), trend.Term) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			top = append(top, trend)
+		}
+	}
+	return top
+}
+
+// Trending returns up to n terms that are in more new titles during the
+// Trends window than usual, the biggest risers first. Nothing trends until
+// the Bot has seen items for a while before the window.
+func (b *Bot) Trending(n int) []Trend {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.trends == nil {
+		return []Trend{}
+	}
+	now := time.Now()
+	b.trends.expire(now)
+	return b.trends.trending(now, n)
+}
+
+// trackTrends counts the terms of a newly stored item. b.mux must be held.
+func (b *Bot) trackTrends(item Item) {
+	if b.trends == nil || b.trends.policy != b.Trends {
+		b.trends = newTrendTracker(b.Trends)
+	}
+	b.trends.add(item)
+}
@@ -0,0 +1,39 @@
+package paperboy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrendingSteadyRate(t *testing.T) {
+	tr := newTrendTracker(DefaultTrends)
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	// "kubernetes" is in a title every 5 minutes for 2 hours, a day's
+	// baseline would only be covered after 25.
+	var now time.Time
+	for i := 0; i < 24; i++ {
+		now = start.Add(time.Duration(i) * 5 * time.Minute)
+		tr.add(Item{Title: fmt.Sprintf("Kubernetes tip %d", i), URL: fmt.Sprint(i), SourceName: "HN", FirstSeen: now})
+	}
+	for _, trend := range tr.trending(now, 10) {
+		if strings.Contains(trend.Term, "kubernetes") {
+			t.Fatalf("a term at a steady rate trends: %+v", trend)
+		}
+	}
+
+	// a term that takes off in the last window still trends.
+	for i := 0; i < 6; i++ {
+		at := now.Add(-time.Duration(i) * 5 * time.Minute)
+		tr.add(Item{Title: "Rust 2.0 released", URL: fmt.Sprint("rust", i), SourceName: "Reddit", FirstSeen: at})
+	}
+	trends := tr.trending(now, 10)
+	for _, trend := range trends {
+		if trend.Term == "rust" {
+			return
+		}
+	}
+	t.Fatalf("got trends %+v, want rust", trends)
+}
@@ -0,0 +1,234 @@
+package paperboy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/logger"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Watch sends an alert through a notifier as soon as the Bot stores an item
+// matching its query, without waiting for anyone to read their items.
+type Watch struct {
+	Name string `json:"name"`
+	// Query is a search query, see ParseQuery for the syntax.
+	Query string `json:"query"`
+	// Fuzzy lets the query's words match words with typos.
+	Fuzzy bool `json:"fuzzy,omitempty"`
+	// Notifier is the name of the notifier alerts are sent with, see
+	// AddNotifier.
+	Notifier string `json:"notifier"`
+	// To is passed on to the notifier, like the Slack channel to alert.
+	To string `json:"to,omitempty"`
+
+	q Query
+}
+
+// compile validates w and parses its query.
+func (w *Watch) compile() error {
+	if w.Name == "" {
+		return fmt.Errorf("watch has no name")
+	}
+	if w.Notifier == "" {
+		return fmt.Errorf("watch %s has no notifier", w.Name)
+	}
+
+	q, err := SearchOptions{Fuzzy: w.Fuzzy}.Parse(w.Query)
+	if err != nil {
+		return fmt.Errorf("watch %s: %s", w.Name, err)
+	}
+	w.q = q
+	return nil
+}
+
+// String describes the watch in one line.
+func (w Watch) String() string {
+	to := w.Notifier
+	if w.To != "" {
+		to += " " + w.To
+	}
+	fuzzy := ""
+	if w.Fuzzy {
+		fuzzy = " (fuzzy)"
+	}
+	return fmt.Sprintf("%s: %q%s -> %s", w.Name, w.Query, fuzzy, to)
+}
+
+// compileWatches validates watches, returning compiled copies.
+func compileWatches(watches []Watch) ([]Watch, error) {
+	compiled := make([]Watch, len(watches))
+	names := make(map[string]bool)
+	for i, watch := range watches {
+		if err := watch.compile(); err != nil {
+			return nil, err
+		}
+		if names[watch.Name] {
+			return nil, fmt.Errorf("duplicate watch name %s", watch.Name)
+		}
+		names[watch.Name] = true
+		compiled[i] = watch
+	}
+	return compiled, nil
+}
+
+// ReadWatches decodes a json list of watches from r and validates them.
+func ReadWatches(r io.Reader) ([]Watch, error) {
+	watches := make([]Watch, 0)
+	if err := json.NewDecoder(r).Decode(&watches); err != nil {
+		return nil, err
+	}
+	return compileWatches(watches)
+}
+
+// WriteWatches encodes watches to w as a json list.
+func WriteWatches(w io.Writer, watches []Watch) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(watches)
+}
+
+// alert is a notification waiting to be sent.
+type alert struct {
+	notifier string
+	watches  []string
+	n        Notification
+}
+
+// watchItems matches newly stored items against the Bot's watches and
+// returns the alerts to send. Each destination is alerted about an item
+// once, however many of its watches match it. b.mux must be held.
+func (b *Bot) watchItems(items []Item) []*alert {
+	if len(b.watches) == 0 || len(items) == 0 {
+		return nil
+	}
+	if b.alerted == nil {
+		b.alerted = make(map[string]map[string]bool)
+	}
+
+	alerts := make(map[string]*alert)
+	keys := make([]string, 0)
+	for _, item := range items {
+		// a single item index lets watches use the full query syntax.
+		idx := NewIndex()
+		idx.Add(item)
+
+		for i := range b.watches {
+			w := &b.watches[i]
+			if len(w.q.eval(idx)) == 0 {
+				continue
+			}
+
+			key := w.Notifier + "\x00" + w.To
+			a, ok := alerts[key]
+			if !ok {
+				a = &alert{notifier: w.Notifier, n: Notification{To: w.To}}
+				alerts[key] = a
+				keys = append(keys, key)
+			}
+			if !containsString(a.watches, w.Name) {
+				a.watches = append(a.watches, w.Name)
+			}
+			if b.alerted[item.URL][key] {
+				continue
+			}
+
+			if b.alerted[item.URL] == nil {
+				b.alerted[item.URL] = make(map[string]bool)
+			}
+			b.alerted[item.URL][key] = true
+			a.n.Items = append(a.n.Items, item)
+		}
+	}
+
+	list := make([]*alert, 0, len(keys))
+	for _, key := range keys {
+		a := alerts[key]
+		if len(a.n.Items) == 0 {
+			continue
+		}
+		sort.Strings(a.watches)
+		a.n.Subject = fmt.Sprintf("Watchlist %s: %d new items", strings.Join(a.watches, ", "), len(a.n.Items))
+		if len(a.n.Items) == 1 {
+			a.n.Subject = fmt.Sprintf("Watchlist %s: new item", strings.Join(a.watches, ", "))
+		}
+		list = append(list, a)
+	}
+	return list
+}
+
+// sendAlerts delivers alerts, logging the ones that fail. b.mux must not be
+// held.
+func (b *Bot) sendAlerts(alerts []*alert) {
+	for _, a := range alerts {
+		if err := b.Notify(a.notifier, a.n); err != nil {
+			logger.Errorf("Error sending alert for %s: %s\n", strings.Join(a.watches, ", "), err)
+		}
+	}
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SetWatches replaces the Bot's watches. The Bot's watches are left alone if
+// any of watches is invalid.
+func (b *Bot) SetWatches(watches []Watch) error {
+	compiled, err := compileWatches(watches)
+	if err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	b.watches = compiled
+	b.mux.Unlock()
+	return nil
+}
+
+// Watches returns a copy of the Bot's watches.
+func (b *Bot) Watches() []Watch {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return append([]Watch(nil), b.watches...)
+}
+
+// AddWatch adds a watch, replacing any watch with the same name. Only items
+// stored after the watch is added are alerted.
+func (b *Bot) AddWatch(watch Watch) error {
+	if err := watch.compile(); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for i := range b.watches {
+		if b.watches[i].Name == watch.Name {
+			b.watches[i] = watch
+			return nil
+		}
+	}
+	b.watches = append(b.watches, watch)
+	return nil
+}
+
+// RemoveWatch removes the named watch, reporting whether it existed.
+func (b *Bot) RemoveWatch(name string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for i := range b.watches {
+		if b.watches[i].Name == name {
+			b.watches = append(b.watches[:i], b.watches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
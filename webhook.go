@@ -0,0 +1,704 @@
+package paperboy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers set on the requests a Webhook sends. The delivery ID stays the
+// same when a post is retried, so receivers can ignore posts they've
+// already seen.
+const (
+	WebhookSignatureHeader = "X-Paperboy-Signature"
+	WebhookTimestampHeader = "X-Paperboy-Timestamp"
+	WebhookDeliveryHeader  = "X-Paperboy-Delivery"
+)
+
+// Webhook posts notifications to a URL as json.
+type Webhook struct {
+	URL string
+	// Headers are added to every request, like Authorization.
+	Headers map[string]string
+	// Secret signs requests when it's set, see SignWebhook.
+	Secret string
+	// PerItem posts each item of a notification on its own, instead of
+	// the whole notification at once.
+	PerItem bool
+	// Client is the HTTP client requests are sent with, one with a 10
+	// second timeout when it's nil.
+	Client *http.Client
+
+	queue *WebhookQueue
+	// prepared is the queue a webhook built from a config starts using
+	// once it's put to use, see start.
+	prepared  *WebhookQueue
+	startOnce sync.Once
+}
+
+// webhookPayload is the json a Webhook posts.
+type webhookPayload struct {
+	Subject string `json:"subject"`
+	To      string `json:"to,omitempty"`
+	// Text is the notification rendered as plain text.
+	Text  string `json:"text"`
+	Items []Item `json:"items"`
+}
+
+// Notify posts n, or queues it when the webhook has a queue, see
+// StartQueue. Without a queue responses other than 2xx are errors, and
+// when items are posted on their own and only some of them fail, the error
+// is a *PartialError with the ones that failed.
+func (w *Webhook) Notify(n Notification) error {
+	w.start()
+
+	notifications := []Notification{n}
+	if w.PerItem && len(n.Items) > 1 {
+		notifications = make([]Notification, len(n.Items))
+		for i, item := range n.Items {
+			notifications[i] = Notification{Subject: n.Subject, To: n.To, Items: []Item{item}}
+		}
+	}
+
+	posts := make([]QueuedPost, len(notifications))
+	for i, n := range notifications {
+		body, err := json.Marshal(webhookPayload{n.Subject, n.To, n.Text(), n.Items})
+		if err != nil {
+			return err
+		}
+		posts[i] = QueuedPost{ID: newDeliveryID(), Body: body, Items: len(n.Items), Created: time.Now()}
+	}
+
+	if w.queue != nil {
+		return w.queue.add(posts)
+	}
+	var failed []Item
+	var firstErr error
+	for i, p := range posts {
+		if err := w.post(p); err != nil {
+			failed = append(failed, notifications[i].Items...)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil && len(failed) < len(n.Items) {
+		return &PartialError{Undelivered: failed, Err: firstErr}
+	}
+	return firstErr
+}
+
+// post sends p, signed if the webhook has a secret.
+func (w *Webhook) post(p QueuedPost) error {
+	headers := make(map[string]string, len(w.Headers)+3)
+	for name, value := range w.Headers {
+		headers[name] = value
+	}
+	headers[WebhookDeliveryHeader] = p.ID
+	if w.Secret != "" {
+		now := time.Now().Unix()
+		headers[WebhookTimestampHeader] = strconv.FormatInt(now, 10)
+		headers[WebhookSignatureHeader] = SignWebhook(w.Secret, now, p.Body)
+	}
+	return postJSON(w.Client, w.URL, headers, p.Body)
+}
+
+// SignWebhook returns the signature of a webhook request's body sent at
+// timestamp, in Unix seconds. It's "sha256=" followed by the hex HMAC-SHA256
+// of the timestamp, a dot and the body, keyed with secret.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a request sent by a Webhook with
+// secret, and returns its body. Requests signed more than maxAge ago are
+// rejected too, so they can't be replayed, unless maxAge is 0.
+func VerifyWebhook(r *http.Request, secret string, maxAge time.Duration) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("missing or invalid %s header", WebhookTimestampHeader)
+	}
+	if maxAge > 0 && time.Since(time.Unix(timestamp, 0)) > maxAge {
+		return nil, fmt.Errorf("request was signed too long ago")
+	}
+
+	want := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(WebhookSignatureHeader))) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return body, nil
+}
+
+// newDeliveryID returns a random ID for a webhook post.
+func newDeliveryID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
+// webhookStatusError is a response other than 2xx.
+type webhookStatusError struct {
+	url    string
+	code   int
+	status string
+	msg    []byte
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("%s responded %s: %s", e.url, e.status, e.msg)
+}
+
+// permanentFailure reports whether err means a post won't succeed however
+// often it's retried, like when it's rejected as a bad request.
+func permanentFailure(err error) bool {
+	e, ok := err.(*webhookStatusError)
+	if !ok {
+		return false
+	}
+	switch e.code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.code/100 == 4
+}
+
+// postJSON posts body to url, responses other than 2xx are errors.
+func postJSON(client *http.Client, url string, headers map[string]string, body []byte) error {
+	if client == nil {
+		client = notifierClient
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &webhookStatusError{url, resp.StatusCode, resp.Status, bytes.TrimSpace(msg)}
+	}
+	return nil
+}
+
+// RetryPolicy decides how often and how long queued webhook posts are
+// retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a post is tried before it's
+	// given up on and moved to the dead letters.
+	MaxAttempts int `yaml:"max_attempts"`
+	// MinBackoff is how long the first retry waits, each one after it
+	// waits twice as long as the one before, up to MaxBackoff.
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// DefaultWebhookRetry tries a post for about a day before giving up.
+var DefaultWebhookRetry = RetryPolicy{
+	MaxAttempts: 12,
+	MinBackoff:  30 * time.Second,
+	MaxBackoff:  4 * time.Hour,
+}
+
+// backoff returns how long to wait before trying a post again after it
+// failed attempts times.
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// QueuedPost is a webhook post waiting in a WebhookQueue, or given up on.
+type QueuedPost struct {
+	// ID is sent in the X-Paperboy-Delivery header.
+	ID   string          `json:"id"`
+	Body json.RawMessage `json:"body"`
+	// Items is the number of items in the post.
+	Items   int       `json:"items"`
+	Created time.Time `json:"created"`
+	// Attempts is the number of times the post was tried, and Error the
+	// last attempt's error.
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+	// Next is when the post is tried next, it's not set on dead letters.
+	Next time.Time `json:"next,omitempty"`
+}
+
+// WebhookQueue holds a webhook's posts until they're delivered, retrying
+// failed ones with exponential backoff. Posts that fail for good, because
+// they were rejected with a 4xx response or ran out of attempts, are kept
+// as dead letters until they're redelivered or purged. The queue is saved
+// to a json file after every change, so nothing is lost on restart.
+type WebhookQueue struct {
+	path  string
+	retry RetryPolicy
+	// owner is the webhook the queue posts with.
+	owner *Webhook
+	// loaded is set once the file's posts are loaded.
+	loaded  bool
+	pending []QueuedPost
+	dead    []QueuedPost
+	mux     sync.Mutex
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// webhookQueueFile is how a WebhookQueue is saved.
+type webhookQueueFile struct {
+	Pending []QueuedPost `json:"pending"`
+	Dead    []QueuedPost `json:"dead"`
+}
+
+// openQueues are the running queues by file, webhooks rebuilt with the same
+// file take over its queue.
+var (
+	openQueues    = make(map[string]*WebhookQueue)
+	openQueuesMux sync.Mutex
+)
+
+// StartQueue makes the webhook queue its posts in the json file at path,
+// delivering them in the background and retrying failures as retry says.
+// Posts already in the file are picked up. If another webhook has a queue
+// running with the same file, this webhook takes it over. Close stops it.
+func (w *Webhook) StartQueue(path string, retry RetryPolicy) error {
+	q, err := prepareQueue(path, retry)
+	if err != nil {
+		return err
+	}
+	w.activate(q)
+	return nil
+}
+
+// validate checks that the policy allows a post to be tried and waits
+// before retrying it.
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts <= 0 {
+		return fmt.Errorf("max attempts must be more than 0")
+	}
+	if p.MinBackoff <= 0 || p.MaxBackoff < p.MinBackoff {
+		return fmt.Errorf("backoff must be more than 0, and the max at least the min")
+	}
+	return nil
+}
+
+// prepareQueue returns a queue for the file at path that isn't running yet.
+// The file's posts are loaded unless a queue is already running with it,
+// nothing else is done until the queue is activated.
+func prepareQueue(path string, retry RetryPolicy) (*WebhookQueue, error) {
+	if err := retry.validate(); err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &WebhookQueue{
+		path:  abs,
+		retry: retry,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	openQueuesMux.Lock()
+	_, running := openQueues[abs]
+	openQueuesMux.Unlock()
+	if !running {
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+		q.loaded = true
+	}
+	return q, nil
+}
+
+// activate makes the webhook post with q, or with the queue already running
+// with q's file.
+func (w *Webhook) activate(q *WebhookQueue) {
+	openQueuesMux.Lock()
+	defer openQueuesMux.Unlock()
+
+	if running, ok := openQueues[q.path]; ok {
+		running.mux.Lock()
+		running.owner = w
+		running.retry = q.retry
+		running.mux.Unlock()
+		w.queue = running
+		running.poke()
+		return
+	}
+
+	// the queue that was running when q was prepared stopped since.
+	if !q.loaded {
+		if err := q.load(); err != nil {
+			logger.Errorf("Error loading webhook queue %s: %s\n", q.path, err)
+		}
+	}
+	q.owner = w
+	openQueues[q.path] = q
+	w.queue = q
+	go q.run()
+}
+
+// start starts the queue the webhook was built with, see notifierStarter.
+// Notify starts it too, for webhooks that are used without a Bot.
+func (w *Webhook) start() {
+	w.startOnce.Do(func() {
+		if w.prepared != nil && w.queue == nil {
+			w.activate(w.prepared)
+		}
+	})
+}
+
+// Queue returns the webhook's queue, nil if it doesn't have one.
+func (w *Webhook) Queue() *WebhookQueue {
+	return w.queue
+}
+
+// Close stops the webhook's queue, unless another webhook took it over.
+// Queued posts stay in the queue's file.
+func (w *Webhook) Close() error {
+	q := w.queue
+	if q == nil {
+		return nil
+	}
+
+	openQueuesMux.Lock()
+	q.mux.Lock()
+	owned := q.owner == w
+	q.mux.Unlock()
+	if owned {
+		delete(openQueues, q.path)
+	}
+	openQueuesMux.Unlock()
+
+	if owned {
+		close(q.stop)
+		<-q.done
+	}
+	return nil
+}
+
+// load reads the queue's file, if it exists.
+func (q *WebhookQueue) load() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var saved webhookQueueFile
+	if err := json.NewDecoder(f).Decode(&saved); err != nil {
+		return fmt.Errorf("%s: %s", q.path, err)
+	}
+	q.pending, q.dead = saved.Pending, saved.Dead
+	return nil
+}
+
+// save writes the queue to its file. q.mux must be held.
+func (q *WebhookQueue) save() error {
+	return writeFileAtomic(q.path, 0, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(webhookQueueFile{q.pending, q.dead})
+	})
+}
+
+// poke wakes the queue up to deliver what's due.
+func (q *WebhookQueue) poke() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// add queues posts to be delivered right away.
+func (q *WebhookQueue) add(posts []QueuedPost) error {
+	q.mux.Lock()
+	now := time.Now()
+	for _, p := range posts {
+		p.Next = now
+		q.pending = append(q.pending, p)
+	}
+	err := q.save()
+	q.mux.Unlock()
+
+	q.poke()
+	return err
+}
+
+// run delivers posts as they're due until the queue is stopped.
+func (q *WebhookQueue) run() {
+	defer close(q.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-q.wake:
+		case <-q.stop:
+			return
+		}
+
+		next := q.deliverDue(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// deliverDue tries the posts that are due and returns when the next one is,
+// the zero time when nothing is queued.
+func (q *WebhookQueue) deliverDue(now time.Time) time.Time {
+	q.mux.Lock()
+	owner := q.owner
+	due := make([]QueuedPost, 0)
+	for _, p := range q.pending {
+		if !p.Next.After(now) {
+			due = append(due, p)
+		}
+	}
+	q.mux.Unlock()
+
+	results := make(map[string]error, len(due))
+deliver:
+	for _, p := range due {
+		select {
+		case <-q.stop:
+			break deliver
+		default:
+		}
+		results[p.ID] = owner.post(p)
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	kept := q.pending[:0]
+	var next time.Time
+	for _, p := range q.pending {
+		err, tried := results[p.ID]
+		if tried {
+			if err == nil {
+				continue
+			}
+			p.Attempts++
+			p.Error = err.Error()
+			if permanentFailure(err) || p.Attempts >= q.retry.MaxAttempts {
+				logger.Errorf("Error delivering webhook post %s, giving up after %d attempts: %s\n", p.ID, p.Attempts, err)
+				p.Next = time.Time{}
+				q.dead = append(q.dead, p)
+				continue
+			}
+			p.Next = now.Add(q.retry.backoff(p.Attempts))
+		}
+		kept = append(kept, p)
+		if next.IsZero() || p.Next.Before(next) {
+			next = p.Next
+		}
+	}
+	q.pending = kept
+
+	if len(results) > 0 {
+		if err := q.save(); err != nil {
+			logger.Errorf("Error saving webhook queue %s: %s\n", q.path, err)
+		}
+	}
+	return next
+}
+
+// Path returns the queue's file.
+func (q *WebhookQueue) Path() string {
+	return q.path
+}
+
+// Pending returns the posts waiting to be delivered, in the order they were
+// queued.
+func (q *WebhookQueue) Pending() []QueuedPost {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return append([]QueuedPost(nil), q.pending...)
+}
+
+// Dead returns the posts that were given up on, in the order they were
+// given up on.
+func (q *WebhookQueue) Dead() []QueuedPost {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return append([]QueuedPost(nil), q.dead...)
+}
+
+// Redeliver moves the dead letters with the given IDs, or all of them when
+// none are given, back into the queue with their attempts reset. It returns
+// the number of posts it moved.
+func (q *WebhookQueue) Redeliver(ids ...string) (int, error) {
+	q.mux.Lock()
+	now := time.Now()
+	moved := 0
+	kept := q.dead[:0]
+	for _, p := range q.dead {
+		if len(ids) > 0 && !containsString(ids, p.ID) {
+			kept = append(kept, p)
+			continue
+		}
+		p.Attempts, p.Error, p.Next = 0, "", now
+		q.pending = append(q.pending, p)
+		moved++
+	}
+	q.dead = kept
+
+	var err error
+	if moved > 0 {
+		err = q.save()
+	}
+	q.mux.Unlock()
+
+	q.poke()
+	return moved, err
+}
+
+// Purge drops the dead letters with the given IDs, or all of them when none
+// are given. It returns the number of posts it dropped.
+func (q *WebhookQueue) Purge(ids ...string) (int, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	dropped := 0
+	kept := q.dead[:0]
+	for _, p := range q.dead {
+		if len(ids) > 0 && !containsString(ids, p.ID) {
+			kept = append(kept, p)
+			continue
+		}
+		dropped++
+	}
+	q.dead = kept
+
+	if dropped == 0 {
+		return 0, nil
+	}
+	return dropped, q.save()
+}
+
+// WebhookQueues returns the queues of the Bot's webhook notifiers, by
+// notifier name.
+func (b *Bot) WebhookQueues() map[string]*WebhookQueue {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	queues := make(map[string]*WebhookQueue)
+	for name, n := range b.notifiers {
+		if w, ok := n.(*Webhook); ok && w.queue != nil {
+			queues[name] = w.queue
+		}
+	}
+	return queues
+}
+
+// newWebhookNotifier builds "webhook" notifiers, which post to their url.
+// Settings starting with header_ are sent as headers, like
+// header_Authorization. Posts are signed with the secret setting, and sent
+// one per item when per_item is true. With a queue setting, the file posts
+// are queued in, they're retried up to max_attempts times, waiting from
+// min_backoff up to max_backoff between tries.
+func newWebhookNotifier(nc NotifierConfig) (Notifier, error) {
+	if err := requireSettings(nc, "url"); err != nil {
+		return nil, err
+	}
+	w := &Webhook{URL: nc.Settings["url"], Secret: nc.Settings["secret"], Headers: make(map[string]string)}
+	for key, value := range nc.Settings {
+		if strings.HasPrefix(key, "header_") {
+			w.Headers[strings.TrimPrefix(key, "header_")] = value
+		}
+	}
+	if s := nc.Settings["per_item"]; s != "" {
+		perItem, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("per_item: %q isn't true or false", s)
+		}
+		w.PerItem = perItem
+	}
+
+	if nc.Settings["queue"] == "" {
+		return w, nil
+	}
+	retry := DefaultWebhookRetry
+	if s := nc.Settings["max_attempts"]; s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("max_attempts: %q isn't a number", s)
+		}
+		retry.MaxAttempts = n
+	}
+	durations := []struct {
+		key string
+		d   *time.Duration
+	}{
+		{"min_backoff", &retry.MinBackoff},
+		{"max_backoff", &retry.MaxBackoff},
+	}
+	for _, d := range durations {
+		if s := nc.Settings[d.key]; s != "" {
+			v, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", d.key, err)
+			}
+			*d.d = v
+		}
+	}
+	// the queue starts when the notifier is put to use, so building one
+	// for a config that's rejected doesn't take over a running queue.
+	q, err := prepareQueue(nc.Settings["queue"], retry)
+	if err != nil {
+		return nil, err
+	}
+	w.prepared = q
+	return w, nil
+}
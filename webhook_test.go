@@ -0,0 +1,283 @@
+package paperboy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a webhook endpoint that checks signatures made with
+// secret and answers with whatever status respond returns, 200 when it's
+// nil. It records every attempt by delivery ID.
+type webhookReceiver struct {
+	*httptest.Server
+	t       *testing.T
+	secret  string
+	respond func(p webhookPayload, attempt int) int
+
+	mux       sync.Mutex
+	attempts  map[string][]time.Time
+	delivered []webhookPayload
+}
+
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	r := &webhookReceiver{t: t, secret: secret, attempts: make(map[string][]time.Time)}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) serve(w http.ResponseWriter, req *http.Request) {
+	body, err := VerifyWebhook(req, r.secret, time.Minute)
+	if err != nil {
+		r.t.Errorf("receiver: %s", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		r.t.Errorf("receiver: %s", err)
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	id := req.Header.Get(WebhookDeliveryHeader)
+	r.attempts[id] = append(r.attempts[id], time.Now())
+	status := http.StatusOK
+	if r.respond != nil {
+		status = r.respond(p, len(r.attempts[id]))
+	}
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	r.delivered = append(r.delivered, p)
+}
+
+// setRespond replaces the receiver's respond func.
+func (r *webhookReceiver) setRespond(respond func(p webhookPayload, attempt int) int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.respond = respond
+}
+
+// titles returns the titles of the items delivered so far.
+func (r *webhookReceiver) titles() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var titles []string
+	for _, p := range r.delivered {
+		for _, item := range p.Items {
+			titles = append(titles, item.Title)
+		}
+	}
+	return titles
+}
+
+// attemptsOf returns the times a post was tried.
+func (r *webhookReceiver) attemptsOf(id string) []time.Time {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]time.Time(nil), r.attempts[id]...)
+}
+
+// eventually fails t if cond doesn't hold within d.
+func eventually(t *testing.T, d time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(d)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't happen within %s", what, d)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"subject":"x"}`)
+	request := func(secret string, signed time.Time, body []byte) *http.Request {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(signed.Unix(), 10))
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, signed.Unix(), body))
+		return req
+	}
+
+	got, err := VerifyWebhook(request("s3cret", time.Now(), body), "s3cret", time.Minute)
+	if err != nil {
+		t.Fatalf("valid request: %s", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("got body %q, want %q", got, body)
+	}
+
+	tampered := request("s3cret", time.Now(), body)
+	tampered.Body = httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"subject":"y"}`))).Body
+	stale := time.Now().Add(-time.Hour)
+	missing := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	for what, req := range map[string]*http.Request{
+		"wrong secret":      request("other", time.Now(), body),
+		"tampered body":     tampered,
+		"stale signature":   request("s3cret", stale, body),
+		"missing timestamp": missing,
+	} {
+		if _, err := VerifyWebhook(req, "s3cret", time.Minute); err == nil {
+			t.Errorf("%s: verified", what)
+		}
+	}
+
+	// an old signature is fine when the age isn't checked.
+	if _, err := VerifyWebhook(request("s3cret", stale, body), "s3cret", 0); err != nil {
+		t.Errorf("stale signature with no max age: %s", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	for attempts, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 9: 10 * time.Second} {
+		if want == 0 {
+			continue
+		}
+		if got := p.backoff(attempts); got != want {
+			t.Errorf("backoff after %d attempts is %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookQueue(t *testing.T) {
+	r := newWebhookReceiver(t, "s3cret")
+	path := filepath.Join(t.TempDir(), "queue.json")
+	retry := RetryPolicy{MaxAttempts: 3, MinBackoff: 50 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+
+	// a post that fails twice is retried with backoff under the same ID.
+	r.setRespond(func(p webhookPayload, attempt int) int {
+		if attempt < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	w := &Webhook{URL: r.URL, Secret: "s3cret"}
+	if err := w.StartQueue(path, retry); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Notify(Notification{Subject: "s", Items: []Item{{Title: "a", URL: "https://example.com/a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	id := w.Queue().Pending()[0].ID
+	eventually(t, 3*time.Second, "delivering a post after 2 failures", func() bool { return len(r.titles()) == 1 })
+	tries := r.attemptsOf(id)
+	if len(tries) != 3 {
+		t.Fatalf("post was tried %d times, want 3", len(tries))
+	}
+	// attempts are timed when they arrive, a little after the queue timed
+	// them.
+	const slack = 10 * time.Millisecond
+	if d := tries[1].Sub(tries[0]); d < retry.MinBackoff-slack {
+		t.Errorf("first retry came after %s, want about %s", d, retry.MinBackoff)
+	}
+	if d := tries[2].Sub(tries[1]); d < retry.backoff(2)-slack {
+		t.Errorf("second retry came after %s, want about %s", d, retry.backoff(2))
+	}
+	eventually(t, time.Second, "dropping a delivered post", func() bool { return len(w.Queue().Pending()) == 0 })
+
+	// posts that run out of attempts, or are rejected, are dead letters.
+	r.setRespond(func(p webhookPayload, attempt int) int {
+		if p.Items[0].Title == "rejected" {
+			return http.StatusBadRequest
+		}
+		return http.StatusServiceUnavailable
+	})
+	w.Notify(Notification{Subject: "s", Items: []Item{{Title: "failing", URL: "https://example.com/f"}}})
+	w.Notify(Notification{Subject: "s", Items: []Item{{Title: "rejected", URL: "https://example.com/r"}}})
+	eventually(t, 3*time.Second, "giving up on 2 posts", func() bool { return len(w.Queue().Dead()) == 2 })
+	for _, p := range w.Queue().Dead() {
+		want := retry.MaxAttempts
+		if bytes.Contains(p.Body, []byte("rejected")) {
+			want = 1
+		}
+		if p.Attempts != want || len(r.attemptsOf(p.ID)) != want {
+			t.Errorf("dead letter %s was tried %d times, want %d", p.Body, p.Attempts, want)
+		}
+		if p.Error == "" {
+			t.Errorf("dead letter %s has no error", p.Body)
+		}
+	}
+
+	// the dead letters survive a restart, and are delivered when they're
+	// redelivered.
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w = &Webhook{URL: r.URL, Secret: "s3cret"}
+	if err := w.StartQueue(path, retry); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	dead := w.Queue().Dead()
+	if len(dead) != 2 {
+		t.Fatalf("%d dead letters after a restart, want 2", len(dead))
+	}
+	r.setRespond(nil)
+	if n, err := w.Queue().Redeliver(dead[0].ID); n != 1 || err != nil {
+		t.Fatalf("redelivered %d posts: %v", n, err)
+	}
+	eventually(t, 3*time.Second, "delivering a redelivered post", func() bool { return len(r.titles()) == 2 })
+	if tries := r.attemptsOf(dead[0].ID); len(tries) != dead[0].Attempts+1 {
+		t.Errorf("redelivered post was tried %d times, want %d", len(tries), dead[0].Attempts+1)
+	}
+
+	if n, err := w.Queue().Purge(); n != 1 || err != nil {
+		t.Fatalf("purged %d posts: %v", n, err)
+	}
+	if n := len(w.Queue().Dead()); n != 0 {
+		t.Fatalf("%d dead letters after purging", n)
+	}
+}
+
+func TestWebhookPerItemRetry(t *testing.T) {
+	// b fails the first two times it's posted.
+	r := newWebhookReceiver(t, "s3cret")
+	failures := 0
+	r.setRespond(func(p webhookPayload, attempt int) int {
+		if p.Items[0].Title == "b" && failures < 2 {
+			failures++
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	b := NewBot(nil)
+	b.AddNotifier("hook", &Webhook{URL: r.URL, Secret: "s3cret", PerItem: true})
+	s := Sink{Name: "all", Notifier: "hook", Retries: 2, RetryDelay: time.Millisecond}
+	items := []Item{{Title: "a", URL: "https://example.com/a"}, {Title: "b", URL: "https://example.com/b"}, {Title: "c", URL: "https://example.com/c"}}
+
+	err := b.Notify("hook", Notification{Subject: "s", Items: items[:2]})
+	partial, ok := err.(*PartialError)
+	if !ok {
+		t.Fatalf("got %v, want a *PartialError", err)
+	}
+	if len(partial.Undelivered) != 1 || partial.Undelivered[0].Title != "b" {
+		t.Fatalf("undelivered items are %v, want b", partial.Undelivered)
+	}
+
+	// a sink only retries the items that weren't delivered.
+	b.deliverToSink(&sinkRunner{sink: s, stop: make(chan struct{})}, Notification{Subject: "s", Items: items})
+	got := r.titles()
+	want := []string{"a", "a", "c", "b"}
+	if len(got) != len(want) {
+		t.Fatalf("delivered %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("delivered %v, want %v", got, want)
+		}
+	}
+	if d := b.Deliveries(); len(d) != 1 || d[0].Attempts != 2 || d[0].Error != "" {
+		t.Fatalf("delivery log is %+v", d)
+	}
+}